@@ -3,26 +3,31 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
-	"math/rand"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	_ "net/http/pprof"
 
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/analytics"
 	grpc_api "github.com/vanamelnik/go-musthave-shortener/internal/app/api/grpc"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/api/rest"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/config"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/dataloader"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/health"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/lifecycle"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/metrics"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/shortener"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/boltdb"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/inmem"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/postgres"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/tracing"
 	"golang.org/x/crypto/acme/autocert"
 )
 
@@ -41,110 +46,224 @@ var (
 func main() {
 	displayVersionInfo()
 
-	flags := config.GetFlags()
-	configFileName, ok := os.LookupEnv("CONFIG")
-	if !ok { // если переменная окружения CONFIG не установлена
-		if flags.ConfigFileName != nil { // смотрим, не задано ли имя файла конфигурации флагом
-			configFileName = *flags.ConfigFileName
-		} else {
-			configFileName = config.DefaultCfgFileName // если нет, то используем значение по умолчанию
-		}
+	if len(os.Args) > 1 && os.Args[1] == migrateCmd {
+		runMigrate(os.Args[2:])
+		return
 	}
-	cfg := config.NewConfig( // порядок имеет значение
-		config.WithFile(configFileName),
-		config.WithFlags(flags),
-		config.WithEnv(), // наивысший приоритет у переменных окружения
-	)
-	err := cfg.Validate()
+
+	cfg := loadConfig(config.GetFlags())
+	log := newLogger(cfg)
+	log.Infof("Server configuration: %s", cfg)
+
+	if err := run(cfg, log); err != nil {
+		// Fatal завершает процесс с ненулевым кодом возврата
+		log.WithError(err).Fatal("Shortener stopped with errors")
+	}
+	log.Info("Shortener stopped")
+}
+
+// run создаёт компоненты сервиса, запускает серверы и блокируется до получения сигнала завершения.
+// Компоненты регистрируются в менеджере жизненного цикла по мере создания, поэтому при остановке
+// они закрываются в обратном порядке: сначала DataLoader и Recorder сливают данные в хранилище,
+// затем закрывается хранилище и в последнюю очередь отправляются спаны трассировки.
+func run(cfg config.Config, log *logrus.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer stop()
+	m := lifecycle.New(cfg.ShutdownTimeout, log)
+	// abort освобождает ресурсы уже созданных компонентов, если запуск сервиса прерван.
+	abort := func(err error) error {
+		m.Close() //nolint:errcheck // ошибки закрытия записываются в лог менеджером
+
+		return err
+	}
+
+	shutdownTracing, err := tracing.Setup(cfg.TraceExporter, cfg.OTLPEndpoint)
 	if err != nil {
-		log.Fatalf("config: %s", err)
+		return err
 	}
-	log.Printf("Server configuration: %s", cfg)
+	m.AddCloser("tracing", shutdownTracing)
 
-	rand.Seed(time.Now().UnixNano())
+	keys, err := shortener.NewKeyGenerator(cfg.KeyGenerator, cfg.KeyAlphabet, cfg.KeyLength)
+	if err != nil {
+		return abort(err)
+	}
+	normalizer, err := newURLNormalizer(cfg)
+	if err != nil {
+		return abort(err)
+	}
+	opts := []shortener.Option{
+		shortener.WithKeyGenerator(keys),
+		shortener.WithURLNormalizer(normalizer),
+		shortener.WithLogger(log),
+	}
+	if cfg.BlocklistFile != "" || cfg.AllowlistFile != "" {
+		policy, err := shortener.NewPolicy(cfg.BlocklistFile, cfg.AllowlistFile, cfg.PolicyReloadInterval,
+			shortener.WithPolicyLogger(log))
+		if err != nil {
+			return abort(err)
+		}
+		m.AddCloser("policy", lifecycle.Func(policy.Close))
+		opts = append(opts, shortener.WithPolicy(policy))
+	}
 
+	checker := health.NewChecker()
 	var db storage.Storage
 	switch cfg.DBType {
 	case config.DBInmem:
-		log.Println("Connecting to in-memory storage...")
-		db, err = inmem.NewDB(cfg.StorageFileName, cfg.InmemFlushInterval)
+		log.Info("Connecting to in-memory storage...")
+		var mem *inmem.DB
+		mem, err = inmem.NewDB(cfg.StorageFileName, cfg.InmemFlushInterval, inmem.WithLogger(log))
+		if err == nil {
+			db = mem
+			checker.Add("snapshot", health.Snapshot(mem.LastFlush))
+		}
 	case config.DBPostgres:
-		log.Print("Connecting to Postgres engine...")
-		db, err = postgres.NewRepo(context.Background(), cfg.DSN)
+		log.Info("Connecting to Postgres engine...")
+		db, err = postgres.NewRepo(context.Background(), cfg.DSN, postgres.WithLogger(log))
+	case config.DBBolt:
+		log.Info("Connecting to bbolt storage...")
+		db, err = boltdb.NewDB(cfg.StorageFileName, boltdb.WithLogger(log))
 	}
 	if err != nil {
-		log.Fatalf("Connect to db failed: %v", err)
+		return abort(fmt.Errorf("connect to db failed: %w", err))
 	}
-	defer db.Close()
+	db = tracing.InstrumentStorage(metrics.InstrumentStorage(db))
+	m.AddCloser("storage", lifecycle.Func(db.Close))
+	checker.Add("storage", health.Ping(db.Ping))
+
+	dl := dataloader.NewDataLoader(context.Background(), db.BatchDelete, cfg.DeleteFlushInterval,
+		dataloader.WithLogger(log))
+	m.AddCloser("DataLoader", lifecycle.Func(dl.Close))
+	checker.Add("dataloader", health.Backlog(dl.Backlog))
+
+	clicks := analytics.NewRecorder(context.Background(), db.StoreClicks, cfg.ClickFlushInterval,
+		analytics.WithLogger(log))
+	m.AddCloser("Recorder", lifecycle.Func(clicks.Close))
+
+	opts = append(opts, shortener.WithClickRecorder(clicks))
+	s := shortener.NewShortener(cfg.BaseURL, db, dl, opts...)
+
+	if cfg.GRPCPort != "" {
+		grpcServer := grpc_api.NewServer(s,
+			grpc_api.Metrics(), grpc_api.Tracing(), grpc_api.Logging(log), grpc_api.Auth(cfg.Secret),
+			grpc_api.RateLimits(cfg))
+		healthServer := grpc_api.RegisterHealthServer(grpcServer)
+		checker.Add("grpc", health.GRPC(healthServer))
+		grpcShutdown := lifecycle.GRPCShutdown(grpcServer)
+		m.AddServer("gRPC", func() error {
+			listen, err := net.Listen("tcp", cfg.GRPCPort)
+			if err != nil {
+				return err
+			}
+			log.Infof("gRPC server is listening at %s", cfg.GRPCPort)
 
-	dl := dataloader.NewDataLoader(context.Background(), db.BatchDelete, cfg.DeleteFlushInterval)
-	defer dl.Close()
+			return grpcServer.Serve(listen)
+		}, func(ctx context.Context) error {
+			healthServer.Shutdown() // статус NOT_SERVING на время завершения обрабатываемых вызовов
+
+			return grpcShutdown(ctx)
+		})
+	}
 
-	s := shortener.NewShortener(cfg.BaseURL, db, dl)
 	router := mux.NewRouter()
-	rest := rest.NewRest(s)
+	rest := rest.NewRest(s, rest.WithLogger(log), rest.WithHealthChecker(checker), rest.WithTrustedProxy(cfg.TrustedProxy))
 	rest.SetupRoutes(cfg, router)
 
-	server := http.Server{
+	server := &http.Server{
 		Addr:    cfg.SrvAddr,
 		Handler: router,
 	}
+	m.AddServer("HTTP", func() error {
+		log.Info("Shortener server is listening at " + cfg.SrvAddr)
 
-	sigint := make(chan os.Signal, 1)
-	signal.Notify(sigint, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+		return listenAndServe(server, cfg)
+	}, server.Shutdown)
 
-	go runMainServer(&server, cfg)
-	log.Println("Shortener server is listening at " + cfg.SrvAddr)
+	if cfg.PprofAddress != "" {
+		pprof := &http.Server{Addr: cfg.PprofAddress, Handler: http.DefaultServeMux}
+		m.AddServer("pprof", func() error {
+			log.Infof("pprof server is listening at %s", cfg.PprofAddress)
 
-	go runPprofServer(cfg.PprofAddress)
+			return pprof.ListenAndServe()
+		}, pprof.Shutdown)
+	}
 
-	go runGRPCServer(cfg.GRPCPort, s)
+	if cfg.MetricsAddress != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		metricsServer := &http.Server{Addr: cfg.MetricsAddress, Handler: metricsMux}
+		m.AddServer("metrics", func() error {
+			log.Infof("metrics server is listening at %s", cfg.MetricsAddress)
 
-	<-sigint
-	log.Println("Shutting down... ")
-	if err := server.Shutdown(context.Background()); err != nil {
-		log.Println(err)
+			return metricsServer.ListenAndServe()
+		}, metricsServer.Shutdown)
 	}
+
+	return m.Run(ctx)
 }
 
-func runMainServer(server *http.Server, cfg config.Config) {
-	if !cfg.EnableHTTPS {
-		log.Println(server.ListenAndServe())
-		return
+// loadConfig формирует конфигурацию сервиса из файла, флагов и переменных окружения.
+func loadConfig(flags config.AppFlags) config.Config {
+	configFileName, ok := os.LookupEnv("CONFIG")
+	if !ok { // если переменная окружения CONFIG не установлена
+		if flags.ConfigFileName != nil { // смотрим, не задано ли имя файла конфигурации флагом
+			configFileName = *flags.ConfigFileName
+		} else {
+			configFileName = config.DefaultCfgFileName // если нет, то используем значение по умолчанию
+		}
 	}
-	manager := &autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		Cache:      autocert.DirCache("cache-dir"),
-		HostPolicy: autocert.HostWhitelist(defaultHost, "www."+defaultHost),
+	cfg := config.NewConfig( // порядок имеет значение
+		config.WithFile(configFileName),
+		config.WithFlags(flags),
+		config.WithEnv(), // наивысший приоритет у переменных окружения
+	)
+	if err := cfg.Validate(); err != nil {
+		logrus.Fatalf("config: %s", err)
 	}
-	server.TLSConfig = manager.TLSConfig()
-	log.Println(server.ListenAndServeTLS("", ""))
+
+	return cfg
 }
 
-func runPprofServer(pprofAddress string) {
-	if pprofAddress == "" {
-		return
-	}
-	log.Printf("pprof server is listening at %s", pprofAddress)
-	err := http.ListenAndServe(pprofAddress, nil)
+// newLogger создаёт логгер с уровнем и форматом из конфигурации.
+func newLogger(cfg config.Config) *logrus.Logger {
+	log, err := logger.New(cfg.LogLevel, cfg.LogFormat)
 	if err != nil {
-		log.Printf("pprof server: %s", err)
+		logrus.Fatalf("config: %v", err)
 	}
+
+	return log
 }
 
-func runGRPCServer(gRPCPort string, s *shortener.Shortener) {
-	if gRPCPort == "" {
-		return
+// newURLNormalizer создаёт нормализатор URL с параметрами из конфигурации.
+func newURLNormalizer(cfg config.Config) (*shortener.URLNormalizer, error) {
+	var opts []shortener.NormalizerOption
+	if cfg.SortQueryParams {
+		opts = append(opts, shortener.WithSortedQuery())
 	}
-	server := grpc_api.NewServer(s)
-	listen, err := net.Listen("tcp", gRPCPort)
-	if err != nil {
-		log.Fatal(err)
+	if cfg.StripURLFragment {
+		opts = append(opts, shortener.WithoutFragment())
+	}
+	if cfg.StripTrailingSlash {
+		opts = append(opts, shortener.WithoutTrailingSlash())
+	}
+
+	return shortener.NewURLNormalizer(cfg.AllowedSchemes, opts...)
+}
+
+// listenAndServe запускает основной сервер по HTTP или, если это указано в конфигурации, по HTTPS
+// с сертификатом Let's Encrypt.
+func listenAndServe(server *http.Server, cfg config.Config) error {
+	if !cfg.EnableHTTPS {
+		return server.ListenAndServe()
 	}
-	log.Printf("gRPC server is listening at %s", gRPCPort)
-	if err := server.Serve(listen); err != nil {
-		log.Printf("gRPC server: %s", err)
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache("cache-dir"),
+		HostPolicy: autocert.HostWhitelist(defaultHost, "www."+defaultHost),
 	}
+	server.TLSConfig = manager.TLSConfig()
+
+	return server.ListenAndServeTLS("", "")
 }
 
 func displayVersionInfo() {
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/config"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/postgres"
+)
+
+// migrateCmd - подкоманда управления миграциями схемы PostgreSQL.
+const migrateCmd = "migrate"
+
+const migrateUsage = `usage: shortener migrate up|down [steps]|status [flags]
+	up		apply all pending migrations
+	down [steps]	revert the last <steps> applied migrations (default 1)
+	status		list migrations and their state`
+
+// runMigrate выполняет подкоманду migrate. Конфигурация (DSN) формируется так же, как при запуске сервера.
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		logrus.Fatal(migrateUsage)
+	}
+	action, args := args[0], args[1:]
+	steps := 1
+	if action == "down" && len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			steps = n
+			args = args[1:]
+		}
+	}
+
+	cfg := loadConfig(config.ParseFlags(args))
+	log := newLogger(cfg)
+	if cfg.DBType != config.DBPostgres {
+		log.Fatal("migrate: migrations are only supported for the postgres storage, set database DSN")
+	}
+	ctx := context.Background()
+	repo, err := postgres.Open(ctx, cfg.DSN, postgres.WithLogger(log))
+	if err != nil {
+		log.Fatalf("migrate: %v", err)
+	}
+	defer repo.Close()
+
+	switch action {
+	case "up":
+		err = repo.MigrateUp(ctx)
+	case "down":
+		err = repo.MigrateDown(ctx, steps)
+	case "status":
+		err = printMigrationStatus(ctx, repo)
+	default:
+		err = fmt.Errorf("unknown action %q\n%s", action, migrateUsage)
+	}
+	if err != nil {
+		repo.Close()
+		log.Fatalf("migrate: %v", err)
+	}
+}
+
+// printMigrationStatus выводит список миграций и время их применения.
+func printMigrationStatus(ctx context.Context, repo *postgres.Repo) error {
+	status, err := repo.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, ms := range status {
+		appliedAt := "pending"
+		if ms.Applied() {
+			appliedAt = ms.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", ms.Version, ms.Name, appliedAt)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,149 @@
+// Пакет analytics собирает статистику переходов по коротким ссылкам.
+package analytics
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+const (
+	// clickChanSize - размер канала с очередью переходов на сохранение.
+	clickChanSize = 1000
+	// maxBatchSize - количество накопленных переходов, при котором они сохраняются, не дожидаясь тикера.
+	maxBatchSize = 500
+)
+
+type (
+	// Recorder асинхронно сохраняет переходы по коротким ссылкам. Функция Record отправляет переход по каналу
+	// агрегатору, который накапливает переходы и по истечении интервала <interval> (или при накоплении
+	// maxBatchSize переходов) передаёт их функции storeFunc, переданной конструктору.
+	// Запись перехода никогда не блокирует обработку запроса: если очередь переполнена, переход отбрасывается.
+	Recorder struct {
+		ctx context.Context
+		// storeFunc - функция StoreClicks из интерфейса storage.Storage.
+		storeFunc StoreClicksFunc
+		interval  time.Duration
+
+		// clickCh - канал, по которому агрегатору отправляются переходы.
+		clickCh chan storage.Click
+		// stopCh - канал для закрытия сервиса.
+		stopCh chan struct{}
+		// doneCh закрывается агрегатором после сохранения оставшихся переходов.
+		doneCh chan struct{}
+
+		log logrus.FieldLogger
+	}
+
+	// Option - параметр Recorder.
+	Option func(*Recorder)
+
+	// StoreClicksFunc - функция интерфейса storage, вызываемая агрегатором для сохранения переходов.
+	StoreClicksFunc func(ctx context.Context, clicks []storage.Click) error
+)
+
+// NewRecorder создаёт и запускает сервис Recorder.
+func NewRecorder(ctx context.Context, storeFunc StoreClicksFunc, interval time.Duration, opts ...Option) *Recorder {
+	rec := &Recorder{
+		ctx:       ctx,
+		storeFunc: storeFunc,
+		interval:  interval,
+		clickCh:   make(chan storage.Click, clickChanSize),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+		log:       logger.Default(),
+	}
+	for _, opt := range opts {
+		opt(rec)
+	}
+	rec.log = rec.log.WithField(logger.FieldComponent, "analytics")
+	go rec.aggregator()
+	rec.log.Info("Recorder started")
+
+	return rec
+}
+
+// WithLogger задаёт логгер сервиса.
+func WithLogger(l logrus.FieldLogger) Option {
+	return func(rec *Recorder) {
+		rec.log = l
+	}
+}
+
+// Record ставит переход в очередь на сохранение.
+func (rec *Recorder) Record(click storage.Click) {
+	select {
+	case rec.clickCh <- click:
+	default:
+		rec.log.WithField("key", click.Key).Warn("queue is full, click dropped")
+	}
+}
+
+// Close останавливает сервис, предварительно сохранив все накопленные переходы.
+func (rec *Recorder) Close() {
+	close(rec.stopCh)
+	<-rec.doneCh
+	rec.log.Info("Recorder closed")
+}
+
+// aggregator накапливает переходы и передаёт их функции storeFunc.
+func (rec *Recorder) aggregator() {
+	defer close(rec.doneCh)
+	ticker := time.NewTicker(rec.interval)
+	defer ticker.Stop()
+
+	batch := make([]storage.Click, 0, maxBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := rec.storeFunc(rec.ctx, batch); err != nil {
+			rec.log.WithError(err).Errorf("could not store %d clicks", len(batch))
+		}
+		batch = make([]storage.Click, 0, maxBatchSize)
+	}
+	for {
+		select {
+		case click := <-rec.clickCh:
+			batch = append(batch, click)
+			if len(batch) >= maxBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-rec.stopCh:
+			// сохраняем переходы, оставшиеся в очереди
+			for {
+				select {
+				case click := <-rec.clickCh:
+					batch = append(batch, click)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// CoarseIP возвращает адрес подсети клиента: /24 для IPv4 и /48 для IPv6. Параметр addr может содержать порт.
+// Если адрес не удаётся разобрать, возвращается пустая строка.
+func CoarseIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,54 @@
+package analytics_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/analytics"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+// TestRecorder проверяет, что все переходы, поставленные в очередь, сохраняются при закрытии сервиса.
+func TestRecorder(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		stored []storage.Click
+	)
+	storeFunc := func(ctx context.Context, clicks []storage.Click) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stored = append(stored, clicks...)
+		return nil
+	}
+
+	rec := analytics.NewRecorder(context.Background(), storeFunc, time.Hour)
+	for i := 0; i < 700; i++ { // больше maxBatchSize - часть переходов сохраняется до закрытия
+		rec.Record(storage.Click{Key: "key1", Time: time.Now()})
+	}
+	rec.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, stored, 700)
+}
+
+func TestCoarseIP(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: "192.168.1.117:53412", want: "192.168.1.0"},
+		{addr: "10.0.0.1", want: "10.0.0.0"},
+		{addr: "[2001:db8:85a3:8d3:1319:8a2e:370:7348]:443", want: "2001:db8:85a3::"},
+		{addr: "2001:db8:85a3:8d3:1319:8a2e:370:7348", want: "2001:db8:85a3::"},
+		{addr: "bufconn", want: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.addr, func(t *testing.T) {
+			assert.Equal(t, tc.want, analytics.CoarseIP(tc.addr))
+		})
+	}
+}
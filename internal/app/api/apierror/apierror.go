@@ -0,0 +1,99 @@
+// Пакет apierror сопоставляет ошибки сервиса с ответами API: кодами ответа REST API, статусами
+// gRPC API v2 и текстами ошибок, которые возвращаются клиенту во всех API. Все API используют одну таблицу
+// соответствия, поэтому одна и та же ошибка везде описывается одинаково.
+package apierror
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/dataloader"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/qrcode"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/shortener"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	"google.golang.org/grpc/codes"
+)
+
+// Тексты ошибок, возвращаемые клиенту.
+const (
+	MsgInternal           = "Something went wrong"
+	MsgWrongID            = "Incorrect ID"
+	MsgWrongURL           = "Wrong URL"
+	MsgWrongAlias         = "Wrong alias"
+	MsgNotFound           = "URL not found"
+	MsgDeleted            = "URL was deleted"
+	MsgExpired            = "URL has expired"
+	MsgWrongExpiry        = "Expiration time must be in the future"
+	MsgForbidden          = "Destination is not allowed"
+	MsgWrongTitle         = "Title is too long"
+	MsgWrongQRCodeOptions = "Wrong QR code parameters"
+	MsgUnavailable        = "Service is temporarily unavailable"
+	MsgStopping           = "Service is stopping"
+	MsgURLAlreadyExists   = "URL already exists"
+)
+
+// ErrInvalidUserID - неверный ID пользователя в запросе.
+var ErrInvalidUserID = errors.New("incorrect user ID")
+
+type (
+	// Response описывает ответ API на ошибку сервиса.
+	Response struct {
+		// HTTPStatus - код ответа REST API.
+		HTTPStatus int
+		// Code - код статуса gRPC API v2.
+		Code codes.Code
+		// Reason - код причины ошибки в подробностях google.rpc.ErrorInfo статуса API v2.
+		Reason string
+		// Message - текст ошибки, возвращаемый клиенту.
+		Message string
+		// Field - поле запроса, указываемое в подробностях google.rpc.BadRequest (для кода InvalidArgument).
+		Field string
+	}
+
+	// mapping - строка таблицы соответствия ошибок ответам.
+	mapping struct {
+		err error
+		Response
+	}
+)
+
+// Internal - ответ на ошибку, не найденную в таблице. Внутренние ошибки сервиса клиенту не раскрываются.
+var Internal = Response{HTTPStatus: http.StatusInternalServerError, Code: codes.Internal, Reason: "INTERNAL", Message: MsgInternal}
+
+// table - соответствие ошибок сервиса ответам API. Ошибки проверяются по порядку с помощью errors.Is.
+// Ошибка storage.ErrURLArlreadyExists обрабатывается в API отдельно, т.к. в ответе возвращается существующий адрес.
+var table = []mapping{
+	{ErrInvalidUserID, Response{http.StatusBadRequest, codes.InvalidArgument, "INVALID_USER_ID", MsgWrongID, "user_id"}},
+	{shortener.ErrInvalidURL, Response{http.StatusBadRequest, codes.InvalidArgument, "INVALID_URL", MsgWrongURL, "url"}},
+	{shortener.ErrInvalidAlias, Response{http.StatusBadRequest, codes.InvalidArgument, "INVALID_ALIAS", MsgWrongAlias, "alias"}},
+	{shortener.ErrInvalidExpiry, Response{http.StatusBadRequest, codes.InvalidArgument, "INVALID_EXPIRY", MsgWrongExpiry, "expires_at"}},
+	{shortener.ErrInvalidTitle, Response{http.StatusBadRequest, codes.InvalidArgument, "INVALID_TITLE", MsgWrongTitle, "title"}},
+	{qrcode.ErrInvalidOptions, Response{http.StatusBadRequest, codes.InvalidArgument, "INVALID_QR_CODE_OPTIONS", MsgWrongQRCodeOptions, ""}},
+	{shortener.ErrForbiddenDestination, Response{http.StatusUnprocessableEntity, codes.PermissionDenied, "DESTINATION_FORBIDDEN", MsgForbidden, ""}},
+	{storage.ErrNotFound, Response{http.StatusNotFound, codes.NotFound, "URL_NOT_FOUND", MsgNotFound, ""}},
+	{storage.ErrDeleted, Response{http.StatusGone, codes.FailedPrecondition, "URL_DELETED", MsgDeleted, ""}},
+	{storage.ErrExpired, Response{http.StatusGone, codes.FailedPrecondition, "URL_EXPIRED", MsgExpired, ""}},
+	{storage.ErrBatchURLUniqueViolation, Response{http.StatusConflict, codes.AlreadyExists, "URL_ALREADY_EXISTS",
+		storage.ErrBatchURLUniqueViolation.Error(), ""}},
+	{storage.ErrKeyCollision, Response{http.StatusConflict, codes.AlreadyExists, "ALIAS_ALREADY_EXISTS",
+		storage.ErrKeyCollision.Error(), ""}},
+	{storage.ErrUnavailable, Response{http.StatusServiceUnavailable, codes.Unavailable, "STORAGE_UNAVAILABLE", MsgUnavailable, ""}},
+	{dataloader.ErrClosed, Response{http.StatusServiceUnavailable, codes.Unavailable, "SERVICE_STOPPING", MsgStopping, ""}},
+}
+
+// For возвращает ответ API на ошибку err. Для ошибок, не найденных в таблице, возвращается Internal.
+func For(err error) Response {
+	for _, m := range table {
+		if errors.Is(err, m.err) {
+			return m.Response
+		}
+	}
+
+	return Internal
+}
+
+// ServerError сообщает, является ли ответ r ответом на внутреннюю ошибку или недоступность сервиса,
+// которые записываются в лог с уровнем error.
+func (r Response) ServerError() bool {
+	return r.HTTPStatus >= http.StatusInternalServerError
+}
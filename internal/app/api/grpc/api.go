@@ -4,15 +4,28 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/analytics"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/api/apierror"
 	pb "github.com/vanamelnik/go-musthave-shortener/internal/app/api/grpc/proto"
+	pbv2 "github.com/vanamelnik/go-musthave-shortener/internal/app/api/grpc/proto/v2"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/config"
+	appContext "github.com/vanamelnik/go-musthave-shortener/internal/app/context"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/qrcode"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/shortener"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
 	"github.com/vanamelnik/go-musthave-shortener/pkg/middleware"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type server struct {
@@ -20,13 +33,69 @@ type server struct {
 	shortener *shortener.Shortener
 }
 
-// NewServer создаёт новый gRPC сервер и регистрирует хендлеры.
-func NewServer(shortener *shortener.Shortener) *grpc.Server {
-	s := grpc.NewServer()
+// NewServer создаёт новый gRPC сервер с опциями opts и регистрирует хендлеры. Сервер обслуживает
+// одновременно API v1 (proto.shortener, ошибки в поле error ответа) и API v2 (shortener.v2.Shortener,
+// ошибки статусами gRPC).
+func NewServer(shortener *shortener.Shortener, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
 	pb.RegisterShortenerServer(s, &server{shortener: shortener})
+	pbv2.RegisterShortenerServer(s, &serverV2{shortener: shortener})
 	return s
 }
 
+// RegisterHealthServer регистрирует на сервере s стандартный сервис проверки состояния grpc.health.v1.
+// Сервер в целом и сервисы proto.shortener и shortener.v2.Shortener получают статус SERVING; при остановке
+// сервера нужно вызвать Shutdown возвращённого сервиса, чтобы клиенты перестали направлять на него вызовы.
+func RegisterHealthServer(s *grpc.Server) *health.Server {
+	hs := health.NewServer()
+	hs.SetServingStatus(pb.Shortener_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(pbv2.Shortener_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+
+	return hs
+}
+
+// Auth возвращает опцию сервера, аутентифицирующую вызовы методов, работающих с данными пользователя,
+// по метаданным uuid и token, подписанным секретным ключом secret (см. middleware.AuthInterceptor).
+func Auth(secret string) grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(middleware.AuthInterceptor(secret))
+}
+
+// Logging возвращает опцию сервера, добавляющую в контекст каждого вызова логгер с полями request_id,
+// method и user_id (если он передан в запросе) и записывающую в лог результат вызова.
+func Logging(log logrus.FieldLogger) grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(middleware.LoggerInterceptor(log))
+}
+
+// Metrics возвращает опцию сервера, считающую количество и время обработки вызовов каждого метода.
+func Metrics() grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(middleware.MetricsInterceptor())
+}
+
+// Tracing возвращает опцию сервера, создающую спан для каждого вызова.
+func Tracing() grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(middleware.TracingInterceptor())
+}
+
+// RateLimits возвращает опцию сервера, ограничивающую частоту вызовов методов сокращения URL, пакетного
+// сокращения и получения изначального URL в соответствии с конфигурацией. Клиенты учитываются по IP-адресу.
+func RateLimits(cfg config.Config) grpc.ServerOption {
+	shorten := middleware.NewRateLimiter(cfg.ShortenRateLimit.Rate, cfg.ShortenRateLimit.Burst)
+	batch := middleware.NewRateLimiter(cfg.BatchRateLimit.Rate, cfg.BatchRateLimit.Burst)
+	redirect := middleware.NewRateLimiter(cfg.RedirectRateLimit.Rate, cfg.RedirectRateLimit.Burst)
+
+	return grpc.ChainUnaryInterceptor(middleware.RateLimitInterceptor(map[string]*middleware.RateLimiter{
+		"/proto.shortener/ShortenURL":          shorten,
+		"/proto.shortener/BatchShorten":        batch,
+		"/proto.shortener/DecodeURL":           redirect,
+		"/proto.shortener/QRCode":              redirect,
+		"/shortener.v2.Shortener/ShortenURL":   shorten,
+		"/shortener.v2.Shortener/BatchShorten": batch,
+		"/shortener.v2.Shortener/DecodeURL":    redirect,
+		"/shortener.v2.Shortener/QRCode":       redirect,
+	}))
+}
+
 // Ping проверяет соединение с текущей базой данных.
 func (s server) Ping(ctx context.Context, in *pb.Empty) (*pb.PingResponse, error) {
 	result := true
@@ -41,18 +110,23 @@ func (s server) Ping(ctx context.Context, in *pb.Empty) (*pb.PingResponse, error
 // ShortenURL принимает в запросе URL и возвращает сокращенный URL.
 func (s server) ShortenURL(ctx context.Context, r *pb.ShortenURLRequest) (*pb.ShortenURLResponse, error) {
 	resp := pb.ShortenURLResponse{}
-	id, errStr := getUserID(r.UserId)
+	id, errStr := getUserID(ctx, r.UserId)
 	if errStr != "" {
 		return &pb.ShortenURLResponse{Error: errStr}, nil
 	}
 	resp.UserId = id.String()
-	shortURL, err := s.shortener.ShortenURL(ctx, id, r.Url)
+	shortURL, err := s.shortener.ShortenURL(ctx, id, r.Url,
+		shortener.WithExpiration(timeOrZero(r.ExpiresAt)),
+		shortener.WithAlias(r.Alias),
+		shortener.WithTitle(r.Title),
+		shortener.WithAlwaysPreview(r.AlwaysPreview))
 	if err != nil {
 		var errURLAlreadyExists *storage.ErrURLArlreadyExists
 		if errors.As(err, &errURLAlreadyExists) {
 			shortURL = fmt.Sprintf("%s/%s", s.shortener.BaseURL, errURLAlreadyExists.Key)
 		} else {
-			return &pb.ShortenURLResponse{Error: err.Error()}, nil
+			logError(ctx, err, "ShortenURL: could not shorten URL")
+			return &pb.ShortenURLResponse{Error: errorMessage(err)}, nil
 		}
 	}
 	resp.Result = shortURL
@@ -64,9 +138,10 @@ func (s server) DecodeURL(ctx context.Context, r *pb.DecodeURLRequest) (*pb.Deco
 	key := strings.TrimPrefix(r.ShortUrl, s.shortener.BaseURL+"/")
 	url, err := s.shortener.DecodeURL(ctx, key)
 	if err != nil {
-		log.Printf("gRPC: DecodeURL: %s", err)
-		return &pb.DecodeURLResqponse{Error: err.Error()}, nil
+		logError(ctx, err, "DecodeURL: could not find URL")
+		return &pb.DecodeURLResqponse{Error: errorMessage(err)}, nil
 	}
+	s.shortener.RecordClick(newClick(ctx, key))
 	return &pb.DecodeURLResqponse{
 		OriginalUrl: url,
 		Error:       "",
@@ -79,7 +154,7 @@ func (s server) BatchShorten(ctx context.Context, r *pb.BatchShortenRequest) (*p
 		return &pb.BatchShortenResponse{}, nil
 	}
 	resp := pb.BatchShortenResponse{}
-	id, errStr := getUserID(r.UserId)
+	id, errStr := getUserID(ctx, r.UserId)
 	if errStr != "" {
 		return &pb.BatchShortenResponse{Error: errStr}, nil
 	}
@@ -88,17 +163,27 @@ func (s server) BatchShorten(ctx context.Context, r *pb.BatchShortenRequest) (*p
 	for i, rec := range r.Records {
 		reqRecords[i].CorrelationID = rec.CorrelationId
 		reqRecords[i].OriginalURL = rec.Url
+		reqRecords[i].ExpiresAt = timeOrZero(rec.ExpiresAt)
+		reqRecords[i].Alias = rec.Alias
+		reqRecords[i].Title = rec.Title
+		reqRecords[i].AlwaysPreview = rec.AlwaysPreview
+	}
+	batchShorten := s.shortener.BatchShortenURL
+	if r.Partial {
+		batchShorten = s.shortener.BatchShortenURLPartial
 	}
-	result, err := s.shortener.BatchShortenURL(ctx, id, reqRecords)
+	result, err := batchShorten(ctx, id, reqRecords)
 	if err != nil {
-		log.Printf("gRPC: BatchShorten: %s", err)
-		return &pb.BatchShortenResponse{Error: err.Error()}, nil
+		logError(ctx, err, "BatchShorten: could not store the records")
+		return &pb.BatchShortenResponse{Error: errorMessage(err)}, nil
 	}
 	respRecords := make([]*pb.BatchShortenResponse_Records, len(result))
 	for i, rec := range result {
 		respRecords[i] = &pb.BatchShortenResponse_Records{
 			CorrelationId: rec.CorrelationID,
 			ShortUrl:      rec.ShortURL,
+			Status:        rec.Status,
+			Error:         rec.Error,
 		}
 	}
 	resp.Records = respRecords
@@ -107,9 +192,9 @@ func (s server) BatchShorten(ctx context.Context, r *pb.BatchShortenRequest) (*p
 
 // GetUserURLs возвращает список записей OriginalURL/ShortURL для пользователя с указанным ID.
 func (s server) GetUserURLs(ctx context.Context, r *pb.GetUserURLsRequest) (*pb.GetUserURLsResponse, error) {
-	id, err := uuid.Parse(r.UserId)
+	id, err := requestUserID(ctx, r.UserId)
 	if err != nil {
-		log.Printf("gRPC: GetUserURLs: %s", err)
+		logger.FromContext(ctx).WithError(err).Info("GetUserURLs: wrong user ID")
 		return &pb.GetUserURLsResponse{Error: err.Error()}, nil
 	}
 	result := s.shortener.GetAll(ctx, id)
@@ -131,15 +216,15 @@ func (s server) DeleteURLs(ctx context.Context, r *pb.DeleteURLsRequest) (*pb.De
 	if len(r.Keys) == 0 {
 		return &pb.DeleteURLsResponse{Error: ""}, nil
 	}
-	id, err := uuid.Parse(r.UserId)
+	id, err := requestUserID(ctx, r.UserId)
 	if err != nil {
-		log.Printf("gRPC: DeleteURLs: %s", err)
+		logger.FromContext(ctx).WithError(err).Info("DeleteURLs: wrong user ID")
 		return &pb.DeleteURLsResponse{Error: err.Error()}, nil
 	}
 
 	if err := s.shortener.BatchDelete(ctx, id, r.Keys); err != nil {
-		log.Printf("gRPC: DeleteURLs: %s", err)
-		return &pb.DeleteURLsResponse{Error: err.Error()}, nil
+		logError(ctx, err, "DeleteURLs: could not delete URLs")
+		return &pb.DeleteURLsResponse{Error: errorMessage(err)}, nil
 	}
 
 	return &pb.DeleteURLsResponse{Error: ""}, nil
@@ -149,8 +234,9 @@ func (s server) DeleteURLs(ctx context.Context, r *pb.DeleteURLsRequest) (*pb.De
 func (s server) Stats(ctx context.Context, in *pb.Empty) (*pb.StatsResponse, error) {
 	urls, users, err := s.shortener.Stats(ctx)
 	if err != nil {
+		logError(ctx, err, "Stats: could not get stats")
 		return &pb.StatsResponse{
-			Error: err.Error(),
+			Error: errorMessage(err),
 		}, nil
 	}
 	return &pb.StatsResponse{
@@ -160,23 +246,115 @@ func (s server) Stats(ctx context.Context, in *pb.Empty) (*pb.StatsResponse, err
 	}, nil
 }
 
-// getUserID возвращает ID пользователя. Если поле reqUserID пустое - генерируется новый ID.
-func getUserID(reqUserID string) (id uuid.UUID, respErr string) {
+// ClickStats возвращает статистику переходов по ссылке, созданной пользователем с указанным ID.
+func (s server) ClickStats(ctx context.Context, r *pb.ClickStatsRequest) (*pb.ClickStatsResponse, error) {
+	id, err := requestUserID(ctx, r.UserId)
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Info("ClickStats: wrong user ID")
+		return &pb.ClickStatsResponse{Error: apierror.MsgWrongID}, nil
+	}
+	stats, err := s.shortener.ClickStats(ctx, id, r.Key)
+	if err != nil {
+		logError(ctx, err, "ClickStats: could not get click stats")
+		return &pb.ClickStatsResponse{Error: errorMessage(err)}, nil
+	}
+	daily := make([]*pb.ClickStatsResponse_Daily, len(stats.Daily))
+	for i, d := range stats.Daily {
+		daily[i] = &pb.ClickStatsResponse_Daily{
+			Date:   timestamppb.New(d.Date),
+			Clicks: int64(d.Clicks),
+		}
+	}
+
+	return &pb.ClickStatsResponse{
+		Total: int64(stats.Total),
+		Daily: daily,
+	}, nil
+}
+
+// QRCode возвращает изображение QR-кода короткой ссылки. Незаданные параметры изображения принимают
+// значения по умолчанию.
+func (s server) QRCode(ctx context.Context, r *pb.QRCodeRequest) (*pb.QRCodeResponse, error) {
+	opts := qrcode.Options{
+		Format: r.Format,
+		Size:   int(r.Size),
+		Level:  r.Level,
+		Margin: qrcode.DefaultMargin,
+	}
+	if r.Margin != nil {
+		opts.Margin = int(*r.Margin)
+	}
+	img, err := s.shortener.QRCode(ctx, r.Key, opts)
+	if err != nil {
+		logError(ctx, err, "QRCode: could not create the QR code")
+		return &pb.QRCodeResponse{Error: errorMessage(err)}, nil
+	}
+
+	return &pb.QRCodeResponse{
+		Image:       img,
+		ContentType: opts.ContentType(),
+	}, nil
+}
+
+// newClick формирует запись о переходе по ключу key из метаданных gRPC-запроса.
+func newClick(ctx context.Context, key string) storage.Click {
+	click := storage.Click{
+		Key:  key,
+		Time: time.Now(),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		click.IP = analytics.CoarseIP(p.Addr.String())
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			click.UserAgent = ua[0]
+		}
+	}
+
+	return click
+}
+
+// getUserID возвращает ID пользователя, аутентифицированного AuthInterceptor, или ID из поля reqUserID.
+// Если поле reqUserID пустое - генерируется новый ID.
+func getUserID(ctx context.Context, reqUserID string) (id uuid.UUID, respErr string) {
+	if id, err := appContext.ID(ctx); err == nil {
+		return id, ""
+	}
 	var err error
 	if reqUserID != "" {
 		id, err = uuid.Parse(reqUserID)
 		if err != nil {
-			log.Printf("gRPC: ShortenURL: could not parse uuid %s: %s", reqUserID, err)
-			return uuid.Nil, respWrongID
+			logger.FromContext(ctx).WithError(err).Info("wrong user ID")
+			return uuid.Nil, apierror.MsgWrongID
 		}
 
 		return id, ""
 	}
 	id, err = middleware.GenerateUserID()
 	if err != nil {
-		log.Printf("gRPC: ShortenURL: could not generate uuid: %s", err)
-		return uuid.Nil, respInternalServerError
+		logger.FromContext(ctx).WithError(err).Error("could not generate user ID")
+		return uuid.Nil, apierror.MsgInternal
 	}
 
 	return id, ""
 }
+
+// requestUserID возвращает ID пользователя, аутентифицированного AuthInterceptor (поле reqUserID
+// в этом случае уже проверено интерсептором), или, если аутентификация не включена, ID из поля reqUserID.
+func requestUserID(ctx context.Context, reqUserID string) (uuid.UUID, error) {
+	if id, err := appContext.ID(ctx); err == nil {
+		return id, nil
+	}
+
+	return uuid.Parse(reqUserID)
+}
+
+// timeOrZero преобразует необязательное поле с временем в time.Time. Отсутствующее значение
+// преобразуется в нулевое время.
+func timeOrZero(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+
+	return ts.AsTime()
+}
@@ -0,0 +1,256 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/api/apierror"
+	pbv2 "github.com/vanamelnik/go-musthave-shortener/internal/app/api/grpc/proto/v2"
+	appContext "github.com/vanamelnik/go-musthave-shortener/internal/app/context"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/qrcode"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/shortener"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	"github.com/vanamelnik/go-musthave-shortener/pkg/middleware"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// serverV2 - реализация API v2, возвращающая ошибки статусами gRPC (см. statusError).
+type serverV2 struct {
+	pbv2.UnimplementedShortenerServer
+	shortener *shortener.Shortener
+}
+
+// batchStatuses - соответствие результатов обработки записей пакетного сокращения статусам API v2.
+var batchStatuses = map[string]pbv2.BatchShortenResponse_Status{
+	shortener.BatchStatusCreated: pbv2.BatchShortenResponse_STATUS_CREATED,
+	shortener.BatchStatusExists:  pbv2.BatchShortenResponse_STATUS_EXISTS,
+	shortener.BatchStatusInvalid: pbv2.BatchShortenResponse_STATUS_INVALID,
+}
+
+// Ping проверяет соединение с текущей базой данных.
+func (s serverV2) Ping(ctx context.Context, in *emptypb.Empty) (*emptypb.Empty, error) {
+	if err := s.shortener.Ping(); err != nil {
+		logger.FromContext(ctx).WithError(err).Error("Ping: storage is unavailable")
+		return nil, statusError(err, "")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// ShortenURL принимает в запросе URL и возвращает сокращенный URL. Если URL уже был сокращён,
+// возвращается статус AlreadyExists с существующей короткой ссылкой.
+func (s serverV2) ShortenURL(ctx context.Context, r *pbv2.ShortenURLRequest) (*pbv2.ShortenURLResponse, error) {
+	id, err := userIDOrNew(ctx, r.UserId)
+	if err != nil {
+		return nil, err
+	}
+	shortURL, err := s.shortener.ShortenURL(ctx, id, r.Url,
+		shortener.WithExpiration(timeOrZero(r.ExpiresAt)),
+		shortener.WithAlias(r.Alias),
+		shortener.WithTitle(r.Title),
+		shortener.WithAlwaysPreview(r.AlwaysPreview))
+	if err != nil {
+		logError(ctx, err, "ShortenURL: could not shorten URL")
+		var errURLAlreadyExists *storage.ErrURLArlreadyExists
+		if errors.As(err, &errURLAlreadyExists) {
+			return nil, alreadyExistsError(fmt.Sprintf("%s/%s", s.shortener.BaseURL, errURLAlreadyExists.Key))
+		}
+
+		return nil, statusError(err, r.Alias)
+	}
+
+	return &pbv2.ShortenURLResponse{
+		ShortUrl: shortURL,
+		UserId:   id.String(),
+	}, nil
+}
+
+// DecodeURL возвращает оригинальный URL.
+func (s serverV2) DecodeURL(ctx context.Context, r *pbv2.DecodeURLRequest) (*pbv2.DecodeURLResponse, error) {
+	key := strings.TrimPrefix(r.ShortUrl, s.shortener.BaseURL+"/")
+	url, err := s.shortener.DecodeURL(ctx, key)
+	if err != nil {
+		logError(ctx, err, "DecodeURL: could not find URL")
+		return nil, statusError(err, key)
+	}
+	s.shortener.RecordClick(newClick(ctx, key))
+
+	return &pbv2.DecodeURLResponse{OriginalUrl: url}, nil
+}
+
+// BatchShorten сокращает список URL. В атомарном режиме ошибка в любой записи возвращается статусом вызова,
+// в режиме частичного сохранения - в поле status записи ответа.
+func (s serverV2) BatchShorten(ctx context.Context, r *pbv2.BatchShortenRequest) (*pbv2.BatchShortenResponse, error) {
+	if len(r.Records) == 0 {
+		return &pbv2.BatchShortenResponse{}, nil
+	}
+	id, err := userIDOrNew(ctx, r.UserId)
+	if err != nil {
+		return nil, err
+	}
+	reqRecords := make([]shortener.BatchShortenRequest, len(r.Records))
+	for i, rec := range r.Records {
+		reqRecords[i].CorrelationID = rec.CorrelationId
+		reqRecords[i].OriginalURL = rec.Url
+		reqRecords[i].ExpiresAt = timeOrZero(rec.ExpiresAt)
+		reqRecords[i].Alias = rec.Alias
+		reqRecords[i].Title = rec.Title
+		reqRecords[i].AlwaysPreview = rec.AlwaysPreview
+	}
+	batchShorten := s.shortener.BatchShortenURL
+	if r.Partial {
+		batchShorten = s.shortener.BatchShortenURLPartial
+	}
+	result, err := batchShorten(ctx, id, reqRecords)
+	if err != nil {
+		logError(ctx, err, "BatchShorten: could not store the records")
+		return nil, statusError(err, "")
+	}
+	respRecords := make([]*pbv2.BatchShortenResponse_Record, len(result))
+	for i, rec := range result {
+		respRecords[i] = &pbv2.BatchShortenResponse_Record{
+			CorrelationId: rec.CorrelationID,
+			ShortUrl:      rec.ShortURL,
+			Status:        batchStatuses[rec.Status],
+			Error:         rec.Error,
+		}
+	}
+
+	return &pbv2.BatchShortenResponse{
+		Records: respRecords,
+		UserId:  id.String(),
+	}, nil
+}
+
+// GetUserURLs возвращает список записей OriginalURL/ShortURL для пользователя с указанным ID.
+func (s serverV2) GetUserURLs(ctx context.Context, r *pbv2.GetUserURLsRequest) (*pbv2.GetUserURLsResponse, error) {
+	id, err := userID(ctx, r.UserId)
+	if err != nil {
+		return nil, err
+	}
+	result := s.shortener.GetAll(ctx, id)
+	records := make([]*pbv2.GetUserURLsResponse_Record, 0, len(result))
+	for key, url := range result {
+		records = append(records, &pbv2.GetUserURLsResponse_Record{
+			ShortUrl:    fmt.Sprintf("%s/%s", s.shortener.BaseURL, key),
+			OriginalUrl: url,
+		})
+	}
+
+	return &pbv2.GetUserURLsResponse{Records: records}, nil
+}
+
+// DeleteURLs ставит в очередь на удаление URL по указанным ключам, принадлежащие пользователю с указанным ID.
+func (s serverV2) DeleteURLs(ctx context.Context, r *pbv2.DeleteURLsRequest) (*emptypb.Empty, error) {
+	id, err := userID(ctx, r.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Keys) == 0 {
+		return &emptypb.Empty{}, nil
+	}
+	if err := s.shortener.BatchDelete(ctx, id, r.Keys); err != nil {
+		logError(ctx, err, "DeleteURLs: could not delete URLs")
+		return nil, statusError(err, "")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// Stats возвращает статистику - общее число зарегистрированных пользователей и сокращенных адресов в базе.
+func (s serverV2) Stats(ctx context.Context, in *emptypb.Empty) (*pbv2.StatsResponse, error) {
+	urls, users, err := s.shortener.Stats(ctx)
+	if err != nil {
+		logError(ctx, err, "Stats: could not get stats")
+		return nil, statusError(err, "")
+	}
+
+	return &pbv2.StatsResponse{
+		Urls:  int32(urls),
+		Users: int32(users),
+	}, nil
+}
+
+// ClickStats возвращает статистику переходов по ссылке, созданной пользователем с указанным ID.
+func (s serverV2) ClickStats(ctx context.Context, r *pbv2.ClickStatsRequest) (*pbv2.ClickStatsResponse, error) {
+	id, err := userID(ctx, r.UserId)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.shortener.ClickStats(ctx, id, r.Key)
+	if err != nil {
+		logError(ctx, err, "ClickStats: could not get click stats")
+		return nil, statusError(err, r.Key)
+	}
+	daily := make([]*pbv2.ClickStatsResponse_Daily, len(stats.Daily))
+	for i, d := range stats.Daily {
+		daily[i] = &pbv2.ClickStatsResponse_Daily{
+			Date:   timestamppb.New(d.Date),
+			Clicks: int64(d.Clicks),
+		}
+	}
+
+	return &pbv2.ClickStatsResponse{
+		Total: int64(stats.Total),
+		Daily: daily,
+	}, nil
+}
+
+// QRCode возвращает изображение QR-кода короткой ссылки. Незаданные параметры изображения принимают
+// значения по умолчанию.
+func (s serverV2) QRCode(ctx context.Context, r *pbv2.QRCodeRequest) (*pbv2.QRCodeResponse, error) {
+	opts := qrcode.Options{
+		Format: r.Format,
+		Size:   int(r.Size),
+		Level:  r.Level,
+		Margin: qrcode.DefaultMargin,
+	}
+	if r.Margin != nil {
+		opts.Margin = int(*r.Margin)
+	}
+	img, err := s.shortener.QRCode(ctx, r.Key, opts)
+	if err != nil {
+		logError(ctx, err, "QRCode: could not create the QR code")
+		return nil, statusError(err, r.Key)
+	}
+
+	return &pbv2.QRCodeResponse{
+		Image:       img,
+		ContentType: opts.ContentType(),
+	}, nil
+}
+
+// userID возвращает ID пользователя, аутентифицированного AuthInterceptor, или разбирает обязательный
+// ID пользователя из запроса. Если ID неверен, возвращается статус InvalidArgument.
+func userID(ctx context.Context, reqUserID string) (uuid.UUID, error) {
+	id, err := requestUserID(ctx, reqUserID)
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Info("wrong user ID")
+		return uuid.Nil, statusError(fmt.Errorf("%w: %v", apierror.ErrInvalidUserID, err), "")
+	}
+
+	return id, nil
+}
+
+// userIDOrNew возвращает ID пользователя, аутентифицированного AuthInterceptor, или разбирает ID
+// пользователя из запроса. Если аутентификация не включена и поле reqUserID пустое, генерируется новый ID.
+func userIDOrNew(ctx context.Context, reqUserID string) (uuid.UUID, error) {
+	if id, err := appContext.ID(ctx); err == nil {
+		return id, nil
+	}
+	if reqUserID != "" {
+		return userID(ctx, reqUserID)
+	}
+	id, err := middleware.GenerateUserID()
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("could not generate user ID")
+		return uuid.Nil, statusError(err, "")
+	}
+
+	return id, nil
+}
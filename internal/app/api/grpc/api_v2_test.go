@@ -0,0 +1,151 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pbv2 "github.com/vanamelnik/go-musthave-shortener/internal/app/api/grpc/proto/v2"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// requireStatus проверяет, что вызов завершился статусом с кодом code и причиной reason
+// в подробностях google.rpc.ErrorInfo, и возвращает остальные подробности статуса.
+func requireStatus(t *testing.T, err error, code codes.Code, reason string) []interface{} {
+	t.Helper()
+	st, ok := status.FromError(err)
+	require.True(t, ok, "error must be a gRPC status: %v", err)
+	require.Equal(t, code, st.Code(), st.Message())
+	details := st.Details()
+	require.NotEmpty(t, details)
+	info, ok := details[0].(*errdetails.ErrorInfo)
+	require.True(t, ok, "the first detail must be ErrorInfo")
+	assert.Equal(t, reason, info.Reason)
+	assert.Equal(t, errorDomain, info.Domain)
+
+	return details[1:]
+}
+
+func TestShortenerV2(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.Dial(port, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pbv2.NewShortenerClient(conn)
+
+	originalURL := "http://example.com/v2/" + uuid.NewString()
+	resp, err := client.ShortenURL(ctx, &pbv2.ShortenURLRequest{Url: originalURL})
+	require.NoError(t, err)
+	shortURL, userID := resp.ShortUrl, resp.UserId
+	key := shortURL[strings.LastIndex(shortURL, "/")+1:]
+
+	t.Run("Shorten existing URL", func(t *testing.T) {
+		_, err := client.ShortenURL(ctx, &pbv2.ShortenURLRequest{Url: originalURL, UserId: userID})
+		details := requireStatus(t, err, codes.AlreadyExists, "URL_ALREADY_EXISTS")
+		require.Len(t, details, 1)
+		assert.Equal(t, shortURL, details[0].(*errdetails.ResourceInfo).ResourceName)
+	})
+	t.Run("Shorten invalid requests", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			req    *pbv2.ShortenURLRequest
+			reason string
+			field  string
+		}{
+			{
+				name:   "Incorrect URL",
+				req:    &pbv2.ShortenURLRequest{Url: "на деревню дедушке"},
+				reason: "INVALID_URL",
+				field:  "url",
+			},
+			{
+				name:   "Incorrect user ID",
+				req:    &pbv2.ShortenURLRequest{Url: "http://google.com", UserId: "самый правильный UUID"},
+				reason: "INVALID_USER_ID",
+				field:  "user_id",
+			},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := client.ShortenURL(ctx, tc.req)
+				details := requireStatus(t, err, codes.InvalidArgument, tc.reason)
+				require.Len(t, details, 1)
+				badRequest := details[0].(*errdetails.BadRequest)
+				require.Len(t, badRequest.FieldViolations, 1)
+				assert.Equal(t, tc.field, badRequest.FieldViolations[0].Field)
+			})
+		}
+	})
+	t.Run("Alias is taken", func(t *testing.T) {
+		_, err := client.ShortenURL(ctx, &pbv2.ShortenURLRequest{Url: "http://example.com/other", Alias: key})
+		requireStatus(t, err, codes.AlreadyExists, "ALIAS_ALREADY_EXISTS")
+	})
+	t.Run("Decode URL", func(t *testing.T) {
+		resp, err := client.DecodeURL(ctx, &pbv2.DecodeURLRequest{ShortUrl: shortURL})
+		require.NoError(t, err)
+		assert.Equal(t, originalURL, resp.OriginalUrl)
+
+		_, err = client.DecodeURL(ctx, &pbv2.DecodeURLRequest{ShortUrl: "invalidkey"})
+		details := requireStatus(t, err, codes.NotFound, "URL_NOT_FOUND")
+		require.Len(t, details, 1)
+		assert.Equal(t, "invalidkey", details[0].(*errdetails.ResourceInfo).ResourceName)
+	})
+	t.Run("Batch shorten", func(t *testing.T) {
+		records := []*pbv2.BatchShortenRequest_Record{
+			{CorrelationId: "1", Url: "http://example.com/v2/" + uuid.NewString()},
+			{CorrelationId: "2", Url: originalURL},
+			{CorrelationId: "3", Url: "example.com"},
+		}
+		_, err := client.BatchShorten(ctx, &pbv2.BatchShortenRequest{Records: records, UserId: userID})
+		requireStatus(t, err, codes.InvalidArgument, "INVALID_URL")
+
+		resp, err := client.BatchShorten(ctx, &pbv2.BatchShortenRequest{Records: records, UserId: userID, Partial: true})
+		require.NoError(t, err)
+		require.Len(t, resp.Records, 3)
+		assert.Equal(t, pbv2.BatchShortenResponse_STATUS_CREATED, resp.Records[0].Status)
+		assert.Equal(t, pbv2.BatchShortenResponse_STATUS_EXISTS, resp.Records[1].Status)
+		assert.Equal(t, shortURL, resp.Records[1].ShortUrl)
+		assert.Equal(t, pbv2.BatchShortenResponse_STATUS_INVALID, resp.Records[2].Status)
+		assert.NotEmpty(t, resp.Records[2].Error)
+	})
+	t.Run("User URLs", func(t *testing.T) {
+		resp, err := client.GetUserURLs(ctx, &pbv2.GetUserURLsRequest{UserId: userID})
+		require.NoError(t, err)
+		assert.Len(t, resp.Records, 2)
+
+		_, err = client.GetUserURLs(ctx, &pbv2.GetUserURLsRequest{})
+		requireStatus(t, err, codes.InvalidArgument, "INVALID_USER_ID")
+	})
+	t.Run("Delete URL", func(t *testing.T) {
+		_, err := client.DeleteURLs(ctx, &pbv2.DeleteURLsRequest{Keys: []string{key}, UserId: userID})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			_, err := client.DecodeURL(ctx, &pbv2.DecodeURLRequest{ShortUrl: shortURL})
+			return status.Code(err) == codes.FailedPrecondition
+		}, time.Second, 10*time.Millisecond)
+
+		_, err = client.QRCode(ctx, &pbv2.QRCodeRequest{Key: key})
+		details := requireStatus(t, err, codes.FailedPrecondition, "URL_DELETED")
+		require.Len(t, details, 1)
+		violations := details[0].(*errdetails.PreconditionFailure).Violations
+		require.Len(t, violations, 1)
+		assert.Equal(t, key, violations[0].Subject)
+	})
+	t.Run("Stats and ping", func(t *testing.T) {
+		resp, err := client.Stats(ctx, &emptypb.Empty{})
+		require.NoError(t, err)
+		assert.Positive(t, resp.Urls)
+
+		_, err = client.Ping(ctx, &emptypb.Empty{})
+		assert.NoError(t, err)
+	})
+}
@@ -1,6 +1,79 @@
 package grpc
 
-const (
-	respInternalServerError = "Something went wrong"
-	respWrongID             = "Incorrect ID"
+import (
+	"context"
+
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/api/apierror"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
 )
+
+// errorDomain - домен кодов причин ошибок (google.rpc.ErrorInfo) в статусах API v2.
+const errorDomain = "shortener"
+
+// errorMessage возвращает текст ошибки, передаваемый клиенту в поле Error ответа API v1.
+// Внутренние ошибки хранилища клиенту не раскрываются.
+func errorMessage(err error) string {
+	return apierror.For(err).Message
+}
+
+// statusError преобразует ошибку сервиса в статус API v2. Статус содержит подробности google.rpc.ErrorInfo,
+// для неверных параметров запроса - google.rpc.BadRequest, для ненайденной ссылки - google.rpc.ResourceInfo,
+// для удалённой или просроченной ссылки - google.rpc.PreconditionFailure. key - ключ ссылки, к которой
+// относится вызов (если есть). Внутренние ошибки сервиса клиенту не раскрываются.
+func statusError(err error, key string) error {
+	resp := apierror.For(err)
+	var details []proto.Message
+	switch resp.Code {
+	case codes.InvalidArgument:
+		violation := &errdetails.BadRequest_FieldViolation{Field: resp.Field, Description: err.Error()}
+		details = append(details, &errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{violation}})
+	case codes.NotFound:
+		details = append(details, &errdetails.ResourceInfo{ResourceType: "url", ResourceName: key, Description: resp.Message})
+	case codes.FailedPrecondition:
+		violation := &errdetails.PreconditionFailure_Violation{Type: resp.Reason, Subject: key, Description: resp.Message}
+		details = append(details, &errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{violation}})
+	}
+
+	return newStatus(resp.Code, resp.Message, resp.Reason, nil, details...)
+}
+
+// alreadyExistsError возвращает статус API v2 для URL, уже сокращённого ранее: короткая ссылка shortURL
+// передаётся в подробностях google.rpc.ResourceInfo и в метаданных google.rpc.ErrorInfo.
+func alreadyExistsError(shortURL string) error {
+	return newStatus(codes.AlreadyExists, apierror.MsgURLAlreadyExists, "URL_ALREADY_EXISTS",
+		map[string]string{"short_url": shortURL},
+		&errdetails.ResourceInfo{ResourceType: "url", ResourceName: shortURL, Description: apierror.MsgURLAlreadyExists})
+}
+
+// newStatus создаёт статус с кодом code и сообщением msg, добавляя к подробностям details
+// google.rpc.ErrorInfo с причиной reason и метаданными metadata.
+func newStatus(code codes.Code, msg, reason string, metadata map[string]string, details ...proto.Message) error {
+	st := &spb.Status{Code: int32(code), Message: msg}
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: errorDomain, Metadata: metadata}
+	for _, detail := range append([]proto.Message{info}, details...) {
+		// подробности, которые не удалось упаковать, не передаются, статус при этом остаётся прежним
+		if packed, err := anypb.New(detail); err == nil {
+			st.Details = append(st.Details, packed)
+		}
+	}
+
+	return status.FromProto(st).Err()
+}
+
+// logError записывает ошибку err в лог вызова: внутренние ошибки сервиса - с уровнем error,
+// ошибки в запросе клиента - с уровнем info.
+func logError(ctx context.Context, err error, msg string) {
+	log := logger.FromContext(ctx).WithError(err)
+	if apierror.For(err).ServerError() {
+		log.Error(msg)
+
+		return
+	}
+	log.Info(msg)
+}
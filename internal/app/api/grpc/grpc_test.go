@@ -1,18 +1,32 @@
 package grpc
 
 import (
+	"bytes"
 	"context"
+	"image/png"
+	"net"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/api/apierror"
 	pb "github.com/vanamelnik/go-musthave-shortener/internal/app/api/grpc/proto"
+	pbv2 "github.com/vanamelnik/go-musthave-shortener/internal/app/api/grpc/proto/v2"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/dataloader"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/shortener"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/inmem"
+	"github.com/vanamelnik/go-musthave-shortener/pkg/middleware"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func TestPing(t *testing.T) {
@@ -24,6 +38,70 @@ func TestPing(t *testing.T) {
 	assert.Equal(t, true, resp.Ok)
 }
 
+func TestHealth(t *testing.T) {
+	w := startClient(t)
+	defer w.conn.Close()
+	client := healthpb.NewHealthClient(w.conn)
+
+	for _, service := range []string{"", "proto.shortener", "shortener.v2.Shortener"} {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status, "service %q", service)
+	}
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// TestAuth проверяет, что при включённой аутентификации пользователь получает доступ только к своим ссылкам.
+func TestAuth(t *testing.T) {
+	ctx := context.Background()
+	db, err := inmem.NewDB(filepath.Join(t.TempDir(), "test.db"), time.Hour)
+	require.NoError(t, err)
+	defer db.Close()
+	server := NewServer(shortener.NewShortener(baseURL, db, dataloader.DataLoader{}), Auth("secret"))
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listen) //nolint:errcheck
+	defer server.Stop()
+	conn, err := grpc.Dial(listen.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client, clientV2 := pb.NewShortenerClient(conn), pbv2.NewShortenerClient(conn)
+
+	// новый пользователь получает идентификатор и токен в заголовке ответа
+	var header metadata.MD
+	resp, err := clientV2.ShortenURL(ctx, &pbv2.ShortenURLRequest{Url: "http://example.com/private"}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Len(t, header.Get(middleware.UserIDMetadataKey), 1)
+	require.Len(t, header.Get(middleware.TokenMetadataKey), 1)
+	userID, token := header.Get(middleware.UserIDMetadataKey)[0], header.Get(middleware.TokenMetadataKey)[0]
+	assert.Equal(t, userID, resp.UserId)
+	authCtx := metadata.AppendToOutgoingContext(ctx, middleware.UserIDMetadataKey, userID, middleware.TokenMetadataKey, token)
+
+	t.Run("Owner gets the URLs", func(t *testing.T) {
+		resp, err := clientV2.GetUserURLs(authCtx, &pbv2.GetUserURLsRequest{})
+		require.NoError(t, err)
+		assert.Len(t, resp.Records, 1)
+
+		respV1, err := client.GetUserURLs(authCtx, &pb.GetUserURLsRequest{UserId: userID})
+		require.NoError(t, err)
+		assert.Empty(t, respV1.Error)
+		assert.Len(t, respV1.Records, 1)
+	})
+	t.Run("Other users are rejected", func(t *testing.T) {
+		_, err := client.GetUserURLs(ctx, &pb.GetUserURLsRequest{UserId: userID})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err), "user_id without a token")
+
+		_, err = clientV2.DeleteURLs(ctx, &pbv2.DeleteURLsRequest{UserId: userID, Keys: []string{"key"}})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err), "user_id without a token")
+
+		forgedCtx := metadata.AppendToOutgoingContext(ctx,
+			middleware.UserIDMetadataKey, userID, middleware.TokenMetadataKey, middleware.NewSigner("guess").Sign(uuid.New()))
+		_, err = clientV2.GetUserURLs(forgedCtx, &pbv2.GetUserURLsRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), "forged token")
+	})
+}
+
 func TestShortener(t *testing.T) {
 	ctx := context.Background()
 	w := startClient(t)
@@ -85,6 +163,31 @@ func TestShortener(t *testing.T) {
 		assert.NotEmpty(t, resp.Error)
 		t.Logf("error message: %s", resp.Error)
 	})
+	t.Run("QR code", func(t *testing.T) {
+		key := shortURL[strings.LastIndex(shortURL, "/")+1:]
+		resp, err := w.client.QRCode(ctx, &pb.QRCodeRequest{Key: key})
+		require.NoError(t, err)
+		require.Empty(t, resp.Error)
+		assert.Equal(t, "image/png", resp.ContentType)
+		img, err := png.Decode(bytes.NewReader(resp.Image))
+		require.NoError(t, err)
+		assert.Equal(t, 256, img.Bounds().Dx())
+
+		margin := int32(0)
+		resp, err = w.client.QRCode(ctx, &pb.QRCodeRequest{Key: key, Format: "svg", Size: 128, Level: "H", Margin: &margin})
+		require.NoError(t, err)
+		require.Empty(t, resp.Error)
+		assert.Equal(t, "image/svg+xml", resp.ContentType)
+		assert.Contains(t, string(resp.Image), `width="128"`)
+
+		resp, err = w.client.QRCode(ctx, &pb.QRCodeRequest{Key: key, Size: 10000})
+		require.NoError(t, err)
+		assert.Equal(t, apierror.MsgWrongQRCodeOptions, resp.Error)
+		resp, err = w.client.QRCode(ctx, &pb.QRCodeRequest{Key: "invalidkey"})
+		require.NoError(t, err)
+		assert.Equal(t, apierror.MsgNotFound, resp.Error)
+		assert.Empty(t, resp.Image)
+	})
 	t.Run("Stats", func(t *testing.T) {
 		resp, err := w.client.Stats(ctx, &pb.Empty{})
 		assert.NoError(t, err)
@@ -130,6 +233,30 @@ func TestBatchShortenAndDelete(t *testing.T) {
 		assert.Equal(t, len(records), len(resp.Records))
 		t.Logf("response records: %+v", resp.Records)
 	})
+	t.Run("BatchShorten partial", func(t *testing.T) {
+		resp, err := w.client.BatchShorten(ctx, &pb.BatchShortenRequest{
+			Records: []*pb.BatchShortenRequest_Records{
+				{CorrelationId: "Google", Url: "http://google.com"},
+				{CorrelationId: "Invalid", Url: "google.com"},
+			},
+			UserId:  userID,
+			Partial: true,
+		})
+		require.NoError(t, err)
+		require.Empty(t, resp.Error)
+		require.Len(t, resp.Records, 2)
+		assert.Equal(t, "exists", resp.Records[0].Status)
+		assert.True(t, strings.HasPrefix(resp.Records[0].ShortUrl, baseURL+"/"))
+		assert.Equal(t, "invalid", resp.Records[1].Status)
+		assert.NotEmpty(t, resp.Records[1].Error)
+
+		resp, err = w.client.BatchShorten(ctx, &pb.BatchShortenRequest{
+			Records: []*pb.BatchShortenRequest_Records{{CorrelationId: "Google", Url: "http://google.com"}},
+			UserId:  userID,
+		})
+		require.NoError(t, err)
+		assert.NotEmpty(t, resp.Error, "atomic mode must fail on a duplicate URL")
+	})
 	t.Run("Add 1 more URL by the same user", func(t *testing.T) {
 		resp, err := w.client.ShortenURL(ctx, &pb.ShortenURLRequest{
 			Url:    "http://onemoreurl.io",
@@ -3,7 +3,6 @@ package grpc
 import (
 	"context"
 	"log"
-	"math/rand"
 	"net"
 	"os"
 	"testing"
@@ -21,7 +20,6 @@ const (
 )
 
 func TestMain(m *testing.M) {
-	rand.Seed(time.Now().UnixNano())
 	db, err := inmem.NewDB(tmpDBFile, time.Millisecond)
 	if err != nil {
 		log.Fatal(err)
@@ -31,6 +29,12 @@ func TestMain(m *testing.M) {
 		if err := os.Remove(tmpDBFile); err != nil {
 			log.Fatal(err)
 		}
+		if err := os.Remove(tmpDBFile + ".prev"); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.Remove(tmpDBFile + ".wal.prev"); err != nil {
+			log.Fatal(err)
+		}
 	}()
 	defer db.Close()
 	dl := dataloader.NewDataLoader(context.Background(), db.BatchDelete, time.Millisecond)
@@ -38,6 +42,7 @@ func TestMain(m *testing.M) {
 	s := shortener.NewShortener(baseURL, db, dl)
 
 	server := NewServer(s)
+	RegisterHealthServer(server)
 	listen, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Fatal(err)
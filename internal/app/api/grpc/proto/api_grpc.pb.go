@@ -28,6 +28,10 @@ type ShortenerClient interface {
 	BatchShorten(ctx context.Context, in *BatchShortenRequest, opts ...grpc.CallOption) (*BatchShortenResponse, error)
 	DeleteURLs(ctx context.Context, in *DeleteURLsRequest, opts ...grpc.CallOption) (*DeleteURLsResponse, error)
 	Stats(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*StatsResponse, error)
+	// ClickStats возвращает статистику переходов по ссылке с разбивкой по дням.
+	ClickStats(ctx context.Context, in *ClickStatsRequest, opts ...grpc.CallOption) (*ClickStatsResponse, error)
+	// QRCode возвращает изображение QR-кода короткой ссылки.
+	QRCode(ctx context.Context, in *QRCodeRequest, opts ...grpc.CallOption) (*QRCodeResponse, error)
 	// Ping проверяет соединение с базой данных.
 	Ping(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*PingResponse, error)
 }
@@ -94,6 +98,24 @@ func (c *shortenerClient) Stats(ctx context.Context, in *Empty, opts ...grpc.Cal
 	return out, nil
 }
 
+func (c *shortenerClient) ClickStats(ctx context.Context, in *ClickStatsRequest, opts ...grpc.CallOption) (*ClickStatsResponse, error) {
+	out := new(ClickStatsResponse)
+	err := c.cc.Invoke(ctx, "/proto.shortener/ClickStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerClient) QRCode(ctx context.Context, in *QRCodeRequest, opts ...grpc.CallOption) (*QRCodeResponse, error) {
+	out := new(QRCodeResponse)
+	err := c.cc.Invoke(ctx, "/proto.shortener/QRCode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *shortenerClient) Ping(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*PingResponse, error) {
 	out := new(PingResponse)
 	err := c.cc.Invoke(ctx, "/proto.shortener/Ping", in, out, opts...)
@@ -113,6 +135,10 @@ type ShortenerServer interface {
 	BatchShorten(context.Context, *BatchShortenRequest) (*BatchShortenResponse, error)
 	DeleteURLs(context.Context, *DeleteURLsRequest) (*DeleteURLsResponse, error)
 	Stats(context.Context, *Empty) (*StatsResponse, error)
+	// ClickStats возвращает статистику переходов по ссылке с разбивкой по дням.
+	ClickStats(context.Context, *ClickStatsRequest) (*ClickStatsResponse, error)
+	// QRCode возвращает изображение QR-кода короткой ссылки.
+	QRCode(context.Context, *QRCodeRequest) (*QRCodeResponse, error)
 	// Ping проверяет соединение с базой данных.
 	Ping(context.Context, *Empty) (*PingResponse, error)
 	mustEmbedUnimplementedShortenerServer()
@@ -140,6 +166,12 @@ func (UnimplementedShortenerServer) DeleteURLs(context.Context, *DeleteURLsReque
 func (UnimplementedShortenerServer) Stats(context.Context, *Empty) (*StatsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
 }
+func (UnimplementedShortenerServer) ClickStats(context.Context, *ClickStatsRequest) (*ClickStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClickStats not implemented")
+}
+func (UnimplementedShortenerServer) QRCode(context.Context, *QRCodeRequest) (*QRCodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QRCode not implemented")
+}
 func (UnimplementedShortenerServer) Ping(context.Context, *Empty) (*PingResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
 }
@@ -264,6 +296,42 @@ func _Shortener_Stats_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Shortener_ClickStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClickStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).ClickStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.shortener/ClickStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).ClickStats(ctx, req.(*ClickStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shortener_QRCode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QRCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).QRCode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.shortener/QRCode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).QRCode(ctx, req.(*QRCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Shortener_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Empty)
 	if err := dec(in); err != nil {
@@ -313,6 +381,14 @@ var Shortener_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Stats",
 			Handler:    _Shortener_Stats_Handler,
 		},
+		{
+			MethodName: "ClickStats",
+			Handler:    _Shortener_ClickStats_Handler,
+		},
+		{
+			MethodName: "QRCode",
+			Handler:    _Shortener_QRCode_Handler,
+		},
 		{
 			MethodName: "Ping",
 			Handler:    _Shortener_Ping_Handler,
@@ -5,21 +5,84 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/analytics"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/context"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/health"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/shortener"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	"github.com/vanamelnik/go-musthave-shortener/pkg/middleware"
 )
 
-type Rest struct {
-	shortener *shortener.Shortener
+// Режимы пакетного сокращения URL (параметр запроса mode).
+const (
+	batchModeAtomic  = "atomic"
+	batchModePartial = "partial"
+)
+
+type (
+	Rest struct {
+		shortener *shortener.Shortener
+		// log - логгер, на основе которого создаются логгеры запросов.
+		log logrus.FieldLogger
+		// health выполняет проверки готовности сервиса для эндпоинта /readyz.
+		health *health.Checker
+		// trustedProxy - подсеть обратного прокси, которому доверяется заголовок X-Real-IP
+		// при определении адреса клиента (nil - прокси не используется).
+		trustedProxy *net.IPNet
+	}
+
+	// Option задаёт необязательные параметры Rest.
+	Option func(*Rest)
+)
+
+func NewRest(s *shortener.Shortener, opts ...Option) Rest {
+	rest := Rest{
+		shortener: s,
+		log:       logger.Default(),
+	}
+	for _, opt := range opts {
+		opt(&rest)
+	}
+	if rest.health == nil {
+		rest.health = health.NewChecker()
+		rest.health.Add("storage", health.Ping(s.Ping))
+	}
+
+	return rest
 }
 
-func NewRest(s *shortener.Shortener) Rest {
-	return Rest{shortener: s}
+// WithLogger задаёт логгер REST API.
+func WithLogger(l logrus.FieldLogger) Option {
+	return func(rest *Rest) {
+		rest.log = l
+	}
+}
+
+// WithHealthChecker задаёт проверки готовности сервиса. По умолчанию проверяется только соединение с хранилищем.
+func WithHealthChecker(c *health.Checker) Option {
+	return func(rest *Rest) {
+		rest.health = c
+	}
+}
+
+// WithTrustedProxy задаёт подсеть обратного прокси (в формате CIDR), которому доверяется заголовок X-Real-IP
+// при определении адреса клиента. По умолчанию заголовок не учитывается.
+func WithTrustedProxy(cidr string) Option {
+	return func(rest *Rest) {
+		rest.trustedProxy = middleware.ParseTrustedProxy(cidr)
+	}
+}
+
+// logger возвращает логгер запроса r, добавленный в контекст LoggerMdlw.
+func (rest Rest) logger(r *http.Request) logrus.FieldLogger {
+	return logger.FromContextOr(r.Context(), rest.log)
 }
 
 // Ping проверяет соединение с базой данных.
@@ -27,28 +90,34 @@ func NewRest(s *shortener.Shortener) Rest {
 // GET /ping
 func (rest Rest) Ping(w http.ResponseWriter, r *http.Request) {
 	if err := rest.shortener.Ping(); err != nil {
-		log.Printf("storage: ping: %v", err)
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		rest.logger(r).WithError(err).Error("Ping: storage is unavailable")
+		httpError(w, err)
 
 		return
 	}
-	log.Println("storage: ping OK")
+	rest.logger(r).Debug("Ping: OK")
 }
 
 // APIShortenURL принимает в теле запроса JSON-объект в формате {"url": "<some_url>"} и
-// возвращает в ответе объект {"result": "<shorten_url>"}
+// возвращает в ответе объект {"result": "<shorten_url>"}. Необязательное поле "expires_at"
+// (в формате RFC 3339) ограничивает срок действия ссылки, поле "alias" задаёт пользовательский ключ,
+// поля "title" и "always_preview" - заголовок ссылки и обязательный показ страницы предпросмотра.
 //
 // POST /api/shorten
 func (rest Rest) APIShortenURL(w http.ResponseWriter, r *http.Request) {
 	type Request struct {
-		URL string `json:"url"`
+		URL           string    `json:"url"`
+		ExpiresAt     time.Time `json:"expires_at,omitempty"`
+		Alias         string    `json:"alias,omitempty"`
+		Title         string    `json:"title,omitempty"`
+		AlwaysPreview bool      `json:"always_preview,omitempty"`
 	}
 	type Result struct {
 		Result string `json:"result"`
 	}
 	id, err := context.ID(r.Context()) // Значение uuid добавлено в контекст запроса middleware'й.
 	if err != nil {
-		log.Printf("shortener: %v", err)
+		rest.logger(r).WithError(err).Error("APIShortenURL: could not get user ID")
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 
 		return
@@ -57,22 +126,26 @@ func (rest Rest) APIShortenURL(w http.ResponseWriter, r *http.Request) {
 	urlReq := Request{}
 	defer r.Body.Close()
 	if err := json.NewDecoder(r.Body).Decode(&urlReq); err != nil {
-		log.Printf("APIShortenURL: %v", err)
+		rest.logger(r).WithError(err).Info("APIShortenURL: could not decode the request")
 		http.Error(w, "Bad request", http.StatusBadRequest)
 
 		return
 	}
-	shortURL, err := rest.shortener.ShortenURL(r.Context(), id, urlReq.URL)
+	shortURL, err := rest.shortener.ShortenURL(r.Context(), id, urlReq.URL,
+		shortener.WithExpiration(urlReq.ExpiresAt),
+		shortener.WithAlias(urlReq.Alias),
+		shortener.WithTitle(urlReq.Title),
+		shortener.WithAlwaysPreview(urlReq.AlwaysPreview))
 	statusCode := http.StatusCreated
 	if err != nil {
-		log.Printf("APIShortenURL: %v", err)
+		rest.logError(r, err, "APIShortenURL: could not shorten URL")
 		var errURLAlreadyExists *storage.ErrURLArlreadyExists
 
 		if errors.As(err, &errURLAlreadyExists) {
 			statusCode = http.StatusConflict
 			shortURL = fmt.Sprintf("%s/%s", rest.shortener.BaseURL, errURLAlreadyExists.Key)
 		} else {
-			http.Error(w, "Wrong URL", http.StatusBadRequest)
+			httpError(w, err)
 
 			return
 		}
@@ -83,7 +156,7 @@ func (rest Rest) APIShortenURL(w http.ResponseWriter, r *http.Request) {
 	err = enc.Encode(&Result{Result: shortURL})
 	if err != nil {
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
-		log.Printf("APIShortHandler: %v", err)
+		rest.logger(r).WithError(err).Error("APIShortenURL: could not encode the response")
 	}
 }
 
@@ -94,7 +167,7 @@ func (rest Rest) APIShortenURL(w http.ResponseWriter, r *http.Request) {
 func (rest Rest) ShortenURL(w http.ResponseWriter, r *http.Request) {
 	id, err := context.ID(r.Context()) // Значение uuid добавлено в контекст запроса middleware'й.
 	if err != nil {
-		log.Printf("shortener: %v", err)
+		rest.logger(r).WithError(err).Error("ShortenURL: could not get user ID")
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 
 		return
@@ -103,14 +176,14 @@ func (rest Rest) ShortenURL(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("shortener: ShortenURL: %v", err)
+		rest.logger(r).WithError(err).Error("ShortenURL: could not read the request")
 		http.Error(w, "Sorry, something went wrong...", http.StatusInternalServerError)
 
 		return
 	}
 	shortURL, err := rest.shortener.ShortenURL(r.Context(), id, string(body))
 	if err != nil {
-		log.Printf("shortener: %v", err)
+		rest.logError(r, err, "ShortenURL: could not shorten URL")
 		var errURLAlreadyExists *storage.ErrURLArlreadyExists
 		if errors.As(err, &errURLAlreadyExists) {
 			w.WriteHeader(http.StatusConflict)
@@ -120,7 +193,7 @@ func (rest Rest) ShortenURL(w http.ResponseWriter, r *http.Request) {
 
 			return
 		}
-		http.Error(w, "Wrong URL", http.StatusBadRequest)
+		httpError(w, err)
 
 		return
 	}
@@ -129,30 +202,92 @@ func (rest Rest) ShortenURL(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(shortURL))
 }
 
-// DecodeURL принимает короткий параметр и производит редирект на изначальный url с кодом 307.
+// DecodeURL принимает короткий параметр и производит редирект на изначальный url с кодом 307. Если в запросе
+// передан параметр preview=1 или у ссылки включён показ предпросмотра, вместо перенаправления
+// показывается страница предпросмотра (см. PreviewURL).
 //
 // GET /{id}
 func (rest Rest) DecodeURL(w http.ResponseWriter, r *http.Request) {
+	rest.decodeURL(w, r, r.URL.Query().Get("preview") == "1")
+}
+
+// decodeURL перенаправляет клиента по адресу короткой ссылки или, если preview == true или у ссылки
+// включён показ предпросмотра, показывает страницу предпросмотра. Учитываются только переходы
+// с перенаправлением: просмотр страницы предпросмотра переходом не считается.
+func (rest Rest) decodeURL(w http.ResponseWriter, r *http.Request, preview bool) {
 	key, ok := mux.Vars(r)["id"]
-	if !ok || len(key) != 8 {
-		log.Printf("shortener: DecodeURL: wrong key '%v'", key)
+	if !ok || !shortener.ValidKey(key) {
+		rest.logger(r).WithField("key", key).Info("DecodeURL: wrong key")
 		http.Error(w, "Wrong key", http.StatusBadRequest)
 
 		return
 	}
-	url, err := rest.shortener.DecodeURL(r.Context(), key)
+	rec, err := rest.shortener.Lookup(r.Context(), key)
 	if err != nil {
-		log.Printf("shortener: DecodeURL: could not find url with key %v: %v", key, err)
-		if errors.Is(err, storage.ErrDeleted) {
-			http.Error(w, "URL was deleted", http.StatusGone)
-			return
-		}
+		rest.logError(r, err, "DecodeURL: could not find URL")
+		httpError(w, err)
 
-		http.Error(w, "URL not found", http.StatusNotFound)
 		return
 	}
-	// log.Printf("shortener: DecodeURL: redirecting to %v (key: %v)", url, key)
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	if preview || rec.AlwaysPreview {
+		rest.renderPreview(w, r, rec)
+
+		return
+	}
+	rest.shortener.RecordClick(storage.Click{
+		Key:       key,
+		Time:      time.Now(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        analytics.CoarseIP(middleware.ClientIP(r, rest.trustedProxy)),
+	})
+	http.Redirect(w, r, rec.OriginalURL, http.StatusTemporaryRedirect)
+}
+
+// ClickStats возвращает статистику переходов по короткой ссылке, созданной текущим пользователем.
+// Статистика включает общее количество переходов и количество переходов по дням (UTC).
+//
+// GET /api/user/urls/{key}/stats
+func (rest Rest) ClickStats(w http.ResponseWriter, r *http.Request) {
+	type daily struct {
+		Date   string `json:"date"`
+		Clicks int    `json:"clicks"`
+	}
+	type result struct {
+		ShortURL string  `json:"short_url"`
+		Total    int     `json:"total"`
+		Daily    []daily `json:"daily"`
+	}
+
+	id, err := context.ID(r.Context()) // Значение uuid добавлено в контекст запроса middleware'й.
+	if err != nil {
+		rest.logger(r).WithError(err).Error("ClickStats: could not get user ID")
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+
+		return
+	}
+	key := mux.Vars(r)["key"]
+	stats, err := rest.shortener.ClickStats(r.Context(), id, key)
+	if err != nil {
+		rest.logError(r, err, "ClickStats: could not get click stats")
+		httpError(w, err)
+
+		return
+	}
+
+	res := result{
+		ShortURL: fmt.Sprintf("%s/%s", rest.shortener.BaseURL, key),
+		Total:    stats.Total,
+		Daily:    make([]daily, 0, len(stats.Daily)),
+	}
+	for _, d := range stats.Daily {
+		res.Daily = append(res.Daily, daily{Date: d.Date.Format("2006-01-02"), Clicks: d.Clicks})
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		rest.logger(r).WithError(err).Error("ClickStats: could not encode the response")
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+	}
 }
 
 // UserURLs возвращает в ответе json с массивом записей всех URL, созданных текущем пользователем
@@ -166,14 +301,14 @@ func (rest Rest) UserURLs(w http.ResponseWriter, r *http.Request) {
 
 	id, err := context.ID(r.Context()) // Значение uuid добавлено в контекст запроса middleware'й.
 	if err != nil {
-		log.Printf("shortener: %v", err)
+		rest.logger(r).WithError(err).Error("UserURLs: could not get user ID")
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 
 		return
 	}
 
 	list := rest.shortener.GetAll(r.Context(), id)
-	log.Printf("[INF] shortener: requested entries for id=%s, found %d items.", id, len(list))
+	rest.logger(r).Debugf("UserURLs: found %d URLs", len(list))
 	if len(list) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
@@ -192,19 +327,22 @@ func (rest Rest) UserURLs(w http.ResponseWriter, r *http.Request) {
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(userURLs); err != nil {
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
-		log.Printf("shortener: %v", err)
+		rest.logger(r).WithError(err).Error("UserURLs: could not encode the response")
 
 		return
 	}
 }
 
 // BatchShortenURL формирует ключи для переданных через тело запроса URL и передает данные на сохранение в базу данных.
+// Для каждой записи можно указать срок действия ссылки в поле "expires_at" и пользовательский ключ в поле "alias".
+// По умолчанию (mode=atomic) записи сохраняются атомарно. В режиме mode=partial каждая запись обрабатывается
+// отдельно, а в ответе для неё возвращается статус: created, exists (с существующей короткой ссылкой) или invalid.
 //
-// POST /api/shorten/batch
+// POST /api/shorten/batch?mode=atomic|partial
 func (rest Rest) BatchShortenURL(w http.ResponseWriter, r *http.Request) {
 	id, err := context.ID(r.Context()) // Значение uuid добавлено в контекст запроса middleware'й.
 	if err != nil {
-		log.Printf("shortener: Batch: %v", err)
+		rest.logger(r).WithError(err).Error("BatchShortenURL: could not get user ID")
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 
 		return
@@ -213,29 +351,35 @@ func (rest Rest) BatchShortenURL(w http.ResponseWriter, r *http.Request) {
 	dec := json.NewDecoder(r.Body)
 	batchReq := make([]shortener.BatchShortenRequest, 0)
 	if err = dec.Decode(&batchReq); err != nil {
-		log.Printf("shortener: Batch: %v", err)
+		rest.logger(r).WithError(err).Info("BatchShortenURL: could not decode the request")
 		http.Error(w, "Bad request", http.StatusBadRequest)
 
 		return
 	}
 
-	resp, err := rest.shortener.BatchShortenURL(r.Context(), id, batchReq)
+	batchShorten, status := rest.shortener.BatchShortenURL, http.StatusCreated
+	switch r.URL.Query().Get("mode") {
+	case batchModeAtomic, "":
+	case batchModePartial:
+		batchShorten, status = rest.shortener.BatchShortenURLPartial, http.StatusOK
+	default:
+		http.Error(w, "Unknown batch mode", http.StatusBadRequest)
+
+		return
+	}
+	resp, err := batchShorten(r.Context(), id, batchReq)
 	if err != nil {
-		if errors.Is(err, storage.ErrBatchURLUniqueViolation) {
-			http.Error(w, err.Error(), http.StatusConflict)
-		} else {
-			http.Error(w, "Something went wrong", http.StatusInternalServerError)
-		}
-		log.Printf("shortener: Batch: cannot store the records: %v", err)
+		httpError(w, err)
+		rest.logError(r, err, "BatchShortenURL: could not store the records")
 
 		return
 	}
 
 	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
-		log.Printf("shortener: Batch: %v", err)
+		rest.logger(r).WithError(err).Error("BatchShortenURL: could not encode the response")
 
 		return
 	}
@@ -248,7 +392,7 @@ func (rest Rest) BatchShortenURL(w http.ResponseWriter, r *http.Request) {
 func (rest Rest) DeleteURLs(w http.ResponseWriter, r *http.Request) {
 	id, err := context.ID(r.Context()) // Значение uuid добавлено в контекст запроса middleware'й.
 	if err != nil {
-		log.Printf("shortener: delete: %v", err)
+		rest.logger(r).WithError(err).Error("DeleteURLs: could not get user ID")
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 
 		return
@@ -256,7 +400,7 @@ func (rest Rest) DeleteURLs(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("shortener: delete: %v", err)
+		rest.logger(r).WithError(err).Error("DeleteURLs: could not read the request")
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 
 		return
@@ -264,15 +408,15 @@ func (rest Rest) DeleteURLs(w http.ResponseWriter, r *http.Request) {
 
 	var keys []string
 	if err := json.Unmarshal(b, &keys); err != nil {
-		log.Printf("shortener: delete: %v", err)
+		rest.logger(r).WithError(err).Info("DeleteURLs: could not decode the request")
 		http.Error(w, "Wrong format", http.StatusBadRequest)
 
 		return
 	}
 
 	if err := rest.shortener.BatchDelete(r.Context(), id, keys); err != nil {
-		log.Printf("shortener: delete: %v", err)
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		rest.logError(r, err, "DeleteURLs: could not delete URLs")
+		httpError(w, err)
 
 		return
 	}
@@ -291,8 +435,8 @@ func (rest Rest) Stats(w http.ResponseWriter, r *http.Request) {
 	}
 	urls, users, err := rest.shortener.Stats(r.Context())
 	if err != nil {
-		log.Printf("shortener: stats: %v", err)
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		rest.logError(r, err, "Stats: could not get stats")
+		httpError(w, err)
 
 		return
 	}
@@ -301,7 +445,7 @@ func (rest Rest) Stats(w http.ResponseWriter, r *http.Request) {
 		Users: users,
 	}
 	if err := json.NewEncoder(w).Encode(st); err != nil {
-		log.Printf("shortener: stats: %v", err)
+		rest.logger(r).WithError(err).Error("Stats: could not encode the response")
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 
 		return
@@ -19,6 +19,7 @@ import (
 	appContext "github.com/vanamelnik/go-musthave-shortener/internal/app/context"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/dataloader"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/shortener"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/inmem"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/postgres"
 )
@@ -34,6 +35,8 @@ func BenchmarkInmem(b *testing.B) {
 	defer func() {
 		db.Close()
 		require.NoError(b, os.Remove("tmp.db"))
+		require.NoError(b, os.Remove("tmp.db.prev"))
+		require.NoError(b, os.Remove("tmp.db.wal.prev"))
 	}()
 	s := shortener.NewShortener(baseURL, db, dataloader.DataLoader{})
 	api := NewRest(s)
@@ -43,6 +46,39 @@ func BenchmarkInmem(b *testing.B) {
 	b.Run("Decode URLs", getRedirectBenchmark(&api, keys))
 }
 
+// BenchmarkInmemRedirect измеряет время редиректа в зависимости от объёма in-memory хранилища.
+// Благодаря индексам время одного редиректа не должно зависеть от количества записей.
+func BenchmarkInmemRedirect(b *testing.B) {
+	const batchSize = 10000
+	for _, size := range []int{1000, 100000, 1000000} {
+		db, err := inmem.NewDB("tmp.db", time.Hour)
+		require.NoError(b, err)
+		id := uuid.New()
+		keys := make([]string, 0, size)
+		for i := 0; i < size; i += batchSize {
+			records := make([]storage.Record, 0, batchSize)
+			for j := i; j < i+batchSize && j < size; j++ {
+				key := fmt.Sprintf("%08d", j)
+				records = append(records, storage.Record{
+					Key:         key,
+					OriginalURL: fmt.Sprintf("http://%d.com", j),
+				})
+				keys = append(keys, baseURL+"/"+key)
+			}
+			require.NoError(b, db.BatchStore(context.Background(), id, records))
+		}
+		s := shortener.NewShortener(baseURL, db, dataloader.DataLoader{})
+		api := NewRest(s)
+
+		b.Run(fmt.Sprintf("%d rows", size), getRedirectBenchmark(&api, keys))
+
+		db.Close()
+		require.NoError(b, os.Remove("tmp.db"))
+		require.NoError(b, os.Remove("tmp.db.prev"))
+		require.NoError(b, os.Remove("tmp.db.wal.prev"))
+	}
+}
+
 func BenchmarkPostgres(b *testing.B) {
 	db, err := postgres.NewRepo(context.Background(), dsn)
 	require.NoError(b, err)
@@ -0,0 +1,33 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/api/apierror"
+)
+
+// errorStatus сопоставляет ошибку сервиса с кодом ответа HTTP и текстом, возвращаемым клиенту (см. apierror).
+// Ошибка ErrURLArlreadyExists обрабатывается в хендлерах отдельно, т.к. в ответе возвращается существующий адрес.
+func errorStatus(err error) (int, string) {
+	resp := apierror.For(err)
+
+	return resp.HTTPStatus, resp.Message
+}
+
+// logError записывает ошибку err в лог запроса r: ошибки, соответствующие кодам 5xx, - с уровнем error,
+// ошибки клиента - с уровнем info.
+func (rest Rest) logError(r *http.Request, err error, msg string) {
+	log := rest.logger(r).WithError(err)
+	if code, _ := errorStatus(err); code >= http.StatusInternalServerError {
+		log.Error(msg)
+
+		return
+	}
+	log.Info(msg)
+}
+
+// httpError отправляет клиенту ответ с кодом и текстом, соответствующими ошибке err.
+func httpError(w http.ResponseWriter, err error) {
+	code, msg := errorStatus(err)
+	http.Error(w, msg, code)
+}
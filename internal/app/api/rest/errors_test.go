@@ -0,0 +1,43 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/dataloader"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/qrcode"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/shortener"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "Invalid URL", err: fmt.Errorf("%w: abc", shortener.ErrInvalidURL), want: http.StatusBadRequest},
+		{name: "Not found", err: fmt.Errorf("key abc: %w", storage.ErrNotFound), want: http.StatusNotFound},
+		{name: "Invalid alias", err: fmt.Errorf("%w: api", shortener.ErrInvalidAlias), want: http.StatusBadRequest},
+		{name: "Invalid expiry", err: shortener.ErrInvalidExpiry, want: http.StatusBadRequest},
+		{name: "Invalid title", err: shortener.ErrInvalidTitle, want: http.StatusBadRequest},
+		{name: "Forbidden destination", err: fmt.Errorf("%w: evil.com is blocked", shortener.ErrForbiddenDestination), want: http.StatusUnprocessableEntity},
+		{name: "Invalid QR code options", err: fmt.Errorf("%w: size must be between 32 and 2048", qrcode.ErrInvalidOptions), want: http.StatusBadRequest},
+		{name: "Deleted", err: storage.ErrDeleted, want: http.StatusGone},
+		{name: "Expired", err: storage.ErrExpired, want: http.StatusGone},
+		{name: "Batch URL violation", err: storage.ErrBatchURLUniqueViolation, want: http.StatusConflict},
+		{name: "Key collision", err: fmt.Errorf("key abc: %w", storage.ErrKeyCollision), want: http.StatusConflict},
+		{name: "Unavailable", err: &storage.UnavailableError{Err: errors.New("connection refused")}, want: http.StatusServiceUnavailable},
+		{name: "Service is stopping", err: fmt.Errorf("delete: %w", dataloader.ErrClosed), want: http.StatusServiceUnavailable},
+		{name: "Unknown", err: errors.New("unknown"), want: http.StatusInternalServerError},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code, _ := errorStatus(tc.err)
+			assert.Equal(t, tc.want, code)
+		})
+	}
+}
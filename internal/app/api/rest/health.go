@@ -0,0 +1,46 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/health"
+)
+
+// Healthz сообщает, что процесс сервиса жив и обрабатывает запросы. Состояние компонентов не проверяется.
+//
+// GET /healthz
+func (rest Rest) Healthz(w http.ResponseWriter, r *http.Request) {
+	rest.writeHealth(w, r, http.StatusOK, health.Report{Status: health.StatusUp})
+}
+
+// Readyz проверяет готовность сервиса к обработке запросов: соединение с хранилищем, сохранение снимков
+// in-memory хранилища, очередь DataLoader и состояние gRPC-сервера. Если какой-либо компонент не готов,
+// возвращается статус 503.
+//
+// GET /readyz
+func (rest Rest) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := rest.health.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusUp {
+		log := rest.logger(r)
+		for name, check := range report.Checks {
+			if check.Status != health.StatusUp {
+				log = log.WithField(name, check.Error)
+			}
+		}
+		log.Warn("Readyz: service is not ready")
+		status = http.StatusServiceUnavailable
+	}
+	rest.writeHealth(w, r, status, report)
+}
+
+// writeHealth отправляет результат проверки report в формате JSON со статусом status.
+func (rest Rest) writeHealth(w http.ResponseWriter, r *http.Request, status int, report health.Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		rest.logger(r).WithError(err).Error("could not encode the health report")
+	}
+}
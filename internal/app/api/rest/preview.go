@@ -0,0 +1,70 @@
+package rest
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+// previewTemplate - HTML-шаблон страницы предпросмотра короткой ссылки.
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 4em auto; padding: 0 1em; color: #222; }
+.url { word-break: break-all; font-family: monospace; background: #f4f4f4; padding: .5em; }
+.meta { color: #666; font-size: .9em; }
+a.button { display: inline-block; margin-top: 1em; padding: .6em 1.2em; background: #2a6ad8; color: #fff; text-decoration: none; border-radius: 4px; }
+</style>
+</head>
+<body>
+<h1>{{if .Title}}{{.Title}}{{else}}You are leaving {{.ShortURL}}{{end}}</h1>
+<p>This short link leads to:</p>
+<p class="url">{{.OriginalURL}}</p>
+<p class="meta">Short link: {{.ShortURL}}
+{{- if not .CreatedAt.IsZero}}<br>Created: {{.CreatedAt.UTC.Format "2006-01-02 15:04 MST"}}{{end}}
+{{- if not .ExpiresAt.IsZero}}<br>Expires: {{.ExpiresAt.UTC.Format "2006-01-02 15:04 MST"}}{{end}}</p>
+<p>Make sure you trust the destination before you continue.</p>
+<a class="button" href="{{.OriginalURL}}" rel="noreferrer nofollow">Continue</a>
+</body>
+</html>
+`))
+
+// previewPage - данные страницы предпросмотра.
+type previewPage struct {
+	Title       string
+	OriginalURL string
+	ShortURL    string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// PreviewURL показывает страницу предпросмотра короткой ссылки вместо перенаправления:
+// адрес назначения, дату создания и заголовок ссылки. Тот же результат даёт запрос GET /{id}?preview=1.
+//
+// GET /{id}+
+func (rest Rest) PreviewURL(w http.ResponseWriter, r *http.Request) {
+	rest.decodeURL(w, r, true)
+}
+
+// renderPreview отправляет клиенту страницу предпросмотра ссылки rec.
+func (rest Rest) renderPreview(w http.ResponseWriter, r *http.Request, rec storage.Record) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err := previewTemplate.Execute(w, previewPage{
+		Title:       rec.Title,
+		OriginalURL: rec.OriginalURL,
+		ShortURL:    rest.shortener.BaseURL + "/" + rec.Key,
+		CreatedAt:   rec.CreatedAt,
+		ExpiresAt:   rec.ExpiresAt,
+	})
+	if err != nil {
+		rest.logger(r).WithError(err).Error("PreviewURL: could not render the page")
+	}
+}
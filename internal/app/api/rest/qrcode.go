@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/qrcode"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/shortener"
+)
+
+// QRCode возвращает изображение QR-кода короткой ссылки. Параметры изображения передаются в строке запроса:
+//
+//	format - png (по умолчанию) или svg;
+//	size   - ширина и высота изображения в пикселях (по умолчанию 256);
+//	level  - уровень коррекции ошибок L, M (по умолчанию), Q или H;
+//	margin - ширина пустого поля вокруг кода в модулях (по умолчанию 4).
+//
+// Просмотр QR-кода не учитывается как переход по ссылке.
+//
+// GET /{id}/qr
+func (rest Rest) QRCode(w http.ResponseWriter, r *http.Request) {
+	key, ok := mux.Vars(r)["id"]
+	if !ok || !shortener.ValidKey(key) {
+		rest.logger(r).WithField("key", key).Info("QRCode: wrong key")
+		http.Error(w, "Wrong key", http.StatusBadRequest)
+
+		return
+	}
+	opts, err := qrOptions(r)
+	if err != nil {
+		rest.logger(r).WithError(err).Info("QRCode: wrong parameters")
+		http.Error(w, "Wrong QR code parameters", http.StatusBadRequest)
+
+		return
+	}
+	img, err := rest.shortener.QRCode(r.Context(), key, opts)
+	if err != nil {
+		rest.logError(r, err, "QRCode: could not create the QR code")
+		httpError(w, err)
+
+		return
+	}
+	w.Header().Set("Content-Type", opts.ContentType())
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	// nolint:errcheck
+	w.Write(img)
+}
+
+// qrOptions считывает параметры изображения QR-кода из строки запроса.
+func qrOptions(r *http.Request) (qrcode.Options, error) {
+	opts := qrcode.DefaultOptions()
+	q := r.URL.Query()
+	if format := q.Get("format"); format != "" {
+		opts.Format = format
+	}
+	if level := q.Get("level"); level != "" {
+		opts.Level = level
+	}
+	if size := q.Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return qrcode.Options{}, err
+		}
+		opts.Size = n
+	}
+	if margin := q.Get("margin"); margin != "" {
+		n, err := strconv.Atoi(margin)
+		if err != nil {
+			return qrcode.Options{}, err
+		}
+		opts.Margin = n
+	}
+
+	return opts, nil
+}
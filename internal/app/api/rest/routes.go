@@ -5,23 +5,60 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/config"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/metrics"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/tracing"
 	"github.com/vanamelnik/go-musthave-shortener/pkg/middleware"
 )
 
 // SetupRoutes устанавливает пути для обработчиков ендпоинтов REST API.
 func (rest Rest) SetupRoutes(cfg config.Config, router *mux.Router) {
-	router.HandleFunc("/ping", rest.Ping).Methods(http.MethodGet)
+	router.Handle("/ping", traced("Ping", rest.Ping)).Methods(http.MethodGet)
+	router.Handle("/healthz", traced("Healthz", rest.Healthz)).Methods(http.MethodGet)
+	router.Handle("/readyz", traced("Readyz", rest.Readyz)).Methods(http.MethodGet)
 
-	router.HandleFunc("/{id}", rest.DecodeURL).Methods(http.MethodGet)
-	router.HandleFunc("/", rest.ShortenURL).Methods(http.MethodPost)
-	router.HandleFunc("/api/shorten", rest.APIShortenURL).Methods(http.MethodPost)
-	router.HandleFunc("/api/shorten/batch", rest.BatchShortenURL).Methods(http.MethodPost)
-	router.HandleFunc("/api/user/urls", rest.UserURLs).Methods(http.MethodGet)
-	router.HandleFunc("/api/user/urls", rest.DeleteURLs).Methods(http.MethodDelete)
+	shortenLimit := middleware.RateLimitMdlw(rateLimiter(cfg.ShortenRateLimit), cfg.TrustedProxy)
+	batchLimit := middleware.RateLimitMdlw(rateLimiter(cfg.BatchRateLimit), cfg.TrustedProxy)
+	redirectLimit := middleware.RateLimitMdlw(rateLimiter(cfg.RedirectRateLimit), cfg.TrustedProxy)
+
+	router.Handle("/{id}/qr", redirectLimit(traced("QRCode", rest.QRCode))).Methods(http.MethodGet)
+	router.Handle("/{id}+", redirectLimit(traced("PreviewURL", rest.PreviewURL))).Methods(http.MethodGet)
+	router.Handle("/{id}", redirectLimit(traced("DecodeURL", rest.DecodeURL))).Methods(http.MethodGet)
+	router.Handle("/", shortenLimit(traced("ShortenURL", rest.ShortenURL))).Methods(http.MethodPost)
+	router.Handle("/api/shorten", shortenLimit(traced("APIShortenURL", rest.APIShortenURL))).Methods(http.MethodPost)
+	router.Handle("/api/shorten/batch", batchLimit(traced("BatchShortenURL", rest.BatchShortenURL))).Methods(http.MethodPost)
+	router.Handle("/api/user/urls", traced("UserURLs", rest.UserURLs)).Methods(http.MethodGet)
+	router.Handle("/api/user/urls", traced("DeleteURLs", rest.DeleteURLs)).Methods(http.MethodDelete)
+	router.Handle("/api/user/urls/{key}/stats", traced("ClickStats", rest.ClickStats)).Methods(http.MethodGet)
 
 	internal := router.PathPrefix("/api/internal").Subrouter()
-	internal.HandleFunc("/stats", rest.Stats).Methods(http.MethodGet)
+	internal.Handle("/stats", traced("Stats", rest.Stats)).Methods(http.MethodGet)
+	if cfg.MetricsAddress == "" {
+		internal.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	}
 	internal.Use(middleware.SubnetCheckerMdlw(cfg.TrustedSubnet))
 
-	router.Use(middleware.CookieMdlw(cfg.Secret), middleware.GzipMdlw)
+	router.Use(
+		middleware.MetricsMdlw,
+		middleware.TracingMdlw,
+		middleware.LoggerMdlw(rest.log),
+		middleware.CookieMdlw(cfg.Secret),
+		middleware.GzipMdlw,
+	)
+}
+
+// traced создаёт для каждого вызова обработчика h дочерний спан спана запроса с именем rest.<name>.
+// Разница между спаном запроса и спаном обработчика - время работы middleware.
+func traced(name string, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Tracer().Start(r.Context(), "rest."+name)
+		defer span.End()
+
+		h(w, r.WithContext(ctx))
+	})
+}
+
+// rateLimiter создаёт ограничитель частоты запросов с параметрами rl. Ограничители создаются для каждого
+// набора эндпоинтов отдельно, поэтому клиент расходует ограничения разных наборов независимо.
+func rateLimiter(rl config.RateLimit) *middleware.RateLimiter {
+	return middleware.NewRateLimiter(rl.Rate, rl.Burst)
 }
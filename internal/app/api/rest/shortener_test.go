@@ -2,12 +2,12 @@ package rest
 
 import (
 	"context"
-	"errors"
+	"image/png"
 	"io"
-	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -16,9 +16,13 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	grpchealth "google.golang.org/grpc/health"
 
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/analytics"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/config"
 	appContext "github.com/vanamelnik/go-musthave-shortener/internal/app/context"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/dataloader"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/health"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/shortener"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/inmem"
@@ -26,7 +30,6 @@ import (
 
 // TestShortener - комплексный тест, прогоняющий все виды запросов к inmemory хранилищу.
 func TestShortener(t *testing.T) {
-	rand.Seed(time.Now().UnixNano())
 	type want struct {
 		statusCode int
 		body       string
@@ -39,7 +42,7 @@ func TestShortener(t *testing.T) {
 		"",            // заполняется POST тестом №1
 		"",            // заполняется POST тестом №2
 		"qwertyui",    // этого ключа нет в базе
-		"favicon.ico", // ключ неверной длины
+		"favicon.ico", // ключ с недопустимым символом
 	}
 	testsPost := []struct {
 		name    string
@@ -127,6 +130,8 @@ func TestShortener(t *testing.T) {
 	defer func() {
 		db.Close()
 		require.NoError(t, os.Remove("tmp.db"))
+		require.NoError(t, os.Remove("tmp.db.prev"))
+		require.NoError(t, os.Remove("tmp.db.wal.prev"))
 	}()
 
 	s := shortener.NewShortener("http://localhost:8080", db, dataloader.DataLoader{})
@@ -189,12 +194,16 @@ var _ storage.Storage = (*MockStorage)(nil)
 type MockStorage struct {
 }
 
-func (ms MockStorage) Store(ctx context.Context, id uuid.UUID, key, url string) error {
+func (ms MockStorage) Store(ctx context.Context, id uuid.UUID, rec storage.Record) error {
 	return nil // имитирует сохранение ключа в базе, ошибок быть не может
 }
 
 func (ms MockStorage) Get(ctx context.Context, key string) (string, error) {
-	return "", errors.New("Mock error") // Ошибка - элемент не найден (используется в цикле проверки уникальности)
+	return "", storage.ErrNotFound // элемент не найден
+}
+
+func (ms MockStorage) Lookup(ctx context.Context, key string) (storage.Record, error) {
+	return storage.Record{}, storage.ErrNotFound
 }
 
 func (ms MockStorage) GetAll(ctx context.Context, id uuid.UUID) map[string]string {
@@ -219,9 +228,23 @@ func (ms MockStorage) Stats(ctx context.Context) (urls int, users int, err error
 	return 0, 0, nil
 }
 
+func (ms MockStorage) StoreClicks(ctx context.Context, clicks []storage.Click) error {
+	return nil
+}
+
+func (ms MockStorage) ClickStats(ctx context.Context, id uuid.UUID, key string) (storage.ClickStats, error) {
+	return storage.ClickStats{}, storage.ErrNotFound
+}
+
+// fixedKeyGenerator - генератор, всегда возвращающий один и тот же ключ.
+type fixedKeyGenerator string
+
+func (g fixedKeyGenerator) Generate() (string, error) {
+	return string(g), nil
+}
+
 func TestAPIShorten(t *testing.T) {
-	rand.Seed(1)
-	const fakeKey = "fpllngzi" // Первый ключ, генерируемый при rand.Seed(1)
+	const fakeKey = "fpllngzi"
 	type want struct {
 		contentType string
 		body        string
@@ -259,8 +282,27 @@ func TestAPIShorten(t *testing.T) {
 				statusCode:  http.StatusBadRequest,
 			},
 		},
+		{
+			name: "#4 Valid request with expiration time",
+			body: `{"url" : "http://shetube.com", "expires_at": "2999-01-01T00:00:00Z"}`,
+			want: want{
+				contentType: "application/json",
+				body:        `{"result":"http://localhost:8080/` + fakeKey + `"}`,
+				statusCode:  http.StatusCreated,
+			},
+		},
+		{
+			name: "#5 Expiration time in the past",
+			body: `{"url" : "http://shetube.com", "expires_at": "2000-01-01T00:00:00Z"}`,
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				body:        "Expiration time must be in the future",
+				statusCode:  http.StatusBadRequest,
+			},
+		},
 	}
-	s := shortener.NewShortener("http://localhost:8080", &MockStorage{}, dataloader.DataLoader{})
+	s := shortener.NewShortener("http://localhost:8080", &MockStorage{}, dataloader.DataLoader{},
+		shortener.WithKeyGenerator(fixedKeyGenerator(fakeKey)))
 	api := NewRest(s)
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -285,3 +327,393 @@ func TestAPIShorten(t *testing.T) {
 		})
 	}
 }
+
+// TestAPIShortenAlias проверяет создание коротких ссылок с пользовательским ключом и переход по ним.
+func TestAPIShortenAlias(t *testing.T) {
+	db, err := inmem.NewDB("tmp_alias.db", time.Hour)
+	require.NoError(t, err)
+	defer func() {
+		db.Close()
+		require.NoError(t, os.Remove("tmp_alias.db"))
+		require.NoError(t, os.Remove("tmp_alias.db.prev"))
+		require.NoError(t, os.Remove("tmp_alias.db.wal.prev"))
+	}()
+	s := shortener.NewShortener("http://localhost:8080", db, dataloader.DataLoader{})
+	api := NewRest(s)
+
+	testCases := []struct {
+		name       string
+		body       string
+		statusCode int
+		wantBody   string
+	}{
+		{
+			name:       "#1 New alias",
+			body:       `{"url": "http://example.com/sale", "alias": "spring-sale"}`,
+			statusCode: http.StatusCreated,
+			wantBody:   `{"result":"http://localhost:8080/spring-sale"}`,
+		},
+		{
+			name:       "#2 Alias is taken",
+			body:       `{"url": "http://example.com/other", "alias": "spring-sale"}`,
+			statusCode: http.StatusConflict,
+			wantBody:   storage.ErrKeyCollision.Error(),
+		},
+		{
+			name:       "#3 Reserved word",
+			body:       `{"url": "http://example.com/other", "alias": "API"}`,
+			statusCode: http.StatusBadRequest,
+			wantBody:   "Wrong alias",
+		},
+		{
+			name:       "#4 Wrong characters",
+			body:       `{"url": "http://example.com/other", "alias": "spring sale"}`,
+			statusCode: http.StatusBadRequest,
+			wantBody:   "Wrong alias",
+		},
+		{
+			name:       "#5 Too short",
+			body:       `{"url": "http://example.com/other", "alias": "ab"}`,
+			statusCode: http.StatusBadRequest,
+			wantBody:   "Wrong alias",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(tc.body))
+			r = r.WithContext(appContext.WithID(r.Context(), uuid.New()))
+			w := httptest.NewRecorder()
+			http.HandlerFunc(api.APIShortenURL).ServeHTTP(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tc.statusCode, res.StatusCode)
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			if res.StatusCode == http.StatusCreated {
+				assert.JSONEq(t, tc.wantBody, string(body))
+			} else {
+				assert.Equal(t, tc.wantBody, strings.TrimSpace(string(body)))
+			}
+		})
+	}
+
+	r := httptest.NewRequest("GET", "/spring-sale", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "spring-sale"})
+	w := httptest.NewRecorder()
+	http.HandlerFunc(api.DecodeURL).ServeHTTP(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+	assert.Equal(t, "http://example.com/sale", res.Header.Get("Location"))
+}
+
+func TestBatchShortenURL(t *testing.T) {
+	db, err := inmem.NewDB(filepath.Join(t.TempDir(), "test.db"), time.Hour)
+	require.NoError(t, err)
+	defer db.Close()
+	s := shortener.NewShortener("http://localhost:8080", db, dataloader.DataLoader{})
+	api := NewRest(s)
+	_, err = s.ShortenURL(context.Background(), uuid.New(), "http://example.com/old", shortener.WithAlias("old"))
+	require.NoError(t, err)
+
+	const batch = `[
+		{"correlation_id": "1", "original_url": "http://example.com/new", "alias": "new"},
+		{"correlation_id": "2", "original_url": "http://example.com/old"},
+		{"correlation_id": "3", "original_url": "example.com"},
+		{"correlation_id": "4", "original_url": "http://example.com/other", "alias": "old"}
+	]`
+	testCases := []struct {
+		name       string
+		mode       string
+		statusCode int
+		wantBody   string
+	}{
+		{
+			name:       "#1 Atomic mode fails on invalid URL",
+			mode:       "",
+			statusCode: http.StatusBadRequest,
+			wantBody:   "Wrong URL",
+		},
+		{
+			name:       "#2 Unknown mode",
+			mode:       "best-effort",
+			statusCode: http.StatusBadRequest,
+			wantBody:   "Unknown batch mode",
+		},
+		{
+			name:       "#3 Partial mode",
+			mode:       "partial",
+			statusCode: http.StatusOK,
+			wantBody: `[
+				{"correlation_id": "1", "short_url": "http://localhost:8080/new", "status": "created"},
+				{"correlation_id": "2", "short_url": "http://localhost:8080/old", "status": "exists"},
+				{"correlation_id": "3", "status": "invalid", "error": "wrong URL: example.com"},
+				{"correlation_id": "4", "status": "invalid", "error": "alias is already taken"}
+			]`,
+		},
+		{
+			name:       "#4 Partial mode repeated",
+			mode:       "partial",
+			statusCode: http.StatusOK,
+			wantBody: `[
+				{"correlation_id": "1", "short_url": "http://localhost:8080/new", "status": "exists"},
+				{"correlation_id": "2", "short_url": "http://localhost:8080/old", "status": "exists"},
+				{"correlation_id": "3", "status": "invalid", "error": "wrong URL: example.com"},
+				{"correlation_id": "4", "status": "invalid", "error": "alias is already taken"}
+			]`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/shorten/batch?mode="+tc.mode, strings.NewReader(batch))
+			r = r.WithContext(appContext.WithID(r.Context(), uuid.New()))
+			w := httptest.NewRecorder()
+			http.HandlerFunc(api.BatchShortenURL).ServeHTTP(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tc.statusCode, res.StatusCode)
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			if res.StatusCode == http.StatusOK {
+				assert.JSONEq(t, tc.wantBody, string(body))
+			} else {
+				assert.Equal(t, tc.wantBody, strings.TrimSpace(string(body)))
+			}
+		})
+	}
+}
+
+// TestClickStats проверяет учёт переходов по короткой ссылке и выдачу статистики её создателю.
+func TestClickStats(t *testing.T) {
+	db, err := inmem.NewDB("tmp_clicks.db", time.Hour)
+	require.NoError(t, err)
+	defer func() {
+		db.Close()
+		require.NoError(t, os.Remove("tmp_clicks.db"))
+		require.NoError(t, os.Remove("tmp_clicks.db.prev"))
+		require.NoError(t, os.Remove("tmp_clicks.db.wal.prev"))
+	}()
+	ctx := context.Background()
+	owner := uuid.New()
+	require.NoError(t, db.Store(ctx, owner, storage.Record{Key: "promo", OriginalURL: "http://example.com"}))
+
+	clicks := analytics.NewRecorder(ctx, db.StoreClicks, time.Hour)
+	api := NewRest(shortener.NewShortener("http://localhost:8080", db, dataloader.DataLoader{},
+		shortener.WithClickRecorder(clicks)))
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("GET", "/promo", nil)
+		r = mux.SetURLVars(r, map[string]string{"id": "promo"})
+		w := httptest.NewRecorder()
+		http.HandlerFunc(api.DecodeURL).ServeHTTP(w, r)
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	}
+	// просмотр страницы предпросмотра не учитывается как переход
+	r := httptest.NewRequest("GET", "/promo?preview=1", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "promo"})
+	w := httptest.NewRecorder()
+	http.HandlerFunc(api.DecodeURL).ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	clicks.Close() // сохраняем накопленные переходы
+
+	getStats := func(id uuid.UUID) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/user/urls/promo/stats", nil)
+		r = mux.SetURLVars(r, map[string]string{"key": "promo"})
+		r = r.WithContext(appContext.WithID(r.Context(), id))
+		w := httptest.NewRecorder()
+		http.HandlerFunc(api.ClickStats).ServeHTTP(w, r)
+		return w
+	}
+
+	w = getStats(owner)
+	require.Equal(t, http.StatusOK, w.Code)
+	today := time.Now().UTC().Format("2006-01-02")
+	assert.JSONEq(t, `{"short_url":"http://localhost:8080/promo","total":2,"daily":[{"date":"`+today+`","clicks":2}]}`,
+		w.Body.String())
+
+	w = getStats(uuid.New())
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPreview(t *testing.T) {
+	db, err := inmem.NewDB(filepath.Join(t.TempDir(), "test.db"), time.Hour)
+	require.NoError(t, err)
+	defer db.Close()
+	s := shortener.NewShortener("http://localhost:8080", db, dataloader.DataLoader{})
+	router := mux.NewRouter()
+	NewRest(s).SetupRoutes(config.Config{}, router)
+
+	ctx := context.Background()
+	_, err = s.ShortenURL(ctx, uuid.New(), "http://example.com/sale?a=1&b=2",
+		shortener.WithAlias("sale"), shortener.WithTitle("Spring <sale>"))
+	require.NoError(t, err)
+	_, err = s.ShortenURL(ctx, uuid.New(), "http://untrusted.example.com",
+		shortener.WithAlias("untrusted"), shortener.WithAlwaysPreview(true))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		target      string
+		statusCode  int
+		location    string
+		wantInBody  []string
+		notWantBody []string
+	}{
+		{
+			name:       "#1 Redirect",
+			target:     "/sale",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "http://example.com/sale?a=1&b=2",
+		},
+		{
+			name:       "#2 Preview by plus sign",
+			target:     "/sale+",
+			statusCode: http.StatusOK,
+			wantInBody: []string{
+				"Spring &lt;sale&gt;",
+				`href="http://example.com/sale?a=1&amp;b=2"`,
+				"http://localhost:8080/sale",
+				"Created: " + time.Now().UTC().Format("2006-01-02"),
+			},
+			notWantBody: []string{"<sale>"},
+		},
+		{
+			name:       "#3 Preview by query parameter",
+			target:     "/sale?preview=1",
+			statusCode: http.StatusOK,
+			wantInBody: []string{"Spring &lt;sale&gt;"},
+		},
+		{
+			name:       "#4 Always preview",
+			target:     "/untrusted",
+			statusCode: http.StatusOK,
+			wantInBody: []string{"http://untrusted.example.com", "You are leaving http://localhost:8080/untrusted"},
+		},
+		{
+			name:       "#5 Preview of a missing key",
+			target:     "/missing+",
+			statusCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tc.target, nil))
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, tc.statusCode, res.StatusCode)
+			assert.Equal(t, tc.location, res.Header.Get("Location"))
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			for _, want := range tc.wantInBody {
+				assert.Contains(t, string(body), want)
+			}
+			for _, notWant := range tc.notWantBody {
+				assert.NotContains(t, string(body), notWant)
+			}
+		})
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	db, err := inmem.NewDB(filepath.Join(t.TempDir(), "test.db"), time.Hour)
+	require.NoError(t, err)
+	defer db.Close()
+	s := shortener.NewShortener("http://localhost:8080", db, dataloader.DataLoader{})
+	router := mux.NewRouter()
+	NewRest(s).SetupRoutes(config.Config{}, router)
+	_, err = s.ShortenURL(context.Background(), uuid.New(), "http://example.com/sale", shortener.WithAlias("sale"))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		target      string
+		statusCode  int
+		contentType string
+		size        int
+	}{
+		{name: "#1 Default PNG", target: "/sale/qr", statusCode: http.StatusOK, contentType: "image/png", size: 256},
+		{name: "#2 PNG with parameters", target: "/sale/qr?size=512&level=H&margin=0", statusCode: http.StatusOK, contentType: "image/png", size: 512},
+		{name: "#3 SVG", target: "/sale/qr?format=svg&size=300", statusCode: http.StatusOK, contentType: "image/svg+xml"},
+		{name: "#4 Unknown format", target: "/sale/qr?format=gif", statusCode: http.StatusBadRequest},
+		{name: "#5 Wrong size", target: "/sale/qr?size=big", statusCode: http.StatusBadRequest},
+		{name: "#6 Size out of range", target: "/sale/qr?size=10000", statusCode: http.StatusBadRequest},
+		{name: "#7 Missing key", target: "/missing/qr", statusCode: http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tc.target, nil))
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, tc.statusCode, res.StatusCode)
+			if tc.contentType == "" {
+				return
+			}
+			assert.Equal(t, tc.contentType, res.Header.Get("Content-Type"))
+			if tc.size == 0 {
+				body, err := io.ReadAll(res.Body)
+				require.NoError(t, err)
+				assert.Contains(t, string(body), "<svg")
+				return
+			}
+			img, err := png.Decode(res.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.size, img.Bounds().Dx())
+		})
+	}
+
+}
+
+// TestHealth проверяет эндпоинты /healthz и /readyz.
+func TestHealth(t *testing.T) {
+	db, err := inmem.NewDB(filepath.Join(t.TempDir(), "test.db"), time.Hour)
+	require.NoError(t, err)
+	s := shortener.NewShortener("http://localhost:8080", db, dataloader.DataLoader{})
+	hs := grpchealth.NewServer()
+	checker := health.NewChecker()
+	checker.Add("storage", health.Ping(db.Ping))
+	checker.Add("snapshot", health.Snapshot(db.LastFlush))
+	checker.Add("dataloader", health.Backlog(func() int { return 3 }))
+	checker.Add("grpc", health.GRPC(hs))
+	router := mux.NewRouter()
+	NewRest(s, WithHealthChecker(checker)).SetupRoutes(config.NewConfig(), router)
+
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+
+		return res.StatusCode, string(body)
+	}
+
+	code, body := get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"status": "up", "checks": {
+		"storage":    {"status": "up"},
+		"snapshot":   {"status": "up"},
+		"dataloader": {"status": "up", "details": {"backlog": 3}},
+		"grpc":       {"status": "up", "details": {"status": "SERVING"}}
+	}}`, body)
+
+	// сервис остановлен: gRPC-сервер не принимает вызовы, хранилище закрыто, но процесс жив
+	hs.Shutdown()
+	db.Close()
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.JSONEq(t, `{"status": "down", "checks": {
+		"storage":    {"status": "down", "error": "Storage is unavailable: storage is closed"},
+		"snapshot":   {"status": "up"},
+		"dataloader": {"status": "up", "details": {"backlog": 3}},
+		"grpc":       {"status": "down", "error": "server is not serving", "details": {"status": "NOT_SERVING"}}
+	}}`, body)
+
+	code, body = get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"status": "up"}`, body)
+}
@@ -4,19 +4,23 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/tracing"
 )
 
 // Провайдеры хранилища
 const (
 	DBInmem    = "inmem"
 	DBPostgres = "postgres"
+	DBBolt     = "bolt"
 )
 
 // Значения по умолчанию
@@ -25,6 +29,28 @@ const (
 
 	defaultDeleteFlushInterval = time.Millisecond
 
+	defaultClickFlushInterval = time.Second
+
+	defaultPolicyReloadInterval = 10 * time.Second
+
+	defaultShutdownTimeout = 10 * time.Second
+
+	defaultShortenRate   = 10
+	defaultShortenBurst  = 20
+	defaultBatchRate     = 1
+	defaultBatchBurst    = 5
+	defaultRedirectRate  = 100
+	defaultRedirectBurst = 200
+
+	logLevelDefault  = "info"
+	logFormatDefault = logger.FormatLogfmt
+
+	otlpEndpointDefault = "http://localhost:4318"
+
+	keyGeneratorDefault = "random"
+	keyLengthDefault    = 8
+	keyAlphabetDefault  = "abcdefghijklmnopqrstuvwxyz1234567890"
+
 	fileStorageDefault = "localhost.db"
 	baseURLDefault     = "http://localhost:8080"
 	srvAddrDefault     = ":8080"
@@ -34,6 +60,21 @@ const (
 	DefaultCfgFileName = "config.json"
 )
 
+// RateLimit - ограничение частоты запросов одного клиента: в среднем не более Rate запросов в секунду
+// и не более Burst запросов подряд. Нулевое значение Rate отключает ограничение.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (rl RateLimit) String() string {
+	if rl.Rate <= 0 {
+		return "off"
+	}
+
+	return strconv.FormatFloat(rl.Rate, 'f', -1, 64) + "rps/" + strconv.Itoa(rl.Burst)
+}
+
 // Config определяет базовую конфигурацию сервиса.
 type Config struct {
 	BaseURL             string        `json:"base_url"`
@@ -45,9 +86,49 @@ type Config struct {
 	EnableHTTPS         bool          `json:"enable_https"`
 	InmemFlushInterval  time.Duration `json:"inmem_flush_interval"`
 	DeleteFlushInterval time.Duration `json:"delete_flush_interval"`
+	ClickFlushInterval  time.Duration `json:"click_flush_interval"`
 	TrustedSubnet       string        `json:"trusted_subnet"`
 	PprofAddress        string        `json:"pprof_address"`
 	GRPCPort            string        `json:"grpc_port"`
+	// TrustedProxy - подсеть обратного прокси, которому сервис доверяет заголовок X-Real-IP
+	// при определении адреса клиента для ограничения частоты запросов и статистики переходов.
+	TrustedProxy string `json:"trusted_proxy"`
+	// ShutdownTimeout - время, отведённое при остановке сервиса на завершение обрабатываемых запросов
+	// и на закрытие каждого из фоновых сервисов.
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+	// MetricsAddress - адрес отдельного сервера метрик Prometheus. Если не задан, метрики отдаются
+	// основным сервером по пути /api/internal/metrics.
+	MetricsAddress string `json:"metrics_address"`
+	// TraceExporter - экспортёр спанов трассировки: otlp, stdout или пустая строка (трассировка отключена).
+	// OTLPEndpoint - адрес коллектора OpenTelemetry для экспортёра otlp.
+	TraceExporter string `json:"trace_exporter"`
+	OTLPEndpoint  string `json:"otlp_endpoint"`
+	// KeyGenerator - стратегия генерации ключей: random, base62 или counter.
+	KeyGenerator string `json:"key_generator"`
+	KeyLength    int    `json:"key_length"`
+	// KeyAlphabet - символы, из которых состоят генерируемые ключи (не используется стратегией base62).
+	KeyAlphabet string `json:"key_alphabet"`
+	// AllowedSchemes - схемы URL, которые разрешено сокращать.
+	AllowedSchemes []string `json:"allowed_schemes"`
+	// SortQueryParams, StripURLFragment и StripTrailingSlash включают дополнительные шаги нормализации URL:
+	// сортировку параметров запроса, удаление фрагмента и удаление завершающего '/' из пути.
+	SortQueryParams    bool `json:"sort_query_params"`
+	StripURLFragment   bool `json:"strip_url_fragment"`
+	StripTrailingSlash bool `json:"strip_trailing_slash"`
+	// BlocklistFile и AllowlistFile - файлы со списками запрещённых и разрешённых доменов.
+	// Изменения в файлах применяются без перезапуска сервиса с периодичностью PolicyReloadInterval.
+	BlocklistFile        string        `json:"blocklist_file"`
+	AllowlistFile        string        `json:"allowlist_file"`
+	PolicyReloadInterval time.Duration `json:"policy_reload_interval"`
+	// ShortenRateLimit, BatchRateLimit и RedirectRateLimit ограничивают частоту запросов одного клиента
+	// на сокращение URL, пакетное сокращение и переход по короткой ссылке соответственно.
+	ShortenRateLimit  RateLimit `json:"shorten_rate_limit"`
+	BatchRateLimit    RateLimit `json:"batch_rate_limit"`
+	RedirectRateLimit RateLimit `json:"redirect_rate_limit"`
+	// LogLevel - минимальный уровень записей лога (debug, info, warn, error).
+	// LogFormat - формат вывода лога: json или logfmt.
+	LogLevel  string `json:"log_level"`
+	LogFormat string `json:"log_format"`
 }
 
 func (cfg Config) String() string {
@@ -57,6 +138,8 @@ func (cfg Config) String() string {
 	b.WriteString(" secret='*****'")
 	b.WriteString(" dbType='" + cfg.DBType + "'")
 	b.WriteString(" deleteFlushInterval=" + cfg.DeleteFlushInterval.String())
+	b.WriteString(" clickFlushInterval=" + cfg.ClickFlushInterval.String())
+	b.WriteString(" shutdownTimeout=" + cfg.ShutdownTimeout.String())
 	if cfg.StorageFileName != "" {
 		b.WriteString(" fileName='" + cfg.StorageFileName + "'")
 	}
@@ -69,12 +152,50 @@ func (cfg Config) String() string {
 	if cfg.TrustedSubnet != "" {
 		b.WriteString(" trustedSubnet=" + cfg.TrustedSubnet)
 	}
+	if cfg.TrustedProxy != "" {
+		b.WriteString(" trustedProxy=" + cfg.TrustedProxy)
+	}
 	if cfg.PprofAddress != "" {
 		b.WriteString(" pprofAddress=" + cfg.PprofAddress)
 	}
+	if cfg.MetricsAddress != "" {
+		b.WriteString(" metricsAddress=" + cfg.MetricsAddress)
+	}
+	if cfg.TraceExporter != "" {
+		b.WriteString(" traceExporter=" + cfg.TraceExporter)
+	}
+	if cfg.TraceExporter == tracing.ExporterOTLP {
+		b.WriteString(" otlpEndpoint=" + cfg.OTLPEndpoint)
+	}
 	if cfg.GRPCPort != "" {
 		b.WriteString(" gRPCAddress=" + cfg.GRPCPort)
 	}
+	b.WriteString(" keyGenerator=" + cfg.KeyGenerator)
+	b.WriteString(" keyLength=" + strconv.Itoa(cfg.KeyLength))
+	b.WriteString(" keyAlphabet='" + cfg.KeyAlphabet + "'")
+	b.WriteString(" allowedSchemes=" + strings.Join(cfg.AllowedSchemes, ","))
+	if cfg.SortQueryParams {
+		b.WriteString(" sortQueryParams: yes")
+	}
+	if cfg.StripURLFragment {
+		b.WriteString(" stripURLFragment: yes")
+	}
+	if cfg.StripTrailingSlash {
+		b.WriteString(" stripTrailingSlash: yes")
+	}
+	if cfg.BlocklistFile != "" {
+		b.WriteString(" blocklistFile='" + cfg.BlocklistFile + "'")
+	}
+	if cfg.AllowlistFile != "" {
+		b.WriteString(" allowlistFile='" + cfg.AllowlistFile + "'")
+	}
+	if cfg.BlocklistFile != "" || cfg.AllowlistFile != "" {
+		b.WriteString(" policyReloadInterval=" + cfg.PolicyReloadInterval.String())
+	}
+	b.WriteString(" shortenRateLimit=" + cfg.ShortenRateLimit.String())
+	b.WriteString(" batchRateLimit=" + cfg.BatchRateLimit.String())
+	b.WriteString(" redirectRateLimit=" + cfg.RedirectRateLimit.String())
+	b.WriteString(" logLevel=" + cfg.LogLevel + " logFormat=" + cfg.LogFormat)
 	if cfg.EnableHTTPS {
 		b.WriteString(" enableHTTPS: yes")
 	} else {
@@ -92,14 +213,55 @@ func (cfg Config) Validate() (retErr error) {
 	if cfg.SrvAddr == "" {
 		retErr = multierror.Append(retErr, errors.New("mising server address"))
 	}
-	if cfg.DBType != DBInmem && cfg.DBType != DBPostgres {
+	if cfg.DBType != DBInmem && cfg.DBType != DBPostgres && cfg.DBType != DBBolt {
 		retErr = multierror.Append(retErr, errors.New("invalid storage type"))
 	}
+	if cfg.ClickFlushInterval <= 0 {
+		retErr = multierror.Append(retErr, errors.New("invalid click flush interval"))
+	}
+	if len(cfg.AllowedSchemes) == 0 {
+		retErr = multierror.Append(retErr, errors.New("no allowed URL schemes"))
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		retErr = multierror.Append(retErr, errors.New("invalid shutdown timeout"))
+	}
+	if cfg.PolicyReloadInterval <= 0 {
+		retErr = multierror.Append(retErr, errors.New("invalid policy reload interval"))
+	}
+	for name, rl := range map[string]RateLimit{
+		"shorten":  cfg.ShortenRateLimit,
+		"batch":    cfg.BatchRateLimit,
+		"redirect": cfg.RedirectRateLimit,
+	} {
+		if rl.Rate < 0 || (rl.Rate > 0 && rl.Burst < 1) {
+			retErr = multierror.Append(retErr, fmt.Errorf("invalid %s rate limit: rate=%v burst=%d", name, rl.Rate, rl.Burst))
+		}
+	}
+	if _, err := logrus.ParseLevel(cfg.LogLevel); err != nil {
+		retErr = multierror.Append(retErr, fmt.Errorf("invalid log level: %q", cfg.LogLevel))
+	}
+	if cfg.LogFormat != logger.FormatJSON && cfg.LogFormat != logger.FormatLogfmt {
+		retErr = multierror.Append(retErr, fmt.Errorf("invalid log format: %q", cfg.LogFormat))
+	}
+	switch cfg.TraceExporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		if cfg.OTLPEndpoint == "" {
+			retErr = multierror.Append(retErr, errors.New("missing OTLP endpoint"))
+		}
+	default:
+		retErr = multierror.Append(retErr, fmt.Errorf("invalid trace exporter: %q", cfg.TraceExporter))
+	}
 	if cfg.TrustedSubnet != "" {
 		if _, _, err := net.ParseCIDR(cfg.TrustedSubnet); err != nil {
 			retErr = multierror.Append(retErr, fmt.Errorf("incorrect subnet: %s", err))
 		}
 	}
+	if cfg.TrustedProxy != "" {
+		if _, _, err := net.ParseCIDR(cfg.TrustedProxy); err != nil {
+			retErr = multierror.Append(retErr, fmt.Errorf("incorrect trusted proxy subnet: %s", err))
+		}
+	}
 
 	return
 }
@@ -110,13 +272,26 @@ type Option func(*Config)
 // поля при помощи функций configOption.
 func NewConfig(opts ...Option) Config {
 	cfg := Config{
-		BaseURL:             baseURLDefault,
-		SrvAddr:             srvAddrDefault,
-		StorageFileName:     fileStorageDefault,
-		InmemFlushInterval:  defaultInmemFlushInterval,
-		DeleteFlushInterval: defaultDeleteFlushInterval,
-		DSN:                 "", // значения по умолчанию будут внесены функцией newConfig.
-		EnableHTTPS:         false,
+		BaseURL:              baseURLDefault,
+		SrvAddr:              srvAddrDefault,
+		StorageFileName:      fileStorageDefault,
+		InmemFlushInterval:   defaultInmemFlushInterval,
+		DeleteFlushInterval:  defaultDeleteFlushInterval,
+		ClickFlushInterval:   defaultClickFlushInterval,
+		ShutdownTimeout:      defaultShutdownTimeout,
+		KeyGenerator:         keyGeneratorDefault,
+		KeyLength:            keyLengthDefault,
+		KeyAlphabet:          keyAlphabetDefault,
+		AllowedSchemes:       []string{"http", "https"},
+		PolicyReloadInterval: defaultPolicyReloadInterval,
+		ShortenRateLimit:     RateLimit{Rate: defaultShortenRate, Burst: defaultShortenBurst},
+		BatchRateLimit:       RateLimit{Rate: defaultBatchRate, Burst: defaultBatchBurst},
+		RedirectRateLimit:    RateLimit{Rate: defaultRedirectRate, Burst: defaultRedirectBurst},
+		LogLevel:             logLevelDefault,
+		LogFormat:            logFormatDefault,
+		OTLPEndpoint:         otlpEndpointDefault,
+		DSN:                  "", // значения по умолчанию будут внесены функцией newConfig.
+		EnableHTTPS:          false,
 	}
 
 	for _, fn := range opts {
@@ -140,6 +315,9 @@ func NewConfig(opts ...Option) Config {
 		cfg.DSN = ""
 	case DBInmem:
 		cfg.DSN = ""
+	case DBBolt:
+		cfg.DSN = ""
+		cfg.InmemFlushInterval = 0
 	}
 
 	return cfg
@@ -148,14 +326,14 @@ func NewConfig(opts ...Option) Config {
 // WithFile считывает конфигурацию из файла (по умолчанию - config.json).
 func WithFile(filename string) Option {
 	return func(cfg *Config) {
-		log.Printf("open file %s", filename)
+		logrus.Infof("open file %s", filename)
 		f, err := os.Open(filename)
 		if err != nil {
-			log.Fatalf("config: could not read file %s: %s", filename, err)
+			logrus.Fatalf("config: could not read file %s: %s", filename, err)
 		}
 		defer f.Close()
 		if err := json.NewDecoder(f).Decode(&cfg); err != nil {
-			log.Fatalf("config: could not decode file %s: %s", filename, err)
+			logrus.Fatalf("config: could not decode file %s: %s", filename, err)
 		}
 	}
 }
@@ -203,6 +381,17 @@ func WithEnv() Option {
 			"DATABASE_DSN":      &cfg.DSN,
 			"HASH_KEY":          &cfg.Secret,
 			"TRUSTED_SUBNET":    &cfg.TrustedSubnet,
+			"TRUSTED_PROXY":     &cfg.TrustedProxy,
+			"KEY_GENERATOR":     &cfg.KeyGenerator,
+			"KEY_ALPHABET":      &cfg.KeyAlphabet,
+			"BLOCKLIST_FILE":    &cfg.BlocklistFile,
+			"ALLOWLIST_FILE":    &cfg.AllowlistFile,
+			"LOG_LEVEL":         &cfg.LogLevel,
+			"LOG_FORMAT":        &cfg.LogFormat,
+			"METRICS_ADDRESS":   &cfg.MetricsAddress,
+			"TRACE_EXPORTER":    &cfg.TraceExporter,
+			// стандартная переменная окружения OpenTelemetry
+			"OTEL_EXPORTER_OTLP_ENDPOINT": &cfg.OTLPEndpoint,
 		}
 
 		for v := range env {
@@ -213,5 +402,8 @@ func WithEnv() Option {
 		if _, ok := os.LookupEnv("ENABLE_HTTPS"); ok {
 			cfg.EnableHTTPS = true
 		}
+		if schemes, ok := os.LookupEnv("ALLOWED_SCHEMES"); ok {
+			cfg.AllowedSchemes = strings.Split(schemes, ",")
+		}
 	}
 }
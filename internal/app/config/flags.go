@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"os"
 	"time"
 )
 
@@ -20,21 +21,28 @@ type AppFlags struct {
 	DeleteFlushInterval *time.Duration
 }
 
-// GetFlags считывает установленные пользователем флаги и возвращает структуру AppFlags.
+// GetFlags считывает установленные пользователем флаги командной строки и возвращает структуру AppFlags.
 func GetFlags() AppFlags {
+	return ParseFlags(os.Args[1:])
+}
+
+// ParseFlags разбирает флаги из переданного списка аргументов и возвращает структуру AppFlags.
+// Используется подкомандами, аргументы которых начинаются не с первого элемента os.Args.
+func ParseFlags(args []string) AppFlags {
 	var flushInterval int
 	configFilename := flag.String("c", DefaultCfgFileName, "configuration file")
 	srvAddr := flag.String("a", srvAddrDefault, "Server address")
 	baseURL := flag.String("b", baseURLDefault, "Base URL")
 	secret := flag.String("p", "*****", "Secret key for hashing cookies") // чтобы ключ по умолчанию не отображался в usage, придется действовать из-за угла))
 	dbType := flag.String("r", DBInmem, "Storage type (default inmem)\n- inmem\t\tin-memory storage periodically written to .gob file\n"+
-		"- postgres\tPostgreSQL database")
+		"- postgres\tPostgreSQL database\n"+
+		"- bolt\t\tembedded on-disk key-value storage (bbolt) in the file storage path")
 	storageFileName := flag.String("f", fileStorageDefault, "File storage path")
 	dsn := flag.String("d", "", "Database DSN")
 	enableHTTPS := flag.Bool("s", false, "enable HTTPS")
 	trustedSubnet := flag.String("t", "", "Trusted subnet for internal requests")
 	flag.IntVar(&flushInterval, "F", int(defaultDeleteFlushInterval/time.Millisecond), "Flush interval for accumulate data to delete in milliseconds")
-	flag.Parse()
+	flag.CommandLine.Parse(args) //nolint:errcheck // CommandLine завершает программу при ошибке разбора
 
 	delFlushInterval := time.Duration(flushInterval) * time.Millisecond
 
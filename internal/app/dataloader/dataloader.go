@@ -2,15 +2,26 @@ package dataloader
 
 import (
 	"context"
-	"log"
+	"errors"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/metrics"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // deletechanSize - размер канала с очередью на удаление.
 const deleteChanSize = 100
 
+// ErrClosed возвращается BatchDelete после закрытия сервиса.
+var ErrClosed = errors.New("dataloader: closed")
+
 type (
 	// DataLoader накапливает данные для пакетного удаления. Для отправки данных в очередь вызывается функция
 	// BatchDelete, которая по каналу отправляет данные агрегатору, накапливающему данные по разным пользователям.
@@ -28,15 +39,30 @@ type (
 		deleteCh chan taskDel
 		// stopCh - канал для закрытия сервиса.
 		stopCh chan struct{}
+		// doneCh закрывается агрегатором после слива оставшихся данных.
+		doneCh chan struct{}
 
 		// tasks - хранилище заданий на удаление по каждому пользователю.
 		tasks map[uuid.UUID][]string
+		// backlog - количество ключей, принятых BatchDelete и ещё не отправленных в хранилище.
+		// Счётчик общий для всех копий DataLoader.
+		backlog *int64
+		// links - ссылки на спаны запросов, создавших задания на удаление, по каждому пользователю.
+		// Спан удаления записей пользователя связывается с ними.
+		links map[uuid.UUID][]trace.Link
+
+		log logrus.FieldLogger
 	}
 
+	// Option - параметр DataLoader.
+	Option func(*DataLoader)
+
 	// taskDel - задание на удаление записей с ключами из массива keys, вызванное пользователем id.
 	taskDel struct {
 		id   uuid.UUID
 		keys []string
+		// span - контекст спана запроса, вызвавшего удаление.
+		span trace.SpanContext
 	}
 
 	// BatchDeleteFunc - функция интерфейса storage, вызываемая агрегатором для удаления
@@ -44,69 +70,138 @@ type (
 	BatchDeleteFunc func(ctx context.Context, id uuid.UUID, keys []string) error
 )
 
-func NewDataLoader(ctx context.Context, deleteFunc BatchDeleteFunc, interval time.Duration) DataLoader {
+// NewDataLoader создаёт и запускает сервис DataLoader.
+func NewDataLoader(ctx context.Context, deleteFunc BatchDeleteFunc, interval time.Duration, opts ...Option) DataLoader {
 	dl := DataLoader{
 		ctx:        ctx,
 		ticker:     time.NewTicker(interval),
 		deleteFunc: deleteFunc,
 		deleteCh:   make(chan taskDel, deleteChanSize),
 		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
 		tasks:      make(map[uuid.UUID][]string),
+		links:      make(map[uuid.UUID][]trace.Link),
+		backlog:    new(int64),
+		log:        logger.Default(),
 	}
+	for _, opt := range opts {
+		opt(&dl)
+	}
+	dl.log = dl.log.WithField(logger.FieldComponent, "dataloader")
 	go dl.aggregator()
-	log.Println("DataLoader started")
+	dl.log.Info("DataLoader started")
 
 	return dl
 }
 
+// WithLogger задаёт логгер сервиса.
+func WithLogger(l logrus.FieldLogger) Option {
+	return func(dl *DataLoader) {
+		dl.log = l
+	}
+}
+
 // BatchDelete отправляет по каналу данные агрегатору, накапливающему записи на удаление и сливающему их в базу по истечении заданного интервала.
+// После закрытия сервиса возвращается ErrClosed.
 func (dl DataLoader) BatchDelete(ctx context.Context, id uuid.UUID, keys []string) error {
-	dl.deleteCh <- taskDel{
+	task := taskDel{
 		id:   id,
 		keys: keys,
+		span: trace.SpanContextFromContext(ctx),
 	}
-	return nil // error добавлен для совместимости со storage.BatchDelete
+	select {
+	case <-dl.stopCh:
+		return ErrClosed
+	default:
+	}
+	// счётчик увеличивается до отправки: иначе агрегатор может слить задание и уменьшить его раньше.
+	atomic.AddInt64(dl.backlog, int64(len(keys)))
+	select {
+	case dl.deleteCh <- task:
+		return nil
+	case <-dl.stopCh:
+		atomic.AddInt64(dl.backlog, -int64(len(keys)))
+
+		return ErrClosed
+	}
+}
+
+// Backlog возвращает количество ключей, ожидающих удаления.
+func (dl DataLoader) Backlog() int {
+	return int(atomic.LoadInt64(dl.backlog))
 }
 
-// Close закрывает сервис DataLoader.
+// Close закрывает сервис DataLoader, предварительно слив все накопленные данные на удаление.
 func (dl DataLoader) Close() {
-	dl.ticker.Stop()
-	if dl.stopCh != nil {
-		close(dl.stopCh)
-	}
-	dl.stopCh = nil
-	dl.flush()
-	log.Println("DataLoader closed")
+	close(dl.stopCh)
+	<-dl.doneCh
+	dl.log.Info("DataLoader closed")
 }
 
 // aggregator накапливает данные на удаление по каждому пользователю и сливает их функции deleteFunc по истечении заданного интервала.
+// Все поля с накопленными данными используются только в горутине агрегатора.
 func (dl DataLoader) aggregator() {
+	defer close(dl.doneCh)
+	defer dl.ticker.Stop()
 	for {
 		select {
 		case task := <-dl.deleteCh: // пришли данные, надо их засунуть в накопитель
-			dl.tasks[task.id] = append(dl.tasks[task.id], task.keys...)
-			log.Printf("dataloader: got %d keys to delete from id %s", len(task.keys), task.id)
+			dl.add(task)
 		case <-dl.ticker.C: // время удалять записи!
 			dl.flush()
-		case <-dl.stopCh: // пора и честь знать...
-			log.Println("dataloader: aggregator stopped")
-			return
+		case <-dl.stopCh: // пора и честь знать... но сначала сливаем всё, что осталось в очереди
+			for {
+				select {
+				case task := <-dl.deleteCh:
+					dl.add(task)
+				default:
+					dl.flush()
+					dl.log.Debug("aggregator stopped")
+
+					return
+				}
+			}
 		}
 	}
 }
 
+// add добавляет задание на удаление в накопитель.
+func (dl DataLoader) add(task taskDel) {
+	dl.tasks[task.id] = append(dl.tasks[task.id], task.keys...)
+	metrics.DataLoaderQueueDepth.Add(float64(len(task.keys)))
+	if task.span.IsValid() {
+		dl.links[task.id] = append(dl.links[task.id], trace.Link{SpanContext: task.span})
+	}
+	dl.log.WithField(logger.FieldUserID, task.id).Debugf("got %d keys to delete", len(task.keys))
+}
+
 // flush отправляет накопленные данные по всем пользователям на удаление.
 func (dl DataLoader) flush() {
 	if len(dl.tasks) == 0 {
 		return
 	}
 
-	log.Printf("dataloader: flush: we have %d tasks to delete", len(dl.tasks))
+	dl.log.Debugf("flush: %d users have keys to delete", len(dl.tasks))
+	total := 0
 	for id, keys := range dl.tasks {
-		log.Printf("dataloader: flush: deleting %d keys for id=%s", len(keys), id)
-		if err := dl.deleteFunc(dl.ctx, id, keys); err != nil {
-			log.Printf("dataloader: %v", err)
+		log := dl.log.WithField(logger.FieldUserID, id)
+		log.Debugf("flush: deleting %d keys", len(keys))
+		// удаление выполняется в отдельной трассе, связанной со спанами запросов, его вызвавших.
+		ctx, span := tracing.Tracer().Start(dl.ctx, "dataloader.flush",
+			trace.WithNewRoot(),
+			trace.WithLinks(dl.links[id]...),
+			trace.WithAttributes(attribute.String("user_id", id.String()), attribute.Int("keys", len(keys))))
+		if err := dl.deleteFunc(ctx, id, keys); err != nil {
+			log.WithError(err).Error("flush: could not delete keys")
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 		}
+		span.End()
 		delete(dl.tasks, id)
+		delete(dl.links, id)
+		metrics.DataLoaderQueueDepth.Sub(float64(len(keys)))
+		atomic.AddInt64(dl.backlog, -int64(len(keys)))
+		total += len(keys)
 	}
+	metrics.DataLoaderFlushSize.Observe(float64(total))
 }
@@ -3,6 +3,7 @@ package dataloader_test
 import (
 	"context"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -10,7 +11,12 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/dataloader"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/inmem"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestDataLoader(t *testing.T) {
@@ -151,12 +157,14 @@ func TestDataLoader(t *testing.T) {
 	defer func() {
 		db.Close()
 		require.NoError(t, os.Remove(filename))
+		require.NoError(t, os.Remove(filename+".prev"))
+		require.NoError(t, os.Remove(filename+".wal.prev"))
 	}()
 
 	t.Log("Storing data...")
 	for _, taskStore := range toStore {
 		for key, url := range taskStore.records {
-			assert.NoError(t, db.Store(ctx, taskStore.id, key, url))
+			assert.NoError(t, db.Store(ctx, taskStore.id, storage.Record{Key: key, OriginalURL: url}))
 		}
 	}
 
@@ -183,3 +191,102 @@ func TestDataLoader(t *testing.T) {
 		})
 	}
 }
+
+func TestDataLoaderTracing(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	deleted := make(chan trace.SpanContext, 1)
+	deleteFunc := func(ctx context.Context, id uuid.UUID, keys []string) error {
+		deleted <- trace.SpanContextFromContext(ctx)
+
+		return nil
+	}
+	dl := dataloader.NewDataLoader(context.Background(), deleteFunc, 10*time.Millisecond)
+	defer dl.Close()
+
+	ctx, reqSpan := otel.Tracer("test").Start(context.Background(), "request")
+	require.NoError(t, dl.BatchDelete(ctx, uuid.New(), []string{"key1", "key2"}))
+	reqSpan.End()
+
+	var flushCtx trace.SpanContext
+	select {
+	case flushCtx = <-deleted:
+	case <-time.After(time.Second):
+		t.Fatal("keys were not flushed")
+	}
+	require.True(t, flushCtx.IsValid(), "deleteFunc must be called with the flush span")
+	assert.NotEqual(t, reqSpan.SpanContext().TraceID(), flushCtx.TraceID(), "flush must start a new trace")
+
+	var flush sdktrace.ReadOnlySpan
+	require.Eventually(t, func() bool {
+		for _, s := range rec.Ended() {
+			if s.Name() == "dataloader.flush" {
+				flush = s
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+	require.Len(t, flush.Links(), 1)
+	assert.Equal(t, reqSpan.SpanContext().SpanID(), flush.Links()[0].SpanContext.SpanID(),
+		"flush span must be linked to the request span")
+}
+
+func TestDataLoaderBacklog(t *testing.T) {
+	release := make(chan struct{})
+	deleteFunc := func(ctx context.Context, id uuid.UUID, keys []string) error {
+		<-release
+
+		return nil
+	}
+	dl := dataloader.NewDataLoader(context.Background(), deleteFunc, 10*time.Millisecond)
+	defer dl.Close()
+
+	require.NoError(t, dl.BatchDelete(context.Background(), uuid.New(), []string{"key1", "key2"}))
+	require.NoError(t, dl.BatchDelete(context.Background(), uuid.New(), []string{"key3"}))
+	assert.Equal(t, 3, dl.Backlog(), "keys must be counted until they are deleted")
+
+	close(release)
+	assert.Eventually(t, func() bool { return dl.Backlog() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestDataLoaderBacklogNeverNegative(t *testing.T) {
+	var dl dataloader.DataLoader
+	negative := make(chan int, 1)
+	deleteFunc := func(ctx context.Context, id uuid.UUID, keys []string) error {
+		// ключи, переданные на удаление, ещё учитываются в счётчике
+		if backlog := dl.Backlog(); backlog < len(keys) {
+			select {
+			case negative <- backlog - len(keys):
+			default:
+			}
+		}
+
+		return nil
+	}
+	dl = dataloader.NewDataLoader(context.Background(), deleteFunc, time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				assert.NoError(t, dl.BatchDelete(context.Background(), uuid.New(), []string{"key"}))
+			}
+		}()
+	}
+	wg.Wait()
+	dl.Close()
+
+	select {
+	case backlog := <-negative:
+		t.Fatalf("backlog went negative: %d", backlog)
+	default:
+	}
+	assert.Equal(t, 0, dl.Backlog())
+	assert.ErrorIs(t, dl.BatchDelete(context.Background(), uuid.New(), []string{"key"}), dataloader.ErrClosed)
+	assert.Equal(t, 0, dl.Backlog(), "rejected keys must not be counted")
+}
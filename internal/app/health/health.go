@@ -0,0 +1,165 @@
+// Пакет health проверяет готовность компонентов сервиса к обработке запросов.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// checkTimeout - время, отведённое на проверку одного компонента.
+const checkTimeout = 2 * time.Second
+
+// Состояния компонента и сервиса в целом.
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+type (
+	// Status - состояние компонента.
+	Status string
+
+	// Check - результат проверки компонента.
+	Check struct {
+		Status Status `json:"status"`
+		Error  string `json:"error,omitempty"`
+		// Details - дополнительные сведения о состоянии компонента.
+		Details map[string]interface{} `json:"details,omitempty"`
+	}
+
+	// CheckFunc проверяет состояние компонента.
+	CheckFunc func(ctx context.Context) Check
+
+	// Report - результат проверки всех компонентов. Сервис готов (StatusUp), если готовы все компоненты.
+	Report struct {
+		Status Status           `json:"status"`
+		Checks map[string]Check `json:"checks,omitempty"`
+	}
+
+	// Checker выполняет зарегистрированные проверки компонентов. Проверки регистрируются до начала
+	// обработки запросов, после чего Checker можно использовать из нескольких горутин.
+	Checker struct {
+		names  []string
+		checks []CheckFunc
+	}
+)
+
+// NewChecker создаёт Checker без проверок.
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add регистрирует проверку компонента name.
+func (c *Checker) Add(name string, check CheckFunc) {
+	c.names = append(c.names, name)
+	c.checks = append(c.checks, check)
+}
+
+// Run параллельно выполняет все проверки. Проверка, не завершившаяся за checkTimeout, считается неудачной.
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	results := make([]Check, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check CheckFunc) {
+			defer wg.Done()
+			results[i] = run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusUp, Checks: make(map[string]Check, len(results))}
+	for i, res := range results {
+		report.Checks[c.names[i]] = res
+		if res.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+
+	return report
+}
+
+// run выполняет проверку check, ожидая её не дольше, чем до отмены ctx.
+func run(ctx context.Context, check CheckFunc) Check {
+	done := make(chan Check, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	select {
+	case res := <-done:
+		return res
+	case <-ctx.Done():
+		return down(errors.New("check timed out"))
+	}
+}
+
+// down возвращает результат неудачной проверки с ошибкой err.
+func down(err error) Check {
+	return Check{Status: StatusDown, Error: err.Error()}
+}
+
+// Ping проверяет соединение с хранилищем функцией ping (метод Ping интерфейса storage.Storage).
+func Ping(ping func() error) CheckFunc {
+	return func(context.Context) Check {
+		if err := ping(); err != nil {
+			return down(err)
+		}
+
+		return Check{Status: StatusUp}
+	}
+}
+
+// Snapshot сообщает время последнего успешного сохранения снимка in-memory хранилища.
+// Проверка неудачна, если последняя попытка сохранения завершилась ошибкой.
+func Snapshot(lastFlush func() (time.Time, error)) CheckFunc {
+	return func(context.Context) Check {
+		last, err := lastFlush()
+		res := Check{Status: StatusUp}
+		if err != nil {
+			res = down(err)
+		}
+		if !last.IsZero() {
+			res.Details = map[string]interface{}{"last_flush": last.UTC().Format(time.RFC3339)}
+		}
+
+		return res
+	}
+}
+
+// Backlog сообщает количество ключей, ожидающих удаления в DataLoader. Очередь на удаление
+// не влияет на готовность сервиса.
+func Backlog(backlog func() int) CheckFunc {
+	return func(context.Context) Check {
+		return Check{
+			Status:  StatusUp,
+			Details: map[string]interface{}{"backlog": backlog()},
+		}
+	}
+}
+
+// GRPC проверяет состояние gRPC-сервера по его сервису grpc.health.v1.
+func GRPC(hs healthpb.HealthServer) CheckFunc {
+	return func(ctx context.Context) Check {
+		resp, err := hs.Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			return down(err)
+		}
+		res := Check{
+			Status:  StatusUp,
+			Details: map[string]interface{}{"status": resp.GetStatus().String()},
+		}
+		if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			res.Status = StatusDown
+			res.Error = "server is not serving"
+		}
+
+		return res
+	}
+}
@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker(t *testing.T) {
+	flushed := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		check      CheckFunc
+		wantStatus Status
+		want       Check
+	}{
+		{
+			name:       "storage is available",
+			check:      Ping(func() error { return nil }),
+			wantStatus: StatusUp,
+			want:       Check{Status: StatusUp},
+		},
+		{
+			name:       "storage is unavailable",
+			check:      Ping(func() error { return errors.New("connection refused") }),
+			wantStatus: StatusDown,
+			want:       Check{Status: StatusDown, Error: "connection refused"},
+		},
+		{
+			name:       "snapshot saving failed",
+			check:      Snapshot(func() (time.Time, error) { return flushed, errors.New("disk is full") }),
+			wantStatus: StatusDown,
+			want: Check{
+				Status:  StatusDown,
+				Error:   "disk is full",
+				Details: map[string]interface{}{"last_flush": "2022-05-01T12:00:00Z"},
+			},
+		},
+		{
+			name: "check timed out",
+			check: func(ctx context.Context) Check {
+				<-ctx.Done()
+				time.Sleep(10 * time.Millisecond)
+
+				return Check{Status: StatusUp}
+			},
+			wantStatus: StatusDown,
+			want:       Check{Status: StatusDown, Error: "check timed out"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewChecker()
+			c.Add("backlog", Backlog(func() int { return 0 }))
+			c.Add("component", tc.check)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			report := c.Run(ctx)
+			assert.Equal(t, tc.wantStatus, report.Status)
+			assert.Equal(t, tc.want, report.Checks["component"])
+			assert.Equal(t, StatusUp, report.Checks["backlog"].Status)
+		})
+	}
+}
@@ -0,0 +1,222 @@
+// Пакет lifecycle управляет запуском и корректной остановкой серверов и фоновых сервисов.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+type (
+	// Manager запускает зарегистрированные серверы и по отмене контекста (или при аварийной остановке
+	// любого из серверов) останавливает их, дожидаясь завершения обрабатываемых запросов, после чего
+	// в порядке, обратном порядку регистрации, закрывает фоновые сервисы.
+	// Каждый этап ограничен таймаутом timeout.
+	Manager struct {
+		timeout time.Duration
+		log     logrus.FieldLogger
+		servers []server
+		closers []closer
+	}
+
+	// server - сервер, работающий до вызова shutdown.
+	server struct {
+		name string
+		// serve блокируется до остановки сервера.
+		serve func() error
+		// shutdown останавливает сервер, дожидаясь завершения обрабатываемых запросов,
+		// но не дольше, чем до отмены контекста.
+		shutdown func(ctx context.Context) error
+	}
+
+	// closer - фоновый сервис, освобождающий ресурсы при остановке.
+	closer struct {
+		name  string
+		close func(ctx context.Context) error
+	}
+)
+
+// New создаёт менеджер, ограничивающий остановку серверов и закрытие каждого сервиса таймаутом timeout.
+func New(timeout time.Duration, log logrus.FieldLogger) *Manager {
+	return &Manager{
+		timeout: timeout,
+		log:     log,
+	}
+}
+
+// AddServer регистрирует сервер. Функция serve блокируется до остановки сервера; ошибки http.ErrServerClosed
+// и grpc.ErrServerStopped, которые возвращаются после вызова shutdown, ошибками не считаются.
+func (m *Manager) AddServer(name string, serve func() error, shutdown func(ctx context.Context) error) {
+	m.servers = append(m.servers, server{name: name, serve: serve, shutdown: shutdown})
+}
+
+// AddCloser регистрирует фоновый сервис, закрываемый после остановки всех серверов. Как и отложенные вызовы,
+// сервисы закрываются в порядке, обратном порядку регистрации: сервисы, сливающие данные в хранилище
+// и зарегистрированные после него, закрываются раньше хранилища.
+func (m *Manager) AddCloser(name string, close func(ctx context.Context) error) {
+	m.closers = append(m.closers, closer{name: name, close: close})
+}
+
+// Func приводит функцию закрытия без контекста и ошибки к виду, принимаемому AddCloser.
+func Func(close func()) func(ctx context.Context) error {
+	return func(context.Context) error {
+		close()
+
+		return nil
+	}
+}
+
+// GRPCShutdown возвращает функцию остановки gRPC-сервера: GracefulStop дожидается завершения обрабатываемых
+// вызовов, а по отмене контекста оставшиеся соединения закрываются принудительно.
+func GRPCShutdown(s *grpc.Server) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		stopped := make(chan struct{})
+		go func() {
+			s.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			s.Stop()
+
+			return ctx.Err()
+		}
+	}
+}
+
+// Run запускает серверы и блокируется до отмены ctx или остановки одного из серверов, затем останавливает
+// все серверы и закрывает фоновые сервисы. Возвращается ошибка, если какой-либо сервер завершился с ошибкой
+// или не успел остановиться, либо если не удалось закрыть какой-либо сервис.
+func (m *Manager) Run(ctx context.Context) error {
+	var result *multierror.Error
+	serveErrs := make(chan error, len(m.servers))
+	for _, s := range m.servers {
+		go func(s server) {
+			m.log.Infof("%s server started", s.name)
+			serveErrs <- m.serve(s)
+		}(s)
+	}
+
+	running := len(m.servers)
+	select {
+	case <-ctx.Done():
+		m.log.Info("Shutting down...")
+	case err := <-serveErrs:
+		running--
+		if err == nil {
+			err = errors.New("server stopped unexpectedly")
+		}
+		result = multierror.Append(result, err)
+		m.log.WithError(err).Error("Shutting down...")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	shutdownErrs := make(chan error, len(m.servers))
+	for _, s := range m.servers {
+		go func(s server) {
+			if err := s.shutdown(shutdownCtx); err != nil {
+				shutdownErrs <- fmt.Errorf("%s server: shutdown: %w", s.name, err)
+				return
+			}
+			shutdownErrs <- nil
+		}(s)
+	}
+	for range m.servers {
+		if err := <-shutdownErrs; err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	// дожидаемся, пока все серверы вернут управление
+	for ; running > 0; running-- {
+		select {
+		case err := <-serveErrs:
+			if err != nil {
+				result = multierror.Append(result, err)
+			}
+		case <-shutdownCtx.Done():
+			result = multierror.Append(result, fmt.Errorf("%d servers did not stop in %s", running, m.timeout))
+			running = 0
+		}
+	}
+	m.log.Info("All servers stopped")
+
+	if err := m.Close(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	return result.ErrorOrNil()
+}
+
+// Close закрывает зарегистрированные фоновые сервисы в обратном порядке. Ошибка закрытия сервиса
+// не мешает закрыть остальные. Если же сервис не закрылся за время timeout, он может продолжать
+// работать с сервисами, зарегистрированными раньше него (например, сливать данные в хранилище),
+// поэтому они остаются открытыми, а Close возвращает ошибку. Используется Run, а также
+// для освобождения ресурсов, если запуск сервиса прерван до вызова Run. Повторный вызов ничего не делает.
+func (m *Manager) Close() error {
+	var result *multierror.Error
+	for i := len(m.closers) - 1; i >= 0; i-- {
+		c := m.closers[i]
+		if err := m.close(c); err != nil {
+			m.log.WithError(err).Errorf("could not close %s", c.name)
+			result = multierror.Append(result, fmt.Errorf("%s: %w", c.name, err))
+			if errors.Is(err, errCloseTimeout) && i > 0 {
+				skipped := make([]string, 0, i)
+				for j := i - 1; j >= 0; j-- {
+					skipped = append(skipped, m.closers[j].name)
+				}
+				err = fmt.Errorf("%s left open: %s is still closing", strings.Join(skipped, ", "), c.name)
+				m.log.Error(err)
+				result = multierror.Append(result, err)
+
+				break
+			}
+
+			continue
+		}
+		m.log.Infof("%s closed", c.name)
+	}
+	m.closers = nil
+
+	return result.ErrorOrNil()
+}
+
+// serve запускает сервер s и возвращает ошибку его аварийной остановки.
+func (m *Manager) serve(s server) error {
+	err := s.serve()
+	if err == nil || errors.Is(err, http.ErrServerClosed) || errors.Is(err, grpc.ErrServerStopped) {
+		m.log.Infof("%s server stopped", s.name)
+
+		return nil
+	}
+
+	return fmt.Errorf("%s server: %w", s.name, err)
+}
+
+// errCloseTimeout возвращается close, если сервис не закрылся за время timeout.
+var errCloseTimeout = errors.New("close timed out")
+
+// close закрывает сервис c, ожидая не дольше timeout.
+func (m *Manager) close(c closer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.close(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: not closed in %s", errCloseTimeout, m.timeout)
+	}
+}
@@ -0,0 +1,186 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLogger возвращает логгер, не пишущий в вывод тестов.
+func testLogger() logrus.FieldLogger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	return log
+}
+
+// journal потокобезопасно записывает последовательность событий.
+type journal struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (j *journal) add(event string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.events = append(j.events, event)
+}
+
+func (j *journal) get() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return append([]string(nil), j.events...)
+}
+
+// addBlockingServer регистрирует в m сервер, работающий до вызова shutdown.
+func addBlockingServer(m *Manager, j *journal, name string) {
+	stop := make(chan struct{})
+	m.AddServer(name, func() error {
+		<-stop
+		j.add(name + " stopped")
+
+		return http.ErrServerClosed
+	}, func(context.Context) error {
+		close(stop)
+
+		return nil
+	})
+}
+
+func TestRunOrder(t *testing.T) {
+	j := &journal{}
+	m := New(time.Second, testLogger())
+	addBlockingServer(m, j, "first")
+	addBlockingServer(m, j, "second")
+	for _, name := range []string{"storage", "DataLoader", "Recorder"} {
+		name := name
+		m.AddCloser(name, Func(func() { j.add(name + " closed") }))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, m.Run(ctx))
+
+	events := j.get()
+	require.Len(t, events, 5)
+	assert.ElementsMatch(t, []string{"first stopped", "second stopped"}, events[:2], "servers must stop first")
+	assert.Equal(t, []string{"Recorder closed", "DataLoader closed", "storage closed"}, events[2:],
+		"closers must run in reverse order")
+	assert.NoError(t, m.Close(), "repeated Close must do nothing")
+	assert.Len(t, j.get(), 5)
+}
+
+func TestRunDrainsHTTP(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done")) //nolint:errcheck
+	})}
+	m := New(time.Second, testLogger())
+	m.AddServer("HTTP", func() error { return server.Serve(listen) }, server.Shutdown)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- m.Run(ctx) }()
+
+	respCh := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listen.Addr().String())
+		if !assert.NoError(t, err) {
+			respCh <- ""
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		respCh <- string(body)
+	}()
+	<-started
+	cancel()
+
+	assert.Equal(t, "done", <-respCh, "in-flight request must be completed")
+	assert.NoError(t, <-runErr)
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		// stop - остановить сервис сигналом, а не ошибкой сервера
+		stop bool
+		// stuck - сервис не закрылся вовремя, и хранилище должно остаться открытым
+		stuck bool
+		setup func(m *Manager, j *journal)
+	}{
+		{
+			name: "server failed",
+			setup: func(m *Manager, j *journal) {
+				m.AddServer("failing", func() error { return errors.New("address already in use") },
+					func(context.Context) error { return nil })
+				addBlockingServer(m, j, "healthy")
+			},
+		},
+		{
+			name: "server stopped unexpectedly",
+			setup: func(m *Manager, j *journal) {
+				m.AddServer("quitter", func() error { return nil }, func(context.Context) error { return nil })
+				addBlockingServer(m, j, "healthy")
+			},
+		},
+		{
+			name: "closer failed",
+			stop: true,
+			setup: func(m *Manager, j *journal) {
+				addBlockingServer(m, j, "healthy")
+				m.AddCloser("failing", func(context.Context) error { return errors.New("disk is full") })
+			},
+		},
+		{
+			name:  "closer timed out",
+			stop:  true,
+			stuck: true,
+			setup: func(m *Manager, j *journal) {
+				addBlockingServer(m, j, "healthy")
+				m.AddCloser("stuck", func(context.Context) error {
+					time.Sleep(time.Second)
+
+					return nil
+				})
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			j := &journal{}
+			m := New(50*time.Millisecond, testLogger())
+			m.AddCloser("storage", Func(func() { j.add("storage closed") }))
+			tc.setup(m, j)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.stop {
+				cancel()
+			}
+			assert.Error(t, m.Run(ctx))
+			if tc.stuck {
+				assert.Equal(t, []string{"healthy stopped"}, j.get(),
+					"storage must stay open while a dependent service is still closing")
+
+				return
+			}
+			assert.Equal(t, []string{"healthy stopped", "storage closed"}, j.get(),
+				"remaining servers and services must be stopped anyway")
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Пакет logger создаёт структурированный логгер сервиса и передаёт логгер запроса через контекст.
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Форматы вывода.
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Имена полей записей лога.
+const (
+	FieldComponent = "component"
+	FieldRequestID = "request_id"
+	FieldUserID    = "user_id"
+	FieldTraceID   = "trace_id"
+)
+
+type privateKey string
+
+const loggerKey privateKey = "logger"
+
+// New создаёт логгер, пишущий в stderr записи уровня level (debug, info, warn, error) и выше
+// в формате format (json или logfmt).
+func New(level, format string) (*logrus.Logger, error) {
+	return NewWithOutput(os.Stderr, level, format)
+}
+
+// NewWithOutput создаёт логгер, пишущий в out.
+func NewWithOutput(out io.Writer, level, format string) (*logrus.Logger, error) {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("logger: %w", err)
+	}
+	l := logrus.New()
+	l.SetOutput(out)
+	l.SetLevel(lvl)
+	switch format {
+	case FormatJSON:
+		l.SetFormatter(&logrus.JSONFormatter{})
+	case FormatLogfmt:
+		l.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	default:
+		return nil, fmt.Errorf("logger: unknown format %q", format)
+	}
+
+	return l, nil
+}
+
+// Default возвращает логгер, используемый компонентами, которым логгер не был передан явно.
+func Default() logrus.FieldLogger {
+	return logrus.StandardLogger()
+}
+
+// WithContext добавляет в контекст логгер запроса.
+func WithContext(ctx context.Context, l logrus.FieldLogger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext возвращает логгер запроса из контекста, а если его там нет - логгер по умолчанию.
+func FromContext(ctx context.Context) logrus.FieldLogger {
+	return FromContextOr(ctx, Default())
+}
+
+// FromContextOr возвращает логгер запроса из контекста, а если его там нет - логгер fallback.
+func FromContextOr(ctx context.Context, fallback logrus.FieldLogger) logrus.FieldLogger {
+	if l, ok := ctx.Value(loggerKey).(logrus.FieldLogger); ok {
+		return l
+	}
+
+	return fallback
+}
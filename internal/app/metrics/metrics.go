@@ -0,0 +1,107 @@
+// Пакет metrics содержит метрики сервиса в формате Prometheus и обработчик, отдающий их.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shortener"
+
+// Registry - реестр метрик сервиса. Кроме метрик сервиса содержит стандартные метрики процесса и Go runtime.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests - количество обработанных HTTP-запросов по шаблону пути, методу и коду ответа.
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of handled HTTP requests.",
+	}, []string{"route", "method", "code"})
+
+	// HTTPRequestDuration - время обработки HTTP-запросов по шаблону пути и методу.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// GRPCRequests - количество обработанных вызовов gRPC по методу и коду ответа. Метка error равна "true",
+	// если вызов завершился ошибкой или ошибка передана в поле error ответа.
+	GRPCRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "Number of handled gRPC calls.",
+	}, []string{"method", "code", "error"})
+
+	// GRPCRequestDuration - время обработки вызовов gRPC по методу.
+	GRPCRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "gRPC call latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	// StorageOperationDuration - время выполнения операций хранилища по имени операции и результату
+	// (ok, not_found, gone, conflict, unavailable или error).
+	StorageOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "operation_duration_seconds",
+		Help:      "Storage operation latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+
+	// DataLoaderQueueDepth - количество ключей, ожидающих пакетного удаления.
+	DataLoaderQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "dataloader",
+		Name:      "queue_depth",
+		Help:      "Number of keys waiting for batch deletion.",
+	})
+
+	// DataLoaderFlushSize - количество ключей, удаляемых за один слив DataLoader.
+	DataLoaderFlushSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "dataloader",
+		Name:      "flush_size",
+		Help:      "Number of keys deleted per flush.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	})
+
+	// SnapshotDuration - время сохранения снимка in-memory хранилища в файл.
+	SnapshotDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "inmem",
+		Name:      "snapshot_duration_seconds",
+		Help:      "Time spent saving the in-memory storage snapshot.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		GRPCRequests,
+		GRPCRequestDuration,
+		StorageOperationDuration,
+		DataLoaderQueueDepth,
+		DataLoaderFlushSize,
+		SnapshotDuration,
+	)
+}
+
+// Handler возвращает обработчик, отдающий метрики из Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
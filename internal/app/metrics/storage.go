@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+var _ storage.Storage = (*instrumentedStorage)(nil)
+
+// instrumentedStorage оборачивает хранилище и записывает время выполнения его операций
+// в StorageOperationDuration.
+type instrumentedStorage struct {
+	db storage.Storage
+}
+
+// InstrumentStorage возвращает хранилище, делегирующее все вызовы db и измеряющее время их выполнения.
+func InstrumentStorage(db storage.Storage) storage.Storage {
+	return instrumentedStorage{db: db}
+}
+
+// observe записывает время выполнения операции op, начатой в момент start и завершившейся ошибкой err.
+// Метка result - исход операции (см. storage.Outcome).
+func observe(op string, start time.Time, err error) {
+	StorageOperationDuration.WithLabelValues(op, storage.Outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+func (s instrumentedStorage) Store(ctx context.Context, id uuid.UUID, rec storage.Record) (err error) {
+	defer func(start time.Time) { observe("store", start, err) }(time.Now())
+
+	return s.db.Store(ctx, id, rec)
+}
+
+func (s instrumentedStorage) Get(ctx context.Context, key string) (url string, err error) {
+	defer func(start time.Time) { observe("get", start, err) }(time.Now())
+
+	return s.db.Get(ctx, key)
+}
+
+func (s instrumentedStorage) Lookup(ctx context.Context, key string) (rec storage.Record, err error) {
+	defer func(start time.Time) { observe("lookup", start, err) }(time.Now())
+
+	return s.db.Lookup(ctx, key)
+}
+
+func (s instrumentedStorage) GetAll(ctx context.Context, id uuid.UUID) map[string]string {
+	defer func(start time.Time) { observe("get_all", start, nil) }(time.Now())
+
+	return s.db.GetAll(ctx, id)
+}
+
+func (s instrumentedStorage) BatchStore(ctx context.Context, id uuid.UUID, records []storage.Record) (err error) {
+	defer func(start time.Time) { observe("batch_store", start, err) }(time.Now())
+
+	return s.db.BatchStore(ctx, id, records)
+}
+
+func (s instrumentedStorage) BatchDelete(ctx context.Context, id uuid.UUID, keys []string) (err error) {
+	defer func(start time.Time) { observe("batch_delete", start, err) }(time.Now())
+
+	return s.db.BatchDelete(ctx, id, keys)
+}
+
+func (s instrumentedStorage) Stats(ctx context.Context) (urls int, users int, err error) {
+	defer func(start time.Time) { observe("stats", start, err) }(time.Now())
+
+	return s.db.Stats(ctx)
+}
+
+func (s instrumentedStorage) StoreClicks(ctx context.Context, clicks []storage.Click) (err error) {
+	defer func(start time.Time) { observe("store_clicks", start, err) }(time.Now())
+
+	return s.db.StoreClicks(ctx, clicks)
+}
+
+func (s instrumentedStorage) ClickStats(ctx context.Context, id uuid.UUID, key string) (st storage.ClickStats, err error) {
+	defer func(start time.Time) { observe("click_stats", start, err) }(time.Now())
+
+	return s.db.ClickStats(ctx, id, key)
+}
+
+func (s instrumentedStorage) Ping() (err error) {
+	defer func(start time.Time) { observe("ping", start, err) }(time.Now())
+
+	return s.db.Ping()
+}
+
+func (s instrumentedStorage) Close() {
+	s.db.Close()
+}
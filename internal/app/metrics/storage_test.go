@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+// getStorage - хранилище, метод Get которого возвращает заданную ошибку.
+type getStorage struct {
+	storage.Storage
+	err error
+}
+
+func (s getStorage) Get(ctx context.Context, key string) (string, error) {
+	return "http://example.com", s.err
+}
+
+// sampleCount возвращает количество наблюдений гистограммы StorageOperationDuration с метками op и result.
+func sampleCount(t *testing.T, op, result string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, StorageOperationDuration.WithLabelValues(op, result).(prometheus.Histogram).Write(&m))
+
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestInstrumentStorage(t *testing.T) {
+	tt := []struct {
+		name   string
+		err    error
+		result string
+	}{
+		{name: "OK", err: nil, result: "ok"},
+		{name: "Not found", err: storage.ErrNotFound, result: "not_found"},
+		{name: "Deleted", err: fmt.Errorf("DB: key: %w", storage.ErrDeleted), result: "gone"},
+		{name: "Expired", err: storage.ErrExpired, result: "gone"},
+		{name: "Key collision", err: fmt.Errorf("DB: key: %w", storage.ErrKeyCollision), result: "conflict"},
+		{name: "Batch URL unique violation", err: storage.ErrBatchURLUniqueViolation, result: "conflict"},
+		{name: "URL already exists", err: &storage.ErrURLArlreadyExists{Key: "key", URL: "url"}, result: "conflict"},
+		{name: "Unavailable", err: &storage.UnavailableError{Err: errors.New("connection refused")}, result: "unavailable"},
+		{name: "Other error", err: errors.New("disk is full"), result: "error"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := InstrumentStorage(getStorage{err: tc.err})
+			before := sampleCount(t, "get", tc.result)
+			url, err := db.Get(context.Background(), "key")
+			assert.Equal(t, "http://example.com", url)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, before+1, sampleCount(t, "get", tc.result))
+		})
+	}
+}
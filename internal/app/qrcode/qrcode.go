@@ -0,0 +1,169 @@
+// Пакет qrcode формирует изображения QR-кодов в форматах PNG и SVG.
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// Форматы изображения.
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// Значения параметров по умолчанию и допустимые пределы.
+const (
+	DefaultSize   = 256
+	DefaultLevel  = "M"
+	DefaultMargin = 4
+
+	minSize   = 32
+	maxSize   = 2048
+	maxMargin = 16
+)
+
+// ErrInvalidOptions возвращается при недопустимых параметрах изображения.
+var ErrInvalidOptions = errors.New("invalid QR code options")
+
+var levels = map[string]qr.Level{
+	"L": qr.L, // восстанавливается ~7% данных
+	"M": qr.M, // ~15%
+	"Q": qr.Q, // ~25%
+	"H": qr.H, // ~30%
+}
+
+// Options - параметры изображения QR-кода. Нулевые значения полей Format, Size и Level заменяются
+// значениями по умолчанию; отступ по умолчанию задаётся явно (DefaultMargin), т.к. 0 - допустимое значение.
+type Options struct {
+	// Format - формат изображения: FormatPNG или FormatSVG.
+	Format string
+	// Size - ширина и высота изображения в пикселях (от 32 до 2048).
+	Size int
+	// Level - уровень коррекции ошибок: L, M, Q или H.
+	Level string
+	// Margin - ширина пустого поля вокруг кода в модулях (от 0 до 16). Стандарт требует не менее 4 модулей.
+	Margin int
+}
+
+// DefaultOptions возвращает параметры изображения по умолчанию.
+func DefaultOptions() Options {
+	return Options{
+		Format: FormatPNG,
+		Size:   DefaultSize,
+		Level:  DefaultLevel,
+		Margin: DefaultMargin,
+	}
+}
+
+// ContentType возвращает MIME-тип изображения.
+func (o Options) ContentType() string {
+	if strings.EqualFold(o.Format, FormatSVG) {
+		return "image/svg+xml"
+	}
+
+	return "image/png"
+}
+
+// validate проверяет параметры и подставляет значения по умолчанию.
+func (o *Options) validate() error {
+	if o.Format == "" {
+		o.Format = FormatPNG
+	}
+	if o.Size == 0 {
+		o.Size = DefaultSize
+	}
+	if o.Level == "" {
+		o.Level = DefaultLevel
+	}
+	o.Format, o.Level = strings.ToLower(o.Format), strings.ToUpper(o.Level)
+	if o.Format != FormatPNG && o.Format != FormatSVG {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidOptions, o.Format)
+	}
+	if o.Size < minSize || o.Size > maxSize {
+		return fmt.Errorf("%w: size must be between %d and %d", ErrInvalidOptions, minSize, maxSize)
+	}
+	if _, ok := levels[o.Level]; !ok {
+		return fmt.Errorf("%w: unknown error correction level %q", ErrInvalidOptions, o.Level)
+	}
+	if o.Margin < 0 || o.Margin > maxMargin {
+		return fmt.Errorf("%w: margin must be between 0 and %d", ErrInvalidOptions, maxMargin)
+	}
+
+	return nil
+}
+
+// Encode формирует изображение QR-кода, содержащего строку text.
+func Encode(text string, opts Options) ([]byte, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	code, err := qr.Encode(text, levels[opts.Level])
+	if err != nil {
+		return nil, fmt.Errorf("qrcode: %w", err)
+	}
+	// размер модуля в пикселях - целый, чтобы границы модулей были чёткими; остаток распределяется
+	// поровну по краям изображения.
+	modules := code.Size + 2*opts.Margin
+	scale := opts.Size / modules
+	if scale < 1 {
+		return nil, fmt.Errorf("%w: size %d is too small, at least %d pixels are needed", ErrInvalidOptions, opts.Size, modules)
+	}
+	offset := (opts.Size-modules*scale)/2 + opts.Margin*scale
+
+	if opts.Format == FormatSVG {
+		return encodeSVG(code, opts.Size, scale, offset), nil
+	}
+
+	return encodePNG(code, opts.Size, scale, offset)
+}
+
+// encodePNG формирует монохромное изображение PNG.
+func encodePNG(code *qr.Code, size, scale, offset int) ([]byte, error) {
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.Black(x, y) {
+				continue
+			}
+			for py := offset + y*scale; py < offset+(y+1)*scale; py++ {
+				for px := offset + x*scale; px < offset+(x+1)*scale; px++ {
+					img.SetColorIndex(px, py, 1)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("qrcode: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeSVG формирует изображение SVG, в котором тёмные модули описаны одним контуром.
+func encodeSVG(code *qr.Code, size, scale, offset int) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		size, size, size, size)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", size, size)
+	b.WriteString(`<path fill="#000" d="`)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&b, "M%d %dh%dv%dh-%dz", offset+x*scale, offset+y*scale, scale, scale, scale)
+			}
+		}
+	}
+	b.WriteString("\"/>\n</svg>\n")
+
+	return b.Bytes()
+}
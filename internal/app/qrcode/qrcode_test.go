@@ -0,0 +1,84 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"rsc.io/qr"
+)
+
+func TestEncodePNG(t *testing.T) {
+	const text = "http://localhost:8080/promo"
+	tests := []struct {
+		name   string
+		opts   Options
+		level  qr.Level
+		margin int
+	}{
+		{name: "Default options", opts: DefaultOptions(), level: qr.M, margin: DefaultMargin},
+		{name: "No margin", opts: Options{Size: 100, Level: "h", Margin: 0}, level: qr.H, margin: 0},
+		{name: "Large", opts: Options{Format: "PNG", Size: 1000, Level: "L", Margin: 8}, level: qr.L, margin: 8},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := Encode(text, tc.opts)
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(data))
+			require.NoError(t, err)
+			require.Equal(t, tc.opts.Size, img.Bounds().Dx())
+			require.Equal(t, tc.opts.Size, img.Bounds().Dy())
+
+			// сравниваем центр каждого модуля с матрицей, построенной библиотекой
+			code, err := qr.Encode(text, tc.level)
+			require.NoError(t, err)
+			modules := code.Size + 2*tc.margin
+			scale := tc.opts.Size / modules
+			offset := (tc.opts.Size-modules*scale)/2 + tc.margin*scale
+			for y := -tc.margin; y < code.Size+tc.margin; y++ {
+				for x := -tc.margin; x < code.Size+tc.margin; x++ {
+					c := color.GrayModel.Convert(img.At(offset+x*scale+scale/2, offset+y*scale+scale/2)).(color.Gray)
+					black := x >= 0 && y >= 0 && x < code.Size && y < code.Size && code.Black(x, y)
+					require.Equal(t, black, c.Y == 0, "module (%d, %d)", x, y)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeSVG(t *testing.T) {
+	data, err := Encode("http://localhost:8080/promo", Options{Format: FormatSVG, Size: 300, Level: "Q", Margin: 2})
+	require.NoError(t, err)
+	svg := string(data)
+	assert.True(t, strings.HasPrefix(svg, "<?xml"))
+	assert.Contains(t, svg, `width="300" height="300"`)
+	assert.Contains(t, svg, `<path fill="#000" d="M`)
+	assert.True(t, strings.HasSuffix(svg, "</svg>\n"))
+	assert.Equal(t, "image/svg+xml", Options{Format: FormatSVG}.ContentType())
+	assert.Equal(t, "image/png", DefaultOptions().ContentType())
+}
+
+func TestEncodeInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "Unknown format", opts: Options{Format: "gif"}},
+		{name: "Too small", opts: Options{Size: 16}},
+		{name: "Too large", opts: Options{Size: 4096}},
+		{name: "Unknown level", opts: Options{Level: "X"}},
+		{name: "Negative margin", opts: Options{Margin: -1}},
+		{name: "Too large margin", opts: Options{Margin: 100}},
+		{name: "Size too small for the content", opts: Options{Size: 40, Margin: 16}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Encode("http://localhost:8080/promo", tc.opts)
+			assert.ErrorIs(t, err, ErrInvalidOptions)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+// Ограничения на длину пользовательского ключа (алиаса).
+const (
+	aliasMinLength = 3
+	aliasMaxLength = 64
+)
+
+// ErrInvalidAlias возвращается, если пользовательский ключ не удовлетворяет требованиям к алиасам.
+var ErrInvalidAlias = errors.New("wrong alias")
+
+// reservedAliases - ключи, совпадающие с путями сервиса. Сравнение выполняется без учёта регистра.
+var reservedAliases = map[string]struct{}{
+	"ping":    {},
+	"api":     {},
+	"healthz": {},
+	"readyz":  {},
+}
+
+// WithAlias задаёт пользовательский ключ короткой ссылки вместо случайно сгенерированного.
+func WithAlias(alias string) ShortenOption {
+	return func(rec *storage.Record) {
+		rec.Key = alias
+	}
+}
+
+// ValidKey проверяет, может ли строка быть ключом короткой ссылки: ключ состоит из латинских букв,
+// цифр, символов '-' и '_' и имеет длину не более aliasMaxLength.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > aliasMaxLength {
+		return false
+	}
+	for _, c := range key {
+		if !isKeyChar(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkAlias проверяет пользовательский ключ на соответствие набору символов, длине и списку зарезервированных слов.
+func checkAlias(alias string) error {
+	if len(alias) < aliasMinLength || !ValidKey(alias) {
+		return fmt.Errorf("%w %q: must be %d-%d characters long and contain only latin letters, digits, '-' and '_'",
+			ErrInvalidAlias, alias, aliasMinLength, aliasMaxLength)
+	}
+	if _, ok := reservedAliases[strings.ToLower(alias)]; ok {
+		return fmt.Errorf("%w %q: reserved word", ErrInvalidAlias, alias)
+	}
+
+	return nil
+}
+
+func isKeyChar(c rune) bool {
+	return c >= 'a' && c <= 'z' ||
+		c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' ||
+		c == '-' || c == '_'
+}
@@ -0,0 +1,38 @@
+package shortener
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAlias(t *testing.T) {
+	tests := []struct {
+		name    string
+		alias   string
+		wantErr bool
+	}{
+		{name: "Valid alias", alias: "my-link_1"},
+		{name: "Too short", alias: "ab", wantErr: true},
+		{name: "Too long", alias: strings.Repeat("a", aliasMaxLength+1), wantErr: true},
+		{name: "Invalid character", alias: "my/link", wantErr: true},
+		{name: "Reserved ping", alias: "ping", wantErr: true},
+		{name: "Reserved api in upper case", alias: "API", wantErr: true},
+		{name: "Reserved healthz", alias: "healthz", wantErr: true},
+		{name: "Reserved healthz in mixed case", alias: "HealthZ", wantErr: true},
+		{name: "Reserved readyz", alias: "readyz", wantErr: true},
+		{name: "Reserved readyz in upper case", alias: "READYZ", wantErr: true},
+		{name: "Reserved word as a prefix", alias: "healthz-check"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkAlias(tc.alias)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidAlias)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
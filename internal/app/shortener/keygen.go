@@ -0,0 +1,202 @@
+package shortener
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Стратегии генерации ключей.
+const (
+	// KeyGenRandom - случайные ключи из символов заданного алфавита.
+	KeyGenRandom = "random"
+	// KeyGenBase62 - случайные ключи из цифр, строчных и прописных латинских букв (алфавит задать нельзя).
+	KeyGenBase62 = "base62"
+	// KeyGenCounter - ключи на основе счётчика, зашифрованного секретным ключом, так что по выданным ключам
+	// нельзя предсказать следующие.
+	KeyGenCounter = "counter"
+)
+
+// feistelRounds - количество раундов сети Фейстеля генератора CounterGenerator.
+const feistelRounds = 8
+
+// Параметры генератора ключей по умолчанию.
+const (
+	DefaultKeyLength   = 8
+	DefaultKeyAlphabet = "abcdefghijklmnopqrstuvwxyz1234567890"
+
+	base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// KeyGenerator генерирует ключи коротких ссылок. Реализации должны быть потокобезопасными.
+// Уникальность ключа генератор не гарантирует: занятые ключи отсеиваются сервисом Shortener.
+type KeyGenerator interface {
+	Generate() (string, error)
+}
+
+type (
+	// RandomGenerator генерирует ключи из случайных символов алфавита, используя crypto/rand.
+	// Символы выбираются равновероятно (без смещения, которое даёт взятие остатка).
+	RandomGenerator struct {
+		alphabet string
+		length   int
+	}
+
+	// CounterGenerator генерирует ключи по значению счётчика. Значение счётчика шифруется сетью Фейстеля
+	// с секретным ключом: сеть переставляет числа [0, 2^2h), а значения, выходящие за количество возможных
+	// ключей N, шифруются повторно (cycle walking), поэтому получается перестановка чисел [0, N), и ключи
+	// не повторяются, пока счётчик не пройдёт все N значений. Без секретного ключа по выданным ключам
+	// нельзя восстановить ни счётчик, ни следующие ключи.
+	//
+	// Секретный ключ и начальное значение счётчика выбираются случайно при создании генератора, так что
+	// после перезапуска сервиса выдаётся другая последовательность; совпадения с ранее выданными ключами
+	// отсеиваются сервисом Shortener.
+	CounterGenerator struct {
+		alphabet string
+		length   int
+		space    uint64
+		// halfBits - размер половины блока сети Фейстеля в битах.
+		halfBits uint
+		secret   []byte
+		counter  uint64
+	}
+)
+
+var (
+	_ KeyGenerator = (*RandomGenerator)(nil)
+	_ KeyGenerator = (*CounterGenerator)(nil)
+)
+
+// NewKeyGenerator создаёт генератор ключей по названию стратегии strategy.
+// Для стратегии KeyGenBase62 параметр alphabet игнорируется.
+func NewKeyGenerator(strategy, alphabet string, length int) (KeyGenerator, error) {
+	switch strategy {
+	case KeyGenRandom, "":
+		return NewRandomGenerator(alphabet, length)
+	case KeyGenBase62:
+		return NewRandomGenerator(base62Alphabet, length)
+	case KeyGenCounter:
+		return NewCounterGenerator(alphabet, length)
+	}
+
+	return nil, fmt.Errorf("unknown key generator %q", strategy)
+}
+
+// NewRandomGenerator создаёт генератор случайных ключей длины length из символов alphabet.
+func NewRandomGenerator(alphabet string, length int) (*RandomGenerator, error) {
+	if err := checkKeyParams(alphabet, length); err != nil {
+		return nil, err
+	}
+
+	return &RandomGenerator{alphabet: alphabet, length: length}, nil
+}
+
+// Generate имплементирует интерфейс KeyGenerator.
+func (g *RandomGenerator) Generate() (string, error) {
+	max := big.NewInt(int64(len(g.alphabet)))
+	buf := make([]byte, g.length)
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("key generator: %w", err)
+		}
+		buf[i] = g.alphabet[n.Int64()]
+	}
+
+	return string(buf), nil
+}
+
+// NewCounterGenerator создаёт генератор ключей длины length из символов alphabet на основе счётчика.
+// Количество возможных ключей len(alphabet)^length не должно превышать 2^64.
+func NewCounterGenerator(alphabet string, length int) (*CounterGenerator, error) {
+	if err := checkKeyParams(alphabet, length); err != nil {
+		return nil, err
+	}
+	space := uint64(1)
+	for i := 0; i < length; i++ {
+		hi, lo := bits.Mul64(space, uint64(len(alphabet)))
+		if hi != 0 {
+			return nil, errors.New("key generator: too many possible keys for the counter strategy, reduce key length")
+		}
+		space = lo
+	}
+	seed := make([]byte, 8+sha256.Size)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, fmt.Errorf("key generator: %w", err)
+	}
+	halfBits := uint(bits.Len64(space-1)+1) / 2
+
+	return &CounterGenerator{
+		alphabet: alphabet,
+		length:   length,
+		space:    space,
+		halfBits: halfBits,
+		secret:   seed[8:],
+		counter:  binary.BigEndian.Uint64(seed[:8]) % space,
+	}, nil
+}
+
+// Generate имплементирует интерфейс KeyGenerator.
+func (g *CounterGenerator) Generate() (string, error) {
+	n := atomic.AddUint64(&g.counter, 1) % g.space
+	// повторное шифрование числа, выходящего за [0, N), рано или поздно возвращает его в [0, N):
+	// перестановка [0, 2^2h) распадается на циклы, а сам n из [0, N) лежит на том же цикле
+	n = g.encrypt(n)
+	for n >= g.space {
+		n = g.encrypt(n)
+	}
+
+	base := uint64(len(g.alphabet))
+	buf := make([]byte, g.length)
+	for i := g.length - 1; i >= 0; i-- {
+		buf[i] = g.alphabet[n%base]
+		n /= base
+	}
+
+	return string(buf), nil
+}
+
+// encrypt шифрует число x < 2^2h сетью Фейстеля с раундовой функцией HMAC-SHA256 по секретному ключу.
+func (g *CounterGenerator) encrypt(x uint64) uint64 {
+	mask := uint64(1)<<g.halfBits - 1
+	l, r := x>>g.halfBits, x&mask
+	var block [9]byte
+	for round := 0; round < feistelRounds; round++ {
+		block[0] = byte(round)
+		binary.BigEndian.PutUint64(block[1:], r)
+		mac := hmac.New(sha256.New, g.secret)
+		mac.Write(block[:])
+		l, r = r, l^(binary.BigEndian.Uint64(mac.Sum(nil))&mask)
+	}
+
+	return l<<g.halfBits | r
+}
+
+// checkKeyParams проверяет алфавит и длину ключа: символы алфавита должны быть допустимыми символами ключа
+// и не повторяться, длина ключа - не превышать максимальную длину алиаса.
+func checkKeyParams(alphabet string, length int) error {
+	if length < 1 || length > aliasMaxLength {
+		return fmt.Errorf("key generator: key length must be between 1 and %d", aliasMaxLength)
+	}
+	if len(alphabet) < 2 {
+		return errors.New("key generator: alphabet must contain at least 2 characters")
+	}
+	seen := make(map[rune]struct{}, len(alphabet))
+	for _, c := range alphabet {
+		if !isKeyChar(c) {
+			return fmt.Errorf("key generator: invalid character %q in the alphabet", c)
+		}
+		if _, ok := seen[c]; ok {
+			return fmt.Errorf("key generator: duplicate character %q in the alphabet", c)
+		}
+		seen[c] = struct{}{}
+	}
+
+	return nil
+}
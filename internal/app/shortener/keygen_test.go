@@ -0,0 +1,152 @@
+package shortener
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeyGenerator(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		alphabet string
+		length   int
+		wantErr  bool
+	}{
+		{name: "Default random", strategy: KeyGenRandom, alphabet: DefaultKeyAlphabet, length: DefaultKeyLength},
+		{name: "Base62 ignores alphabet", strategy: KeyGenBase62, alphabet: "", length: 6},
+		{name: "Counter", strategy: KeyGenCounter, alphabet: DefaultKeyAlphabet, length: DefaultKeyLength},
+		{name: "Unknown strategy", strategy: "uuid", alphabet: DefaultKeyAlphabet, length: 8, wantErr: true},
+		{name: "Zero length", strategy: KeyGenRandom, alphabet: DefaultKeyAlphabet, length: 0, wantErr: true},
+		{name: "Too long", strategy: KeyGenRandom, alphabet: DefaultKeyAlphabet, length: aliasMaxLength + 1, wantErr: true},
+		{name: "Single character alphabet", strategy: KeyGenRandom, alphabet: "a", length: 8, wantErr: true},
+		{name: "Duplicate characters", strategy: KeyGenRandom, alphabet: "abca", length: 8, wantErr: true},
+		{name: "Invalid character", strategy: KeyGenRandom, alphabet: "ab/", length: 8, wantErr: true},
+		{name: "Counter space overflow", strategy: KeyGenCounter, alphabet: base62Alphabet, length: 11, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gen, err := NewKeyGenerator(tc.strategy, tc.alphabet, tc.length)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			key, err := gen.Generate()
+			require.NoError(t, err)
+			assert.Len(t, key, tc.length)
+			assert.True(t, ValidKey(key))
+		})
+	}
+}
+
+func TestRandomGeneratorAlphabet(t *testing.T) {
+	gen, err := NewRandomGenerator("xyz", 16)
+	require.NoError(t, err)
+	seen := make(map[rune]bool)
+	for i := 0; i < 100; i++ {
+		key, err := gen.Generate()
+		require.NoError(t, err)
+		for _, c := range key {
+			require.True(t, strings.ContainsRune("xyz", c), "unexpected character %q", c)
+			seen[c] = true
+		}
+	}
+	assert.Len(t, seen, 3, "all characters of the alphabet must be used")
+}
+
+// TestCounterGeneratorUnique проверяет, что генератор на основе счётчика выдаёт все возможные ключи
+// без повторов, прежде чем начать заново.
+func TestCounterGeneratorUnique(t *testing.T) {
+	gen, err := NewCounterGenerator("abcdef", 4)
+	require.NoError(t, err)
+	space := 6 * 6 * 6 * 6
+	keys := make(map[string]struct{}, space)
+	sequential := 0
+	prev := ""
+	for i := 0; i < space; i++ {
+		key, err := gen.Generate()
+		require.NoError(t, err)
+		keys[key] = struct{}{}
+		if prev != "" && key[:3] == prev[:3] && key[3] == prev[3]+1 {
+			sequential++
+		}
+		prev = key
+	}
+	assert.Len(t, keys, space)
+	assert.Less(t, sequential, space/2, "keys must not look sequential")
+}
+
+// TestCounterGeneratorUnpredictable проверяет, что разность соседних ключей не постоянна: иначе по двум
+// ключам можно было бы вычислить все последующие.
+func TestCounterGeneratorUnpredictable(t *testing.T) {
+	gen, err := NewCounterGenerator(DefaultKeyAlphabet, DefaultKeyLength)
+	require.NoError(t, err)
+	decode := func(key string) uint64 {
+		var n uint64
+		for _, c := range key {
+			n = n*uint64(len(DefaultKeyAlphabet)) + uint64(strings.IndexRune(DefaultKeyAlphabet, c))
+		}
+		return n
+	}
+	space := gen.space
+	diffs := make(map[uint64]struct{})
+	prev := uint64(0)
+	for i := 0; i <= 100; i++ {
+		key, err := gen.Generate()
+		require.NoError(t, err)
+		n := decode(key)
+		if i > 0 {
+			diffs[(n+space-prev)%space] = struct{}{}
+		}
+		prev = n
+	}
+	assert.Greater(t, len(diffs), 90, "differences of consecutive keys must not repeat")
+}
+
+// collisionProbability - вероятность хотя бы одного совпадения среди n случайных ключей при space возможных
+// ключах (приближение задачи о днях рождения): p ≈ 1 - exp(-n(n-1) / 2·space).
+func collisionProbability(n, space float64) float64 {
+	return 1 - math.Exp(-n*(n-1)/(2*space))
+}
+
+// TestCollisionProbability документирует вероятность совпадения случайных ключей и проверяет,
+// что генератор ей соответствует.
+//
+// Для ключей по умолчанию (8 символов из 36, около 2.8·10^12 вариантов) вероятность хотя бы одного
+// совпадения составляет ~0.02% на 25 тыс. ключей, ~0.2% на 100 тыс. и ~16% на миллион ключей.
+// Совпадения не приводят к ошибкам - сервис генерирует новый ключ, но при большом количестве ссылок
+// стоит увеличить длину ключа или использовать стратегию base62 (62^8 ≈ 2.2·10^14 вариантов).
+func TestCollisionProbability(t *testing.T) {
+	defaultSpace := math.Pow(float64(len(DefaultKeyAlphabet)), DefaultKeyLength)
+	assert.InDelta(t, 0.0002, collisionProbability(25_000, defaultSpace), 0.0001)
+	assert.InDelta(t, 0.0018, collisionProbability(100_000, defaultSpace), 0.0001)
+	assert.InDelta(t, 0.163, collisionProbability(1_000_000, defaultSpace), 0.001)
+
+	// проверяем формулу экспериментально на маленьком пространстве ключей: 2^10 вариантов, по 32 ключа
+	const (
+		trials = 2000
+		n      = 32
+	)
+	gen, err := NewRandomGenerator("ab", 10)
+	require.NoError(t, err)
+	collisions := 0
+	for i := 0; i < trials; i++ {
+		keys := make(map[string]struct{}, n)
+		for j := 0; j < n; j++ {
+			key, err := gen.Generate()
+			require.NoError(t, err)
+			keys[key] = struct{}{}
+		}
+		if len(keys) < n {
+			collisions++
+		}
+	}
+	want := collisionProbability(n, 1024)
+	assert.InDelta(t, want, float64(collisions)/trials, 0.06,
+		"expected collision rate %.3f, got %.3f", want, float64(collisions)/trials)
+}
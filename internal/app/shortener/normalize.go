@@ -0,0 +1,125 @@
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// DefaultSchemes - схемы URL, разрешённые по умолчанию.
+var DefaultSchemes = []string{"http", "https"}
+
+// defaultPorts - порты по умолчанию, которые удаляются из адреса при нормализации.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+	"ws":    "80",
+	"wss":   "443",
+}
+
+type (
+	// URLNormalizer проверяет URL и приводит его к каноническому виду, чтобы адреса, отличающиеся только
+	// записью, сохранялись в хранилище одной записью. Всегда выполняются следующие преобразования:
+	// схема и хост приводятся к нижнему регистру, интернационализированные доменные имена - к punycode,
+	// удаляются порт по умолчанию для схемы, точка в конце доменного имени и путь "/".
+	// Остальные преобразования включаются опциями NormalizerOption.
+	URLNormalizer struct {
+		schemes            map[string]struct{}
+		sortQuery          bool
+		stripFragment      bool
+		stripTrailingSlash bool
+	}
+
+	// NormalizerOption включает дополнительные преобразования URLNormalizer.
+	NormalizerOption func(n *URLNormalizer)
+)
+
+// NewURLNormalizer создаёт URLNormalizer, пропускающий только URL со схемами из списка schemes.
+func NewURLNormalizer(schemes []string, opts ...NormalizerOption) (*URLNormalizer, error) {
+	if len(schemes) == 0 {
+		return nil, errors.New("url normalizer: no allowed schemes")
+	}
+	n := &URLNormalizer{schemes: make(map[string]struct{}, len(schemes))}
+	for _, scheme := range schemes {
+		n.schemes[strings.ToLower(scheme)] = struct{}{}
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	return n, nil
+}
+
+// WithSortedQuery включает сортировку параметров запроса по имени.
+func WithSortedQuery() NormalizerOption {
+	return func(n *URLNormalizer) {
+		n.sortQuery = true
+	}
+}
+
+// WithoutFragment включает удаление фрагмента (части URL после '#').
+func WithoutFragment() NormalizerOption {
+	return func(n *URLNormalizer) {
+		n.stripFragment = true
+	}
+}
+
+// WithoutTrailingSlash включает удаление завершающего '/' из непустого пути.
+func WithoutTrailingSlash() NormalizerOption {
+	return func(n *URLNormalizer) {
+		n.stripTrailingSlash = true
+	}
+}
+
+// Normalize проверяет строку rawURL и возвращает URL в каноническом виде. Если строка не является абсолютным
+// URL с хостом или схема URL не разрешена, возвращается ErrInvalidURL.
+func (n *URLNormalizer) Normalize(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if u.Opaque != "" || u.Host == "" || u.Scheme == "" {
+		return "", fmt.Errorf("%w: %s", ErrInvalidURL, rawURL)
+	}
+	if _, ok := n.schemes[u.Scheme]; !ok {
+		return "", fmt.Errorf("%w: scheme %q is not allowed", ErrInvalidURL, u.Scheme)
+	}
+
+	host, port := strings.TrimSuffix(u.Hostname(), "."), u.Port()
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+		if ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+	} else {
+		if host, err = idna.Lookup.ToASCII(host); err != nil || host == "" {
+			return "", fmt.Errorf("%w: invalid host %q", ErrInvalidURL, u.Hostname())
+		}
+	}
+	if port != "" && port != defaultPorts[u.Scheme] {
+		host += ":" + port
+	}
+	u.Host = host
+
+	if u.Path == "/" {
+		u.Path, u.RawPath = "", ""
+	}
+	if n.stripTrailingSlash && len(u.Path) > 1 {
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+	}
+	if n.sortQuery && u.RawQuery != "" {
+		u.RawQuery = u.Query().Encode() // url.Values.Encode сортирует параметры по имени
+	}
+	u.ForceQuery = false
+	if n.stripFragment {
+		u.Fragment, u.RawFragment = "", ""
+	}
+
+	return u.String(), nil
+}
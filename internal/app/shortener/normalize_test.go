@@ -0,0 +1,60 @@
+package shortener
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []NormalizerOption
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{name: "Already normalized", url: "http://example.com/path?b=2&a=1#top", want: "http://example.com/path?b=2&a=1#top"},
+		{name: "Case of scheme and host", url: "HTTPS://Example.COM/Path", want: "https://example.com/Path"},
+		{name: "Root path", url: "http://example.com/", want: "http://example.com"},
+		{name: "Default port", url: "https://example.com:443/a", want: "https://example.com/a"},
+		{name: "Non-default port", url: "http://example.com:8080/a", want: "http://example.com:8080/a"},
+		{name: "Trailing dot", url: "http://example.com./a", want: "http://example.com/a"},
+		{name: "IDN", url: "http://Пример.РФ/путь", want: "http://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{name: "IPv6", url: "http://[::1]:80/a", want: "http://[::1]/a"},
+		{name: "Spaces", url: "  http://example.com/a ", want: "http://example.com/a"},
+		{name: "Empty query", url: "http://example.com/a?", want: "http://example.com/a"},
+		{name: "Trailing slash kept", url: "http://example.com/a/", want: "http://example.com/a/"},
+		{name: "Trailing slash stripped", opts: []NormalizerOption{WithoutTrailingSlash()}, url: "http://example.com/a/", want: "http://example.com/a"},
+		{name: "Sorted query", opts: []NormalizerOption{WithSortedQuery()}, url: "http://example.com/?b=2&a=1&a=0", want: "http://example.com?a=1&a=0&b=2"},
+		{name: "Fragment stripped", opts: []NormalizerOption{WithoutFragment()}, url: "http://example.com/a#top", want: "http://example.com/a"},
+		{name: "No scheme", url: "example.com", wantErr: true},
+		{name: "No host", url: "http:///path", wantErr: true},
+		{name: "javascript", url: "javascript:alert(1)", wantErr: true},
+		{name: "data", url: "data:text/html,<script>alert(1)</script>", wantErr: true},
+		{name: "ftp is not allowed", url: "ftp://example.com/file", wantErr: true},
+		{name: "Invalid host", url: "http://exa mple.com", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := NewURLNormalizer(DefaultSchemes, tc.opts...)
+			require.NoError(t, err)
+			got, err := n.Normalize(tc.url)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidURL)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	_, err := NewURLNormalizer(nil)
+	assert.Error(t, err)
+	n, err := NewURLNormalizer([]string{"FTP"})
+	require.NoError(t, err)
+	got, err := n.Normalize("ftp://example.com:21/file")
+	require.NoError(t, err)
+	assert.Equal(t, "ftp://example.com/file", got)
+}
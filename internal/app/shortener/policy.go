@@ -0,0 +1,235 @@
+package shortener
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"golang.org/x/net/idna"
+)
+
+// ErrForbiddenDestination возвращается, если хост URL запрещён политикой Policy.
+var ErrForbiddenDestination = errors.New("destination is not allowed")
+
+type (
+	// Policy проверяет хосты сокращаемых URL по спискам запрещённых (blocklist) и разрешённых (allowlist)
+	// доменов. Хост запрещён, если он есть в blocklist, или если allowlist задан и хоста в нём нет.
+	//
+	// Списки хранятся в текстовых файлах, по одному правилу в строке; пустые строки и строки, начинающиеся
+	// с '#', игнорируются. Правило "example.com" соответствует только хосту example.com, правило
+	// "*.example.com" - хосту example.com и всем его поддоменам. Изменённые файлы перечитываются
+	// с заданной периодичностью; если файл не удалось прочитать, продолжает действовать прежний список.
+	Policy struct {
+		sync.RWMutex
+		blocklist listFile
+		allowlist listFile
+
+		stop chan struct{}
+		done chan struct{}
+
+		log logrus.FieldLogger
+	}
+
+	// PolicyOption задаёт необязательные параметры политики.
+	PolicyOption func(*Policy)
+
+	// listFile - список правил и сведения о файле, из которого он загружен.
+	listFile struct {
+		name    string
+		modTime time.Time
+		size    int64
+		hosts   *hostList
+	}
+
+	// hostList - набор правил для хостов.
+	hostList struct {
+		exact    map[string]struct{}
+		suffixes map[string]struct{} // домены из правил вида *.<домен>
+	}
+)
+
+// NewPolicy загружает списки из файлов blocklistFile и allowlistFile (пустое имя файла означает, что список
+// не задан) и запускает горутину, перечитывающую изменённые файлы с периодичностью reloadInterval.
+// Для её остановки следует вызвать Close.
+func NewPolicy(blocklistFile, allowlistFile string, reloadInterval time.Duration, opts ...PolicyOption) (*Policy, error) {
+	if reloadInterval <= 0 {
+		return nil, errors.New("policy: reload interval must be positive")
+	}
+	p := &Policy{
+		blocklist: listFile{name: blocklistFile},
+		allowlist: listFile{name: allowlistFile},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		log:       logger.Default(),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.log = p.log.WithField(logger.FieldComponent, "policy")
+	for _, f := range []*listFile{&p.blocklist, &p.allowlist} {
+		if f.name == "" {
+			continue
+		}
+		if _, err := f.reload(); err != nil {
+			return nil, fmt.Errorf("policy: %w", err)
+		}
+	}
+	go p.watcher(reloadInterval)
+
+	return p, nil
+}
+
+// WithPolicyLogger задаёт логгер политики.
+func WithPolicyLogger(l logrus.FieldLogger) PolicyOption {
+	return func(p *Policy) {
+		p.log = l
+	}
+}
+
+// Check проверяет, разрешено ли сокращать URL с хостом host и переходить по ним.
+// Если хост запрещён, возвращается ErrForbiddenDestination.
+func (p *Policy) Check(host string) error {
+	host = normalizeHost(host)
+	p.RLock()
+	defer p.RUnlock()
+
+	if p.blocklist.hosts != nil && p.blocklist.hosts.match(host) {
+		return fmt.Errorf("%w: %s is blocked", ErrForbiddenDestination, host)
+	}
+	if p.allowlist.hosts != nil && !p.allowlist.hosts.match(host) {
+		return fmt.Errorf("%w: %s is not in the allowlist", ErrForbiddenDestination, host)
+	}
+
+	return nil
+}
+
+// Close останавливает перечитывание файлов.
+func (p *Policy) Close() {
+	close(p.stop)
+	<-p.done
+}
+
+// watcher с периодичностью interval проверяет, изменились ли файлы списков, и перечитывает изменённые.
+// Работает в своей горутине до вызова Close.
+func (p *Policy) watcher(interval time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.reload()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// reload перечитывает изменённые файлы списков.
+func (p *Policy) reload() {
+	for _, f := range []*listFile{&p.blocklist, &p.allowlist} {
+		if f.name == "" {
+			continue
+		}
+		p.RLock()
+		next := *f
+		p.RUnlock()
+		changed, err := next.reload()
+		if err != nil {
+			p.log.WithError(err).Error("could not reload the list, keeping the previous one")
+			continue
+		}
+		if !changed {
+			continue
+		}
+		p.Lock()
+		*f = next
+		p.Unlock()
+		p.log.WithField("file", f.name).Info("list reloaded")
+	}
+}
+
+// reload перечитывает файл, если его время изменения или размер отличаются от запомненных.
+func (f *listFile) reload() (changed bool, err error) {
+	info, err := os.Stat(f.name)
+	if err != nil {
+		return false, err
+	}
+	if f.hosts != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return false, nil
+	}
+	file, err := os.Open(f.name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	hosts, err := parseHostList(file.Name(), bufio.NewScanner(file))
+	if err != nil {
+		return false, err
+	}
+	f.hosts, f.modTime, f.size = hosts, info.ModTime(), info.Size()
+
+	return true, nil
+}
+
+// parseHostList считывает правила из сканера s.
+func parseHostList(name string, s *bufio.Scanner) (*hostList, error) {
+	l := &hostList{
+		exact:    make(map[string]struct{}),
+		suffixes: make(map[string]struct{}),
+	}
+	for n := 1; s.Scan(); n++ {
+		rule := strings.TrimSpace(s.Text())
+		if rule == "" || strings.HasPrefix(rule, "#") {
+			continue
+		}
+		rules := l.exact
+		if strings.HasPrefix(rule, "*.") {
+			rule, rules = rule[2:], l.suffixes
+		}
+		host := normalizeHost(rule)
+		if host == "" || strings.ContainsAny(host, "*/:") {
+			return nil, fmt.Errorf("%s:%d: invalid rule %q", name, n, s.Text())
+		}
+		rules[host] = struct{}{}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return l, nil
+}
+
+// match проверяет, соответствует ли хост host какому-либо правилу списка.
+func (l *hostList) match(host string) bool {
+	if _, ok := l.exact[host]; ok {
+		return true
+	}
+	for {
+		if _, ok := l.suffixes[host]; ok {
+			return true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return false
+		}
+		host = host[i+1:]
+	}
+}
+
+// normalizeHost приводит доменное имя к виду, в котором оно хранится после нормализации URL:
+// нижний регистр, punycode, без точки в конце.
+func normalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		return ascii
+	}
+
+	return host
+}
@@ -0,0 +1,118 @@
+package shortener
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/dataloader"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/inmem"
+)
+
+// writeList атомарно заменяет файл списка, чтобы Policy не прочитал его недописанным.
+func writeList(t *testing.T, name, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(name+".tmp", []byte(content), 0600))
+	require.NoError(t, os.Rename(name+".tmp", name))
+}
+
+func TestPolicyCheck(t *testing.T) {
+	dir := t.TempDir()
+	blocklist := filepath.Join(dir, "blocklist.txt")
+	allowlist := filepath.Join(dir, "allowlist.txt")
+	writeList(t, blocklist, `
+# phishing
+evil.com
+*.phish.example.org
+*.пример.рф
+`)
+	writeList(t, allowlist, "*.example.org\n*.example.com\n*.xn--e1afmkfd.xn--p1ai\n")
+
+	tests := []struct {
+		name      string
+		blocklist string
+		allowlist string
+		host      string
+		wantErr   bool
+	}{
+		{name: "No lists", host: "evil.com"},
+		{name: "Blocked exact", blocklist: blocklist, host: "evil.com", wantErr: true},
+		{name: "Exact rule does not match subdomains", blocklist: blocklist, host: "www.evil.com"},
+		{name: "Blocked wildcard domain itself", blocklist: blocklist, host: "phish.example.org", wantErr: true},
+		{name: "Blocked wildcard subdomain", blocklist: blocklist, host: "a.b.PHISH.example.org.", wantErr: true},
+		{name: "Wildcard does not match by substring", blocklist: blocklist, host: "notphish.example.org"},
+		{name: "Blocked IDN", blocklist: blocklist, host: "www.xn--e1afmkfd.xn--p1ai", wantErr: true},
+		{name: "Allowed", allowlist: allowlist, host: "www.example.com"},
+		{name: "Not in the allowlist", allowlist: allowlist, host: "example.net", wantErr: true},
+		{name: "Blocklist has priority", blocklist: blocklist, allowlist: allowlist, host: "x.phish.example.org", wantErr: true},
+		{name: "Allowed by both lists", blocklist: blocklist, allowlist: allowlist, host: "docs.example.org"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewPolicy(tc.blocklist, tc.allowlist, time.Hour)
+			require.NoError(t, err)
+			defer p.Close()
+			err = p.Check(tc.host)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, ErrForbiddenDestination)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestNewPolicyErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.txt")
+	writeList(t, invalid, "good.com\nhttp://bad.com/path\n")
+
+	_, err := NewPolicy(filepath.Join(dir, "missing.txt"), "", time.Hour)
+	assert.Error(t, err)
+	_, err = NewPolicy(invalid, "", time.Hour)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid.txt:2")
+	_, err = NewPolicy("", "", 0)
+	assert.Error(t, err)
+}
+
+// TestPolicyReload проверяет, что изменения в файле списка применяются без перезапуска, в том числе
+// к уже сокращённым ссылкам, а при ошибке в файле продолжает действовать прежний список.
+func TestPolicyReload(t *testing.T) {
+	blocklist := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeList(t, blocklist, "")
+	p, err := NewPolicy(blocklist, "", 10*time.Millisecond)
+	require.NoError(t, err)
+	defer p.Close()
+
+	db, err := inmem.NewDB(filepath.Join(t.TempDir(), "test.db"), time.Hour)
+	require.NoError(t, err)
+	defer db.Close()
+	s := NewShortener("http://localhost:8080", db, dataloader.DataLoader{}, WithPolicy(p))
+	ctx := context.Background()
+	_, err = s.ShortenURL(ctx, uuid.New(), "http://example.com/promo", WithAlias("promo"))
+	require.NoError(t, err)
+
+	writeList(t, blocklist, "*.example.com\n")
+	require.Eventually(t, func() bool {
+		return p.Check("example.com") != nil
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = s.DecodeURL(ctx, "promo")
+	assert.ErrorIs(t, err, ErrForbiddenDestination, "links to newly blocked hosts must stop resolving")
+	_, err = s.ShortenURL(ctx, uuid.New(), "http://www.example.com/")
+	assert.ErrorIs(t, err, ErrForbiddenDestination)
+	resp, err := s.BatchShortenURLPartial(ctx, uuid.New(), []BatchShortenRequest{{CorrelationID: "1", OriginalURL: "http://example.com/a"}})
+	require.NoError(t, err)
+	assert.Equal(t, BatchStatusInvalid, resp[0].Status)
+
+	writeList(t, blocklist, "*\n")
+	time.Sleep(50 * time.Millisecond)
+	assert.Error(t, p.Check("example.com"), "the previous list must be kept if the file is invalid")
+	assert.NoError(t, p.Check("example.net"))
+}
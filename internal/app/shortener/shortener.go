@@ -3,18 +3,47 @@ package shortener
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"math/rand"
 	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/analytics"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/dataloader"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/qrcode"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
 )
 
-// keyLength определяет длину ключа короткого адреса.
-const keyLength = 8
+// Статусы обработки записей пакетного запроса.
+const (
+	// BatchStatusCreated - создана новая короткая ссылка.
+	BatchStatusCreated = "created"
+	// BatchStatusExists - URL уже был сокращён ранее, возвращается существующая короткая ссылка.
+	BatchStatusExists = "exists"
+	// BatchStatusInvalid - запись не сохранена из-за ошибки в её параметрах.
+	BatchStatusInvalid = "invalid"
+)
+
+// maxKeyAttempts - максимальное количество попыток сгенерировать свободный ключ. Исчерпать его можно только
+// при почти заполненном пространстве ключей: в этом случае следует увеличить длину ключа.
+const maxKeyAttempts = 100
+
+// titleMaxLength - максимальная длина заголовка ссылки в символах.
+const titleMaxLength = 256
+
+var (
+	// ErrInvalidURL возвращается, если переданная строка не является корректным URL.
+	ErrInvalidURL = errors.New("wrong URL")
+	// ErrInvalidExpiry возвращается, если время окончания действия ссылки уже наступило.
+	ErrInvalidExpiry = errors.New("expiration time must be in the future")
+	// ErrInvalidTitle возвращается, если заголовок ссылки длиннее titleMaxLength символов.
+	ErrInvalidTitle = fmt.Errorf("title must not be longer than %d characters", titleMaxLength)
+)
 
 // Shortener - сервис создания, хранения и получения коротких URL адресов.
 type (
@@ -23,24 +52,119 @@ type (
 		BaseURL string
 
 		dl dataloader.DataLoader
+		// keys - генератор ключей коротких ссылок.
+		keys KeyGenerator
+		// normalizer проверяет и нормализует сокращаемые URL.
+		normalizer *URLNormalizer
+		// policy проверяет хосты сокращаемых URL. Если nil, разрешены любые хосты.
+		policy *Policy
+		// clicks - сервис сохранения переходов по ссылкам. Если nil, переходы не учитываются.
+		clicks *analytics.Recorder
+		// log - логгер сервиса, используемый, если в контексте запроса нет логгера запроса.
+		log logrus.FieldLogger
 	}
 
+	// Option задаёт необязательные параметры сервиса Shortener.
+	Option func(s *Shortener)
+
 	BatchShortenRequest struct {
-		CorrelationID string `json:"correlation_id"`
-		OriginalURL   string `json:"original_url"`
+		CorrelationID string    `json:"correlation_id"`
+		OriginalURL   string    `json:"original_url"`
+		ExpiresAt     time.Time `json:"expires_at,omitempty"`
+		// Alias - пользовательский ключ. Если не задан, ключ генерируется.
+		Alias string `json:"alias,omitempty"`
+		// Title и AlwaysPreview - заголовок ссылки и признак обязательного показа страницы предпросмотра.
+		Title         string `json:"title,omitempty"`
+		AlwaysPreview bool   `json:"always_preview,omitempty"`
 	}
 	BatchShortenResponse struct {
 		CorrelationID string `json:"correlation_id"`
-		ShortURL      string `json:"short_url"`
+		ShortURL      string `json:"short_url,omitempty"`
+		// Status - результат обработки записи: BatchStatusCreated, BatchStatusExists или BatchStatusInvalid.
+		Status string `json:"status"`
+		// Error - причина, по которой запись не сохранена (для статуса BatchStatusInvalid).
+		Error string `json:"error,omitempty"`
 	}
+
+	// ShortenOption задаёт дополнительные параметры создаваемой короткой ссылки.
+	ShortenOption func(rec *storage.Record)
 )
 
+// WithExpiration ограничивает срок действия ссылки моментом expiresAt.
+// Нулевое значение expiresAt означает, что срок действия не ограничен.
+func WithExpiration(expiresAt time.Time) ShortenOption {
+	return func(rec *storage.Record) {
+		rec.ExpiresAt = expiresAt
+	}
+}
+
+// WithTitle задаёт заголовок ссылки, который показывается на странице предпросмотра.
+func WithTitle(title string) ShortenOption {
+	return func(rec *storage.Record) {
+		rec.Title = strings.TrimSpace(title)
+	}
+}
+
+// WithAlwaysPreview включает показ страницы предпросмотра при каждом переходе по ссылке
+// (например, если адрес назначения не вызывает доверия).
+func WithAlwaysPreview(always bool) ShortenOption {
+	return func(rec *storage.Record) {
+		rec.AlwaysPreview = always
+	}
+}
+
 // NewShortener инициализирует новую структуру Shortener с использованием заданного хранилища.
-func NewShortener(baseURL string, db storage.Storage, dl dataloader.DataLoader) *Shortener {
-	return &Shortener{
+// По умолчанию ключи генерируются стратегией KeyGenRandom с параметрами DefaultKeyAlphabet и DefaultKeyLength,
+// а сокращать разрешается только URL со схемами DefaultSchemes.
+func NewShortener(baseURL string, db storage.Storage, dl dataloader.DataLoader, opts ...Option) *Shortener {
+	s := &Shortener{
 		BaseURL: baseURL,
 		db:      db,
 		dl:      dl,
+		keys:    &RandomGenerator{alphabet: DefaultKeyAlphabet, length: DefaultKeyLength},
+		log:     logger.Default(),
+	}
+	s.normalizer, _ = NewURLNormalizer(DefaultSchemes)
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithKeyGenerator задаёт генератор ключей коротких ссылок.
+func WithKeyGenerator(keys KeyGenerator) Option {
+	return func(s *Shortener) {
+		s.keys = keys
+	}
+}
+
+// WithURLNormalizer задаёт правила проверки и нормализации сокращаемых URL.
+func WithURLNormalizer(n *URLNormalizer) Option {
+	return func(s *Shortener) {
+		s.normalizer = n
+	}
+}
+
+// WithPolicy включает проверку хостов сокращаемых URL политикой p. Проверка повторяется и при переходе
+// по короткой ссылке, поэтому ссылки на хосты, запрещённые после сокращения, перестают работать.
+func WithPolicy(p *Policy) Option {
+	return func(s *Shortener) {
+		s.policy = p
+	}
+}
+
+// WithClickRecorder включает учёт переходов по коротким ссылкам с помощью сервиса rec.
+func WithClickRecorder(rec *analytics.Recorder) Option {
+	return func(s *Shortener) {
+		s.clicks = rec
+	}
+}
+
+// WithLogger задаёт логгер сервиса.
+func WithLogger(l logrus.FieldLogger) Option {
+	return func(s *Shortener) {
+		s.log = l
 	}
 }
 
@@ -49,34 +173,133 @@ func (s Shortener) Ping() error {
 	return s.db.Ping()
 }
 
-// ShortenURL генерирует для переданного URL рандомный ключ, производит проверку его уникальности
-// и сохраняет в хранилище.
-func (s Shortener) ShortenURL(ctx context.Context, id uuid.UUID, urlStr string) (shortURL string, retErr error) {
-	url, err := checkURL(urlStr)
+// ShortenURL генерирует для переданного URL ключ и сохраняет пару в хранилище, повторяя попытку с новым ключом,
+// если сгенерированный ключ уже занят. Если задан пользовательский ключ (WithAlias), сохраняется он; если алиас
+// уже занят, возвращается storage.ErrKeyCollision.
+func (s Shortener) ShortenURL(ctx context.Context, id uuid.UUID, urlStr string, opts ...ShortenOption) (string, error) {
+	rec, err := s.newRecord(urlStr, opts...)
+	if err != nil {
+		return "", err
+	}
+	key, err := s.store(ctx, id, rec)
 	if err != nil {
 		return "", err
 	}
 
-	// цикл проверки уникальности
-	for {
-		key := generateKey()
-		if _, err := s.db.Get(ctx, key); err != nil {
-			err = s.db.Store(ctx, id, key, url.String())
-			if err != nil {
-				return "", err
-			}
-			// log.Printf("[INF] shortener: ShortenURL: created a token %v for %v", key, url)
-			shortURL = fmt.Sprintf("%s/%s", s.BaseURL, key)
+	return s.shortURL(key), nil
+}
+
+// newRecord проверяет параметры создаваемой ссылки и формирует запись для сохранения в хранилище.
+// URL сохраняется в каноническом виде (см. URLNormalizer).
+func (s Shortener) newRecord(urlStr string, opts ...ShortenOption) (storage.Record, error) {
+	url, err := s.normalizer.Normalize(urlStr)
+	if err != nil {
+		return storage.Record{}, err
+	}
+	if err := s.checkPolicy(url); err != nil {
+		return storage.Record{}, err
+	}
+	rec := storage.Record{OriginalURL: url, CreatedAt: time.Now()}
+	for _, opt := range opts {
+		opt(&rec)
+	}
+	if err := checkExpiry(rec.ExpiresAt); err != nil {
+		return storage.Record{}, err
+	}
+	if utf8.RuneCountInString(rec.Title) > titleMaxLength {
+		return storage.Record{}, ErrInvalidTitle
+	}
+	if rec.Key != "" {
+		if err := checkAlias(rec.Key); err != nil {
+			return storage.Record{}, err
+		}
+	}
+
+	return rec, nil
+}
+
+// store сохраняет запись rec в хранилище и возвращает её ключ. Если ключ записи не задан, он генерируется.
+func (s Shortener) store(ctx context.Context, id uuid.UUID, rec storage.Record) (string, error) {
+	if rec.Key != "" {
+		if err := s.db.Store(ctx, id, rec); err != nil {
+			return "", err
+		}
+
+		return rec.Key, nil
+	}
 
-			return shortURL, nil
+	// ключ резервируется атомарно самим хранилищем: при коллизии (в том числе с удалённой или просроченной
+	// записью) генерируется новый ключ, остальные ошибки, включая ErrURLArlreadyExists, возвращаются вызывающему.
+	for i := 0; i < maxKeyAttempts; i++ {
+		key, err := s.generateKey()
+		if err != nil {
+			return "", err
+		}
+		rec.Key = key
+		err = s.db.Store(ctx, id, rec)
+		if errors.Is(err, storage.ErrKeyCollision) {
+			s.logger(ctx).WithField("key", key).Debug("key is already in use, generating another one")
+			continue
 		}
-		log.Printf("Wow!!! %d-значный случайный код повторился! Совпадение? Не думаю!", keyLength)
+		if err != nil {
+			return "", err
+		}
+
+		return key, nil
 	}
+
+	return "", fmt.Errorf("shortener: could not find a free key in %d attempts", maxKeyAttempts)
 }
 
-// DecodeURL возвращает изначальный URL по ключу.
+// DecodeURL возвращает изначальный URL по ключу. Если хост URL запрещён политикой,
+// возвращается ErrForbiddenDestination.
 func (s Shortener) DecodeURL(ctx context.Context, key string) (string, error) {
-	return s.db.Get(ctx, key)
+	url, err := s.db.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if err := s.checkPolicy(url); err != nil {
+		return "", err
+	}
+
+	return url, nil
+}
+
+// Lookup возвращает сведения о короткой ссылке с ключом key. Ошибки те же, что и у метода DecodeURL.
+func (s Shortener) Lookup(ctx context.Context, key string) (storage.Record, error) {
+	rec, err := s.db.Lookup(ctx, key)
+	if err != nil {
+		return storage.Record{}, err
+	}
+	if err := s.checkPolicy(rec.OriginalURL); err != nil {
+		return storage.Record{}, err
+	}
+
+	return rec, nil
+}
+
+// QRCode возвращает изображение QR-кода короткой ссылки с ключом key. Ошибки те же, что и у метода DecodeURL;
+// при недопустимых параметрах изображения возвращается qrcode.ErrInvalidOptions.
+func (s Shortener) QRCode(ctx context.Context, key string, opts qrcode.Options) ([]byte, error) {
+	rec, err := s.Lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return qrcode.Encode(s.shortURL(rec.Key), opts)
+}
+
+// RecordClick ставит в очередь на сохранение переход по короткой ссылке.
+func (s Shortener) RecordClick(click storage.Click) {
+	if s.clicks == nil {
+		return
+	}
+	s.clicks.Record(click)
+}
+
+// ClickStats возвращает статистику переходов по ключу key, созданному пользователем с переданным id.
+func (s Shortener) ClickStats(ctx context.Context, id uuid.UUID, key string) (storage.ClickStats, error) {
+	return s.db.ClickStats(ctx, id, key)
 }
 
 // GetAll возвращает записи всех URL, созданных пользователем с переданным id.
@@ -84,18 +307,25 @@ func (s Shortener) GetAll(ctx context.Context, id uuid.UUID) map[string]string {
 	return s.db.GetAll(ctx, id)
 }
 
-// BatchShortenURL формирует ключи для переданных чURL и передает данные на сохранение в базу данных.
+// BatchShortenURL формирует ключи для переданных URL и передает данные на сохранение в базу данных.
+// Записи сохраняются атомарно: если хотя бы одна из них не может быть сохранена, возвращается ошибка
+// и не сохраняется ни одна запись. Как и в ShortenURL, коллизия сгенерированного ключа ошибкой не является.
 func (s Shortener) BatchShortenURL(ctx context.Context, id uuid.UUID, request []BatchShortenRequest) ([]BatchShortenResponse, error) {
 	records := make([]storage.Record, 0, len(request))
-	for _, rec := range request {
-		records = append(records, storage.Record{
-			CorellationID: rec.CorrelationID,
-			OriginalURL:   rec.OriginalURL,
-			Key:           generateKey(),
-		})
+	var generated []int // индексы записей, ключи которых генерируются
+	for _, req := range request {
+		rec, err := s.newRecord(req.OriginalURL, req.options()...)
+		if err != nil {
+			return nil, fmt.Errorf("correlation id %s: %w", req.CorrelationID, err)
+		}
+		if rec.Key == "" {
+			generated = append(generated, len(records))
+		}
+		rec.CorellationID = req.CorrelationID
+		records = append(records, rec)
 	}
 
-	if err := s.db.BatchStore(ctx, id, records); err != nil {
+	if err := s.batchStore(ctx, id, records, generated); err != nil {
 		return nil, err
 	}
 
@@ -103,10 +333,116 @@ func (s Shortener) BatchShortenURL(ctx context.Context, id uuid.UUID, request []
 	for i, rec := range records {
 		batchResp[i] = BatchShortenResponse{
 			CorrelationID: rec.CorellationID,
-			ShortURL:      fmt.Sprintf("%s/%s", s.BaseURL, rec.Key),
+			ShortURL:      s.shortURL(rec.Key),
+			Status:        BatchStatusCreated,
 		}
 	}
-	log.Printf("shortener: Batch: successfully added %d records to the repository", len(records))
+	s.logger(ctx).Infof("batch: added %d records", len(records))
+
+	return batchResp, nil
+}
+
+// batchStore атомарно сохраняет записи records, генерируя ключи записей с индексами generated. Если хранилище
+// сообщает о коллизии ключа, а пользовательские алиасы свободны, значит занят один из сгенерированных ключей:
+// они генерируются заново (алиасы не меняются), и попытка повторяется, всего не более maxKeyAttempts раз.
+func (s Shortener) batchStore(ctx context.Context, id uuid.UUID, records []storage.Record, generated []int) error {
+	for i := 0; i < maxKeyAttempts; i++ {
+		for _, j := range generated {
+			key, err := s.generateKey()
+			if err != nil {
+				return err
+			}
+			records[j].Key = key
+		}
+		err := s.db.BatchStore(ctx, id, records)
+		if !errors.Is(err, storage.ErrKeyCollision) || len(generated) == 0 {
+			return err
+		}
+		taken, lookupErr := s.aliasTaken(ctx, records, generated)
+		if lookupErr != nil {
+			return lookupErr
+		}
+		if taken {
+			return err
+		}
+		s.logger(ctx).Debug("batch: generated key is already in use, generating new keys")
+	}
+
+	return fmt.Errorf("shortener: could not find free keys for the batch in %d attempts", maxKeyAttempts)
+}
+
+// aliasTaken проверяет, занят ли хотя бы один пользовательский ключ из records (записи с индексами generated
+// пропускаются) - другой записью хранилища, в том числе удалённой или просроченной, или алиасом того же пакета.
+func (s Shortener) aliasTaken(ctx context.Context, records []storage.Record, generated []int) (bool, error) {
+	skip := make(map[int]struct{}, len(generated))
+	for _, j := range generated {
+		skip[j] = struct{}{}
+	}
+	aliases := make(map[string]struct{}, len(records)-len(generated))
+	for i, rec := range records {
+		if _, ok := skip[i]; ok {
+			continue
+		}
+		if _, ok := aliases[rec.Key]; ok {
+			return true, nil
+		}
+		aliases[rec.Key] = struct{}{}
+		_, err := s.db.Lookup(ctx, rec.Key)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+		case err == nil, errors.Is(err, storage.ErrDeleted), errors.Is(err, storage.ErrExpired):
+			return true, nil
+		default:
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// options возвращает параметры создаваемой ссылки, заданные в записи пакетного запроса.
+func (req BatchShortenRequest) options() []ShortenOption {
+	return []ShortenOption{
+		WithExpiration(req.ExpiresAt),
+		WithAlias(req.Alias),
+		WithTitle(req.Title),
+		WithAlwaysPreview(req.AlwaysPreview),
+	}
+}
+
+// BatchShortenURLPartial сохраняет записи пакетного запроса по отдельности и возвращает результат обработки
+// каждой из них: новую короткую ссылку, существующую короткую ссылку для уже сокращённого URL или причину,
+// по которой запись не может быть сохранена. Ошибка возвращается только при сбое хранилища; записи,
+// сохранённые до сбоя, остаются в хранилище, и при повторном запросе для них вернётся статус BatchStatusExists.
+func (s Shortener) BatchShortenURLPartial(ctx context.Context, id uuid.UUID, request []BatchShortenRequest) ([]BatchShortenResponse, error) {
+	batchResp := make([]BatchShortenResponse, len(request))
+	created := 0
+	for i, req := range request {
+		batchResp[i].CorrelationID = req.CorrelationID
+		rec, err := s.newRecord(req.OriginalURL, req.options()...)
+		if err != nil {
+			batchResp[i].Status, batchResp[i].Error = BatchStatusInvalid, err.Error()
+			continue
+		}
+		key, err := s.store(ctx, id, rec)
+		var errURLAlreadyExists *storage.ErrURLArlreadyExists
+		switch {
+		case err == nil:
+			batchResp[i].Status, batchResp[i].ShortURL = BatchStatusCreated, s.shortURL(key)
+			created++
+		case errors.As(err, &errURLAlreadyExists):
+			batchResp[i].Status, batchResp[i].ShortURL = BatchStatusExists, s.shortURL(errURLAlreadyExists.Key)
+		case errors.Is(err, storage.ErrKeyCollision): // алиас уже занят - возможно, этой же ссылкой
+			if url, err := s.db.Get(ctx, rec.Key); err == nil && url == rec.OriginalURL {
+				batchResp[i].Status, batchResp[i].ShortURL = BatchStatusExists, s.shortURL(rec.Key)
+				continue
+			}
+			batchResp[i].Status, batchResp[i].Error = BatchStatusInvalid, "alias is already taken"
+		default:
+			return nil, fmt.Errorf("correlation id %s: %w", req.CorrelationID, err)
+		}
+	}
+	s.logger(ctx).Infof("batch: added %d of %d records", created, len(request))
 
 	return batchResp, nil
 }
@@ -121,28 +457,47 @@ func (s Shortener) Stats(ctx context.Context) (urls int, users int, err error) {
 	return s.db.Stats(ctx)
 }
 
-// generateKey создает рандомную строку из строчных букв и цифр. Длина строки задана в глобальной переменной keyLength.
-func generateKey() string {
-	const chars = "abcdefghijklmnopqrstuvwxyz1234567890"
-	buf := make([]byte, keyLength)
-	for i := range buf {
-		buf[i] = chars[rand.Intn(len(chars))]
-	}
+// shortURL возвращает короткую ссылку для ключа key.
+func (s Shortener) shortURL(key string) string {
+	return fmt.Sprintf("%s/%s", s.BaseURL, key)
+}
 
-	return string(buf)
+// logger возвращает логгер запроса ctx, а если его нет - логгер сервиса.
+func (s Shortener) logger(ctx context.Context) logrus.FieldLogger {
+	return logger.FromContextOr(ctx, s.log).WithField(logger.FieldComponent, "shortener")
 }
 
-// checkURL проверяет входящую строку, является ли она URL с полями scheme и host.
-func checkURL(u string) (*url.URL, error) {
-	url, err := url.Parse(u)
-	if err != nil {
+// generateKey возвращает новый ключ, пропуская ключи, совпадающие с зарезервированными словами.
+func (s Shortener) generateKey() (string, error) {
+	for {
+		key, err := s.keys.Generate()
+		if err != nil {
+			return "", err
+		}
+		if _, ok := reservedAliases[strings.ToLower(key)]; !ok {
+			return key, nil
+		}
+	}
+}
 
-		return nil, err
+// checkPolicy проверяет хост URL urlStr политикой сервиса.
+func (s Shortener) checkPolicy(urlStr string) error {
+	if s.policy == nil {
+		return nil
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
 	}
-	if url.Host == "" || url.Scheme == "" {
 
-		return nil, fmt.Errorf("wrong URL: %s", u)
+	return s.policy.Check(u.Hostname())
+}
+
+// checkExpiry проверяет, что время окончания действия ссылки (если оно задано) ещё не наступило.
+func checkExpiry(expiresAt time.Time) error {
+	if storage.Expired(expiresAt, time.Now()) {
+		return ErrInvalidExpiry
 	}
 
-	return url, nil
+	return nil
 }
@@ -0,0 +1,189 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/dataloader"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/inmem"
+)
+
+// TestShortenURLConcurrent - стресс-тест для запуска с флагом -race: много параллельных запросов на маленьком
+// пространстве ключей, чтобы коллизии случались постоянно. Каждый URL отправляется несколькими запросами
+// одновременно. Ни один ключ не должен достаться двум URL, и ни один URL не должен получить два ключа.
+func TestShortenURLConcurrent(t *testing.T) {
+	const (
+		urls    = 96 // из 2^7 = 128 возможных ключей
+		copies  = 4
+		baseURL = "http://localhost:8080"
+	)
+	db, err := inmem.NewDB(filepath.Join(t.TempDir(), "test.db"), time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+	keys, err := NewRandomGenerator("ab", 7)
+	require.NoError(t, err)
+	s := NewShortener(baseURL, db, dataloader.DataLoader{}, WithKeyGenerator(keys))
+
+	type result struct {
+		url string
+		key string
+		err error
+	}
+	results := make(chan result, urls*copies)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < urls; i++ {
+		for c := 0; c < copies; c++ {
+			wg.Add(1)
+			go func(url string) {
+				defer wg.Done()
+				<-start
+				shortURL, err := s.ShortenURL(context.Background(), uuid.New(), url)
+				results <- result{url: url, key: strings.TrimPrefix(shortURL, baseURL+"/"), err: err}
+			}(fmt.Sprintf("http://example.com/%d", i))
+		}
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	keyURL := make(map[string]string)  // ключ -> URL
+	created := make(map[string]string) // URL -> ключ, созданный для него
+	existing := make(map[string][]string)
+	for res := range results {
+		var errURLAlreadyExists *storage.ErrURLArlreadyExists
+		switch {
+		case res.err == nil:
+			_, ok := created[res.url]
+			require.False(t, ok, "URL %s was stored twice", res.url)
+			created[res.url] = res.key
+			u, ok := keyURL[res.key]
+			require.False(t, ok, "key %s was given to both %s and %s", res.key, u, res.url)
+			keyURL[res.key] = res.url
+		case errors.As(res.err, &errURLAlreadyExists):
+			existing[res.url] = append(existing[res.url], errURLAlreadyExists.Key)
+		default:
+			require.NoError(t, res.err)
+		}
+	}
+	require.Len(t, created, urls)
+	for url, keys := range existing {
+		for _, key := range keys {
+			assert.Equal(t, created[url], key, "a duplicate of %s must return the existing key", url)
+		}
+	}
+	for key, url := range keyURL {
+		got, err := db.Get(context.Background(), key)
+		require.NoError(t, err)
+		assert.Equal(t, url, got)
+	}
+}
+
+// sequenceKeyGenerator возвращает ключи из списка по очереди, а после его окончания - последний ключ.
+type sequenceKeyGenerator struct {
+	mu    sync.Mutex
+	keys  []string
+	calls int
+}
+
+func (g *sequenceKeyGenerator) Generate() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := g.keys[len(g.keys)-1]
+	if g.calls < len(g.keys) {
+		key = g.keys[g.calls]
+	}
+	g.calls++
+
+	return key, nil
+}
+
+func TestBatchShortenURLKeyCollision(t *testing.T) {
+	const baseURL = "http://localhost:8080"
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		keys     []string
+		request  []BatchShortenRequest
+		wantKeys []string
+		wantErr  error
+		// wantCalls - ожидаемое количество сгенерированных ключей.
+		wantCalls int
+	}{
+		{
+			name: "Generated key collides with existing key",
+			keys: []string{"taken", "fresh1", "fresh2"},
+			request: []BatchShortenRequest{
+				{CorrelationID: "1", OriginalURL: "http://example.com/1"},
+				{CorrelationID: "2", OriginalURL: "http://example.com/2", Alias: "my-alias"},
+			},
+			wantKeys:  []string{"fresh1", "my-alias"},
+			wantCalls: 2,
+		},
+		{
+			name: "Generated keys collide with each other",
+			keys: []string{"same", "same", "fresh1", "fresh2"},
+			request: []BatchShortenRequest{
+				{CorrelationID: "1", OriginalURL: "http://example.com/1"},
+				{CorrelationID: "2", OriginalURL: "http://example.com/2"},
+			},
+			wantKeys:  []string{"fresh1", "fresh2"},
+			wantCalls: 4,
+		},
+		{
+			name: "Alias is taken",
+			keys: []string{"fresh1"},
+			request: []BatchShortenRequest{
+				{CorrelationID: "1", OriginalURL: "http://example.com/1"},
+				{CorrelationID: "2", OriginalURL: "http://example.com/2", Alias: "taken"},
+			},
+			wantErr:   storage.ErrKeyCollision,
+			wantCalls: 1,
+		},
+		{
+			name:      "Generator always returns taken key",
+			keys:      []string{"taken"},
+			request:   []BatchShortenRequest{{CorrelationID: "1", OriginalURL: "http://example.com/1"}},
+			wantCalls: maxKeyAttempts,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := inmem.NewDB(filepath.Join(t.TempDir(), "test.db"), time.Hour)
+			require.NoError(t, err)
+			t.Cleanup(db.Close)
+			require.NoError(t, db.Store(ctx, uuid.New(), storage.Record{Key: "taken", OriginalURL: "http://example.com/taken"}))
+			keys := &sequenceKeyGenerator{keys: tc.keys}
+			s := NewShortener(baseURL, db, dataloader.DataLoader{}, WithKeyGenerator(keys))
+
+			resp, err := s.BatchShortenURL(ctx, uuid.New(), tc.request)
+			assert.Equal(t, tc.wantCalls, keys.calls)
+			switch {
+			case tc.wantErr != nil:
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			case tc.wantKeys == nil:
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, resp, len(tc.wantKeys))
+			for i, key := range tc.wantKeys {
+				assert.Equal(t, baseURL+"/"+key, resp[i].ShortURL)
+				url, err := db.Get(ctx, key)
+				require.NoError(t, err)
+				assert.Equal(t, tc.request[i].OriginalURL, url)
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+// Пакет boltdb - реализация хранилища ключей во встроенной key-value базе bbolt (B+ дерево на диске).
+// Хранилище не требует отдельного сервера БД и не держит все данные в памяти.
+package boltdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ storage.Storage = (*DB)(nil)
+
+// Бакеты базы данных.
+var (
+	// keysBucket: <key> -> record в формате JSON (включая удалённые записи).
+	keysBucket = []byte("keys")
+	// urlsBucket: <SHA-256 от url> -> <key>. Обеспечивает уникальность неудалённых URL. Ключи bbolt
+	// ограничены 32 КБ, а длина URL - нет, поэтому бакет индексируется хешем URL.
+	urlsBucket = []byte("url_hashes")
+	// legacyURLsBucket - индекс URL из предыдущих версий: <url> -> <key>. При открытии базы переводится в urlsBucket.
+	legacyURLsBucket = []byte("urls")
+	// usersBucket содержит вложенный бакет для каждого пользователя: <key> -> пустое значение.
+	// В бакете пользователя хранятся только неудалённые записи, пустые бакеты удаляются.
+	usersBucket = []byte("users")
+	// dailyClicksBucket содержит вложенный бакет для каждого ключа: <начало суток (UTC), Unix-время uint64> ->
+	// <количество переходов за сутки, uint64>.
+	dailyClicksBucket = []byte("daily_clicks")
+	// legacyClicksBucket - бакет с полной историей переходов из предыдущих версий: вложенный бакет для каждого
+	// ключа, <порядковый номер> -> storage.Click в формате JSON. При открытии базы переводится в dailyClicksBucket.
+	legacyClicksBucket = []byte("clicks")
+)
+
+// openTimeout - время ожидания блокировки файла базы данных другим процессом.
+const openTimeout = time.Second
+
+type (
+	// DB - реализация интерфейса storage.Storage на базе bbolt.
+	DB struct {
+		db *bolt.DB
+
+		// reaperStop - сигнал завершения воркера reaper.
+		reaperStop chan struct{}
+		// workers позволяет дождаться завершения воркера reaper.
+		workers sync.WaitGroup
+		// closeOnce обеспечивает однократное закрытие хранилища.
+		closeOnce sync.Once
+
+		log logrus.FieldLogger
+	}
+
+	// Option - параметр хранилища, передаваемый конструктору NewDB.
+	Option func(*DB)
+
+	// record - запись хранилища в бакете keysBucket.
+	record struct {
+		SessionID   uuid.UUID `json:"session_id"`
+		OriginalURL string    `json:"original_url"`
+		Deleted     bool      `json:"deleted"`
+		ExpiresAt   time.Time `json:"expires_at"`
+		CreatedAt   time.Time `json:"created_at"`
+		Title       string    `json:"title,omitempty"`
+		// AlwaysPreview - всегда показывать страницу предпросмотра ссылки.
+		AlwaysPreview bool `json:"always_preview,omitempty"`
+	}
+)
+
+// NewDB открывает (или создаёт) файл базы данных и инициализирует бакеты.
+func NewDB(fileName string, opts ...Option) (*DB, error) {
+	if fileName == "" {
+		return nil, errors.New("boltdb: missing file name")
+	}
+	d := &DB{
+		reaperStop: make(chan struct{}),
+		log:        logger.Default(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.log = d.log.WithField(logger.FieldComponent, "boltdb")
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("boltdb: could not open %s: %w", fileName, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{keysBucket, urlsBucket, usersBucket, dailyClicksBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if err := migrateURLs(tx); err != nil {
+			return err
+		}
+		return migrateClicks(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("boltdb: could not create buckets: %w", err)
+	}
+	d.log.WithField("file", fileName).Info("opened database")
+	d.db = db
+	d.workers.Add(1)
+	go d.reaper(d.reaperStop)
+
+	return d, nil
+}
+
+// WithLogger задаёт логгер хранилища.
+func WithLogger(l logrus.FieldLogger) Option {
+	return func(d *DB) {
+		d.log = l
+	}
+}
+
+// Store имплементирует интерфейс storage.Storage.
+func (d *DB) Store(ctx context.Context, id uuid.UUID, rec storage.Record) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, id, rec)
+	})
+}
+
+// urlKey возвращает ключ URL в бакете urlsBucket.
+func urlKey(url string) []byte {
+	sum := sha256.Sum256([]byte(url))
+
+	return sum[:]
+}
+
+// migrateURLs переводит индекс URL из бакета legacyURLsBucket в urlsBucket и удаляет старый бакет.
+func migrateURLs(tx *bolt.Tx) error {
+	legacy := tx.Bucket(legacyURLsBucket)
+	if legacy == nil {
+		return nil
+	}
+	urls := tx.Bucket(urlsBucket)
+	err := legacy.ForEach(func(url, key []byte) error {
+		return urls.Put(urlKey(string(url)), key)
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.DeleteBucket(legacyURLsBucket)
+}
+
+// put сохраняет запись в рамках транзакции tx, проверяя уникальность ключа и URL.
+func put(tx *bolt.Tx, id uuid.UUID, rec storage.Record) error {
+	keys := tx.Bucket(keysBucket)
+	urls := tx.Bucket(urlsBucket)
+	key, url := rec.Key, rec.OriginalURL
+	if keys.Get([]byte(key)) != nil {
+		return fmt.Errorf("boltdb: key %s: %w", key, storage.ErrKeyCollision)
+	}
+	if existingKey := urls.Get(urlKey(url)); existingKey != nil {
+		// просроченная запись, ещё не удалённая reaper, не должна занимать URL
+		holder, ok, err := getRecord(tx, string(existingKey))
+		if err != nil {
+			return err
+		}
+		if !ok || !storage.Expired(holder.ExpiresAt, time.Now()) {
+			return &storage.ErrURLArlreadyExists{
+				Key: string(existingKey),
+				URL: url,
+			}
+		}
+		if err := markDeleted(tx, string(existingKey), holder); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(record{
+		SessionID:     id,
+		OriginalURL:   url,
+		ExpiresAt:     rec.ExpiresAt,
+		CreatedAt:     rec.CreatedAt,
+		Title:         rec.Title,
+		AlwaysPreview: rec.AlwaysPreview,
+	})
+	if err != nil {
+		return err
+	}
+	if err := keys.Put([]byte(key), data); err != nil {
+		return err
+	}
+	if err := urls.Put(urlKey(url), []byte(key)); err != nil {
+		return err
+	}
+	userKeys, err := tx.Bucket(usersBucket).CreateBucketIfNotExists(id[:])
+	if err != nil {
+		return err
+	}
+
+	return userKeys.Put([]byte(key), []byte{})
+}
+
+// getRecord извлекает запись по ключу. Если ключ не найден, возвращается ok == false.
+func getRecord(tx *bolt.Tx, key string) (rec record, ok bool, err error) {
+	data := tx.Bucket(keysBucket).Get([]byte(key))
+	if data == nil {
+		return record{}, false, nil
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return record{}, false, fmt.Errorf("boltdb: corrupted record %s: %w", key, err)
+	}
+
+	return rec, true, nil
+}
+
+// Get имплементирует интерфейс storage.Storage.
+func (d *DB) Get(ctx context.Context, key string) (string, error) {
+	rec, err := d.Lookup(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	return rec.OriginalURL, nil
+}
+
+// Lookup имплементирует интерфейс storage.Storage.
+func (d *DB) Lookup(ctx context.Context, key string) (storage.Record, error) {
+	var result storage.Record
+	err := d.db.View(func(tx *bolt.Tx) error {
+		rec, ok, err := getRecord(tx, key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("boltdb: key %s: %w", key, storage.ErrNotFound)
+		}
+		if rec.Deleted {
+			return storage.ErrDeleted
+		}
+		if storage.Expired(rec.ExpiresAt, time.Now()) {
+			return storage.ErrExpired
+		}
+		result = storage.Record{
+			OriginalURL:   rec.OriginalURL,
+			Key:           key,
+			ExpiresAt:     rec.ExpiresAt,
+			CreatedAt:     rec.CreatedAt,
+			Title:         rec.Title,
+			AlwaysPreview: rec.AlwaysPreview,
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+// GetAll имплементирует интерфейс storage.Storage.
+func (d *DB) GetAll(ctx context.Context, id uuid.UUID) map[string]string {
+	list := make(map[string]string)
+	err := d.db.View(func(tx *bolt.Tx) error {
+		userKeys := tx.Bucket(usersBucket).Bucket(id[:])
+		if userKeys == nil {
+			return nil
+		}
+		return userKeys.ForEach(func(k, _ []byte) error {
+			rec, ok, err := getRecord(tx, string(k))
+			if err != nil {
+				return err
+			}
+			if ok && !rec.Deleted {
+				list[string(k)] = rec.OriginalURL
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		d.log.WithError(err).Error("GetAll: could not read records")
+	}
+
+	return list
+}
+
+// BatchStore имплементирует интерфейс storage.Storage.
+// Все записи сохраняются в одной транзакции, которая откатывается при первой же ошибке.
+func (d *DB) BatchStore(ctx context.Context, id uuid.UUID, records []storage.Record) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		for _, rec := range records {
+			err := put(tx, id, rec)
+			var errURLAlreadyExists *storage.ErrURLArlreadyExists
+			if errors.As(err, &errURLAlreadyExists) {
+				return storage.ErrBatchURLUniqueViolation
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BatchDelete имплементирует интерфейс storage.Storage.
+func (d *DB) BatchDelete(ctx context.Context, id uuid.UUID, keys []string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(usersBucket).Bucket(id[:]) == nil { // у пользователя нет ни одной записи
+			return nil
+		}
+		for _, key := range keys {
+			rec, ok, err := getRecord(tx, key)
+			if err != nil {
+				return err
+			}
+			if !ok || rec.SessionID != id || rec.Deleted {
+				continue
+			}
+			if err := markDeleted(tx, key, rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// markDeleted помечает запись rec с ключом key как удалённую и убирает её из бакетов URL и пользователя.
+// Бакет пользователя, у которого не осталось неудалённых записей, удаляется.
+func markDeleted(tx *bolt.Tx, key string, rec record) error {
+	rec.Deleted = true
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(keysBucket).Put([]byte(key), data); err != nil {
+		return err
+	}
+	urls := tx.Bucket(urlsBucket)
+	if string(urls.Get(urlKey(rec.OriginalURL))) == key {
+		if err := urls.Delete(urlKey(rec.OriginalURL)); err != nil {
+			return err
+		}
+	}
+	users := tx.Bucket(usersBucket)
+	userKeys := users.Bucket(rec.SessionID[:])
+	if userKeys == nil {
+		return nil
+	}
+	if err := userKeys.Delete([]byte(key)); err != nil {
+		return err
+	}
+	if k, _ := userKeys.Cursor().First(); k == nil {
+		return users.DeleteBucket(rec.SessionID[:])
+	}
+
+	return nil
+}
+
+// Stats имплементирует интерфейс storage.Storage.
+func (d *DB) Stats(ctx context.Context) (urls int, users int, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		urls = tx.Bucket(urlsBucket).Stats().KeyN
+		// бакеты пользователей без неудалённых записей удаляются, поэтому достаточно их пересчитать
+		return tx.Bucket(usersBucket).ForEach(func(_, _ []byte) error {
+			users++
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return urls, users, nil
+}
+
+// Close имплементирует интерфейс storage.Storage. Повторные вызовы ничего не делают.
+func (d *DB) Close() {
+	d.closeOnce.Do(func() {
+		// дожидаемся завершения reaper, чтобы он не обратился к закрытой базе.
+		close(d.reaperStop)
+		d.workers.Wait()
+		if err := d.db.Close(); err != nil {
+			d.log.WithError(err).Error("close: could not close database")
+			return
+		}
+		d.log.Info("database closed")
+	})
+}
+
+// Ping имплементирует интерфейс storage.Storage.
+func (d *DB) Ping() error {
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+	if err != nil {
+		return &storage.UnavailableError{Err: err}
+	}
+
+	return nil
+}
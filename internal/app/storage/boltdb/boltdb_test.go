@@ -0,0 +1,240 @@
+package boltdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/storagetest"
+	bolt "go.etcd.io/bbolt"
+)
+
+// TestBolt тестирует основные сценарии работы хранилища: сохранение, получение, пакетное сохранение,
+// мягкое удаление и статистику.
+func TestBolt(t *testing.T) {
+	const fileName = "tmp.bolt"
+	ctx := context.Background()
+	id1, id2 := uuid.New(), uuid.New()
+
+	db, err := NewDB(fileName)
+	require.NoError(t, err)
+	defer func() {
+		db.Close()
+		require.NoError(t, os.Remove(fileName))
+	}()
+
+	t.Run("Store - Get", func(t *testing.T) {
+		require.NoError(t, db.Store(ctx, id1, storage.Record{Key: "key1", OriginalURL: "url1"}))
+		url, err := db.Get(ctx, "key1")
+		assert.NoError(t, err)
+		assert.Equal(t, "url1", url)
+	})
+	t.Run("Store non-unique key", func(t *testing.T) {
+		assert.Error(t, db.Store(ctx, id1, storage.Record{Key: "key1", OriginalURL: "url9999"}))
+	})
+	t.Run("Store non-unique URL", func(t *testing.T) {
+		err := db.Store(ctx, id2, storage.Record{Key: "key2", OriginalURL: "url1"})
+		var errURLAlreadyExists *storage.ErrURLArlreadyExists
+		require.True(t, errors.As(err, &errURLAlreadyExists))
+		assert.Equal(t, "key1", errURLAlreadyExists.Key)
+	})
+	t.Run("Get wrong key", func(t *testing.T) {
+		_, err := db.Get(ctx, "key2")
+		assert.Error(t, err)
+	})
+	t.Run("BatchStore is atomic", func(t *testing.T) {
+		err := db.BatchStore(ctx, id2, []storage.Record{
+			{Key: "key2", OriginalURL: "url2"},
+			{Key: "key3", OriginalURL: "url1"}, // URL уже сохранён
+		})
+		assert.ErrorIs(t, err, storage.ErrBatchURLUniqueViolation)
+		_, err = db.Get(ctx, "key2")
+		assert.Error(t, err)
+
+		require.NoError(t, db.BatchStore(ctx, id2, []storage.Record{
+			{Key: "key2", OriginalURL: "url2"},
+			{Key: "key3", OriginalURL: "url3"},
+		}))
+		assert.Equal(t, map[string]string{"key2": "url2", "key3": "url3"}, db.GetAll(ctx, id2))
+	})
+	t.Run("BatchDelete", func(t *testing.T) {
+		// чужие записи не удаляются
+		require.NoError(t, db.BatchDelete(ctx, id1, []string{"key2"}))
+		assert.Equal(t, map[string]string{"key2": "url2", "key3": "url3"}, db.GetAll(ctx, id2))
+
+		require.NoError(t, db.BatchDelete(ctx, id1, []string{"key1"}))
+		_, err := db.Get(ctx, "key1")
+		assert.ErrorIs(t, err, storage.ErrDeleted)
+		assert.Empty(t, db.GetAll(ctx, id1))
+		// URL удалённой записи можно сохранить заново
+		assert.NoError(t, db.Store(ctx, id2, storage.Record{Key: "key4", OriginalURL: "url1"}))
+	})
+	t.Run("Stats", func(t *testing.T) {
+		urls, users, err := db.Stats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, urls)
+		assert.Equal(t, 1, users)
+	})
+	t.Run("Ping", func(t *testing.T) {
+		assert.NoError(t, db.Ping())
+	})
+}
+
+// TestReaper проверяет, что просроченные записи помечаются как удалённые.
+func TestReaper(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "url1", ExpiresAt: now.Add(time.Minute)}))
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key2", OriginalURL: "url2"}))
+
+	require.NoError(t, db.reapExpired(now.Add(2*time.Minute)))
+	_, err = db.Get(ctx, "key1")
+	assert.ErrorIs(t, err, storage.ErrDeleted)
+	assert.Equal(t, map[string]string{"key2": "url2"}, db.GetAll(ctx, id))
+	assert.NoError(t, db.Store(ctx, id, storage.Record{Key: "key3", OriginalURL: "url1"}))
+
+	// после удаления последней записи пользователь не учитывается в статистике
+	require.NoError(t, db.Store(ctx, uuid.New(), storage.Record{Key: "key4", OriginalURL: "url4", ExpiresAt: now}))
+	require.NoError(t, db.reapExpired(now))
+	urls, users, err := db.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, urls)
+	assert.Equal(t, 1, users)
+}
+
+// TestClose проверяет, что Close дожидается завершения reaper и может вызываться повторно.
+func TestClose(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.bolt"))
+	require.NoError(t, err)
+	db.Close()
+	assert.NotPanics(t, db.Close)
+	assert.Error(t, db.Ping())
+}
+
+// TestClicksMigration проверяет перевод истории переходов из предыдущих версий в счётчики по дням.
+func TestClicksMigration(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+	fileName := filepath.Join(t.TempDir(), "test.bolt")
+	db, err := NewDB(fileName)
+	require.NoError(t, err)
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "url1"}))
+	db.Close()
+
+	day := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	raw, err := bolt.Open(fileName, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, raw.Update(func(tx *bolt.Tx) error {
+		history, err := tx.CreateBucketIfNotExists(legacyClicksBucket)
+		require.NoError(t, err)
+		clicks, err := history.CreateBucket([]byte("key1"))
+		require.NoError(t, err)
+		for i, clickedAt := range []time.Time{day.Add(time.Hour), day.Add(25 * time.Hour), day.Add(2 * time.Hour)} {
+			data, err := json.Marshal(storage.Click{Key: "key1", Time: clickedAt})
+			if err != nil {
+				return err
+			}
+			if err := clicks.Put([]byte{byte(i)}, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.NoError(t, raw.Close())
+
+	db, err = NewDB(fileName)
+	require.NoError(t, err)
+	defer db.Close()
+	stats, err := db.ClickStats(ctx, id, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, storage.ClickStats{
+		Total: 3,
+		Daily: []storage.DailyClicks{{Date: day, Clicks: 2}, {Date: day.Add(24 * time.Hour), Clicks: 1}},
+	}, stats)
+	require.NoError(t, db.db.View(func(tx *bolt.Tx) error {
+		assert.Nil(t, tx.Bucket(legacyClicksBucket), "raw click history must be removed")
+		return nil
+	}))
+}
+
+// TestLongURL проверяет, что URL длиннее максимального размера ключа bbolt сохраняются и остаются уникальными.
+func TestLongURL(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.bolt"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	url := "http://example.com/?q=" + strings.Repeat("a", bolt.MaxKeySize)
+	require.NoError(t, db.Store(ctx, uuid.New(), storage.Record{Key: "key1", OriginalURL: url}))
+	got, err := db.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, url, got)
+
+	err = db.Store(ctx, uuid.New(), storage.Record{Key: "key2", OriginalURL: url})
+	var errURLAlreadyExists *storage.ErrURLArlreadyExists
+	require.ErrorAs(t, err, &errURLAlreadyExists)
+	assert.Equal(t, "key1", errURLAlreadyExists.Key)
+}
+
+// TestURLsMigration проверяет перевод индекса URL из предыдущих версий в индекс по хешу URL.
+func TestURLsMigration(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+	fileName := filepath.Join(t.TempDir(), "test.bolt")
+	db, err := NewDB(fileName)
+	require.NoError(t, err)
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "url1"}))
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key2", OriginalURL: "url2"}))
+	db.Close()
+
+	// возвращаем индекс URL к формату предыдущих версий
+	raw, err := bolt.Open(fileName, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, raw.Update(func(tx *bolt.Tx) error {
+		legacy, err := tx.CreateBucket(legacyURLsBucket)
+		require.NoError(t, err)
+		require.NoError(t, legacy.Put([]byte("url1"), []byte("key1")))
+		require.NoError(t, legacy.Put([]byte("url2"), []byte("key2")))
+		return tx.DeleteBucket(urlsBucket)
+	}))
+	require.NoError(t, raw.Close())
+
+	db, err = NewDB(fileName)
+	require.NoError(t, err)
+	defer db.Close()
+	err = db.Store(ctx, id, storage.Record{Key: "key3", OriginalURL: "url1"})
+	var errURLAlreadyExists *storage.ErrURLArlreadyExists
+	require.ErrorAs(t, err, &errURLAlreadyExists)
+	assert.Equal(t, "key1", errURLAlreadyExists.Key)
+	urls, _, err := db.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, urls)
+	require.NoError(t, db.db.View(func(tx *bolt.Tx) error {
+		assert.Nil(t, tx.Bucket(legacyURLsBucket), "legacy URL index must be removed")
+		return nil
+	}))
+}
+
+// TestConformance прогоняет общий набор тестов хранилища.
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		db, err := NewDB(filepath.Join(t.TempDir(), "test.bolt"))
+		require.NoError(t, err)
+		t.Cleanup(db.Close)
+		return db
+	})
+}
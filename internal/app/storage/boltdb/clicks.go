@@ -0,0 +1,106 @@
+package boltdb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// StoreClicks имплементирует интерфейс storage.Storage. Переходы хранятся в виде счётчиков по дням (UTC).
+func (d *DB) StoreClicks(ctx context.Context, clicks []storage.Click) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(keysBucket)
+		for _, c := range clicks {
+			if keys.Get([]byte(c.Key)) == nil {
+				continue
+			}
+			if err := addClicks(tx, c.Key, c.Time, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addClicks увеличивает на n счётчик переходов по ключу key за сутки, содержащие момент t.
+func addClicks(tx *bolt.Tx, key string, t time.Time, n uint64) error {
+	daily, err := tx.Bucket(dailyClicksBucket).CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		return err
+	}
+	day := make([]byte, 8)
+	binary.BigEndian.PutUint64(day, uint64(t.UTC().Truncate(24*time.Hour).Unix()))
+	if v := daily.Get(day); v != nil {
+		n += binary.BigEndian.Uint64(v)
+	}
+	count := make([]byte, 8)
+	binary.BigEndian.PutUint64(count, n)
+
+	return daily.Put(day, count)
+}
+
+// migrateClicks переводит переходы, сохранённые в бакете legacyClicksBucket в виде полной истории,
+// в счётчики по дням и удаляет бакет истории.
+func migrateClicks(tx *bolt.Tx) error {
+	history := tx.Bucket(legacyClicksBucket)
+	if history == nil {
+		return nil
+	}
+	err := history.ForEach(func(key, _ []byte) error {
+		clicks := history.Bucket(key)
+		if clicks == nil {
+			return nil
+		}
+		return clicks.ForEach(func(_, v []byte) error {
+			var c storage.Click
+			if err := json.Unmarshal(v, &c); err != nil {
+				return fmt.Errorf("boltdb: corrupted click of %s: %w", key, err)
+			}
+			return addClicks(tx, string(key), c.Time, 1)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.DeleteBucket(legacyClicksBucket)
+}
+
+// ClickStats имплементирует интерфейс storage.Storage.
+func (d *DB) ClickStats(ctx context.Context, id uuid.UUID, key string) (storage.ClickStats, error) {
+	stats := storage.ClickStats{Daily: make([]storage.DailyClicks, 0)}
+	err := d.db.View(func(tx *bolt.Tx) error {
+		rec, ok, err := getRecord(tx, key)
+		if err != nil {
+			return err
+		}
+		if !ok || rec.SessionID != id {
+			return fmt.Errorf("boltdb: key %s: %w", key, storage.ErrNotFound)
+		}
+		daily := tx.Bucket(dailyClicksBucket).Bucket([]byte(key))
+		if daily == nil {
+			return nil
+		}
+		// ключи бакета - начало суток в формате big-endian, поэтому ForEach обходит их по возрастанию даты
+		return daily.ForEach(func(k, v []byte) error {
+			clicks := int(binary.BigEndian.Uint64(v))
+			stats.Daily = append(stats.Daily, storage.DailyClicks{
+				Date:   time.Unix(int64(binary.BigEndian.Uint64(k)), 0).UTC(),
+				Clicks: clicks,
+			})
+			stats.Total += clicks
+			return nil
+		})
+	})
+	if err != nil {
+		return storage.ClickStats{}, err
+	}
+
+	return stats, nil
+}
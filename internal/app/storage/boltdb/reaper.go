@@ -0,0 +1,74 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// reaper - сервис, с периодичностью storage.ReapInterval помечающий просроченные записи как удалённые.
+// Сервис работает в своей горутине и завершается по сигналу из канала stop.
+func (d *DB) reaper(stop <-chan struct{}) {
+	defer d.workers.Done()
+	ticker := time.NewTicker(storage.ReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := d.reapExpired(now); err != nil {
+				d.log.WithError(err).Error("reaper: could not delete expired records")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reapExpired помечает как удалённые все записи, срок действия которых истёк к моменту now.
+// Просроченные ключи ищутся в транзакции чтения, которая не блокирует запись, поэтому блокировка
+// записи bbolt удерживается только на время пометки найденных ключей.
+func (d *DB) reapExpired(now time.Time) error {
+	var expired []string
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(keysBucket).ForEach(func(k, v []byte) error {
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("boltdb: corrupted record %s: %w", k, err)
+			}
+			if !rec.Deleted && storage.Expired(rec.ExpiresAt, now) {
+				expired = append(expired, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil || len(expired) == 0 {
+		return err
+	}
+
+	n := 0
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		for _, key := range expired {
+			// запись могла измениться после транзакции чтения
+			rec, ok, err := getRecord(tx, key)
+			if err != nil {
+				return err
+			}
+			if !ok || rec.Deleted || !storage.Expired(rec.ExpiresAt, now) {
+				continue
+			}
+			if err := markDeleted(tx, key, rec); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err == nil && n > 0 {
+		d.log.Infof("reaper: %d expired records deleted", n)
+	}
+
+	return err
+}
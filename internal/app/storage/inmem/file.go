@@ -1,54 +1,58 @@
 package inmem
 
 import (
-	"encoding/gob"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"io/fs"
+
+	"github.com/sirupsen/logrus"
 )
 
-// initRepo считывает и декодирует данные хранилища из файла в формате gob.
+// initRepo считывает и декодирует данные хранилища из файла снимка.
+// Если снимок повреждён, данные восстанавливаются из предыдущего поколения снимка, и возвращается
+// fromPrev = true: в этом случае поверх снимка нужно проиграть и предыдущий сегмент журнала.
 // Если файл не найден - он создается функцией createRepoFile.
-func initRepo(fileName string) ([]row, error) {
-	if _, err := os.Stat(fileName); err != nil {
-		if os.IsNotExist(err) {
-			return createRepoFile(fileName)
-		}
+func initRepo(fileName string, log logrus.FieldLogger) (repo []row, fromPrev bool, err error) {
+	log = log.WithField("file", fileName)
+	repo, err = readSnapshot(fileName)
+	if err == nil {
+		log.Infof("read %d records from the snapshot", len(repo))
 
-		return nil, fmt.Errorf("initRepo: %v", err)
+		return repo, false, nil
 	}
-	file, err := os.OpenFile(fileName, os.O_RDONLY, 0777)
-	if err != nil {
-		return nil, fmt.Errorf("initRepo: %v", err)
+	if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errBadSnapshot) {
+		return nil, false, fmt.Errorf("initRepo: %v", err)
 	}
-	defer file.Close()
-
-	dec := gob.NewDecoder(file)
-	repo := make([]row, 0)
-	if err = dec.Decode(&repo); err != nil {
-		return nil, fmt.Errorf("initRepo: %v", err)
+	if errors.Is(err, errBadSnapshot) {
+		log.WithError(err).Warn("snapshot is corrupted")
 	}
-	log.Printf("[INF] readRepo: successfully read repo from file %s", fileName)
 
-	return repo, nil
+	// основной файл отсутствует (сбой между переименованиями) или повреждён - пробуем предыдущее поколение
+	prevRepo, prevErr := readSnapshot(fileName + prevSuffix)
+	switch {
+	case prevErr == nil:
+		log.Warnf("restored repo from the previous snapshot %s", fileName+prevSuffix)
+
+		return prevRepo, true, nil
+	case errors.Is(prevErr, fs.ErrNotExist) && errors.Is(err, fs.ErrNotExist):
+		repo, err = createRepoFile(fileName, log)
+
+		return repo, false, err
+	case errors.Is(prevErr, fs.ErrNotExist):
+		return nil, false, fmt.Errorf("initRepo: %v", err)
+	default:
+		return nil, false, fmt.Errorf("initRepo: %v; previous snapshot: %v", err, prevErr)
+	}
 }
 
-// createRepoFile создает файл и записывает в него сериализованную пустую map (иначе автотест
+// createRepoFile создает файл и записывает в него сериализованный пустой снимок (иначе автотест
 // ругается на пустой файл).
-func createRepoFile(fileName string) ([]row, error) {
-	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0777)
-	if err != nil {
-
-		return nil, fmt.Errorf("createRepoFile: %v", err)
-	}
-	defer file.Close()
-
-	enc := gob.NewEncoder(file)
+func createRepoFile(fileName string, log logrus.FieldLogger) ([]row, error) {
 	repo := make([]row, 0)
-	if err = enc.Encode(&repo); err != nil {
+	if err := writeSnapshot(fileName, repo); err != nil {
 		return nil, fmt.Errorf("createRepoFile: %v", err)
 	}
-	log.Printf("[INF] createRepoFile: successfully created repo file %s", fileName)
+	log.Info("created an empty snapshot")
 
 	return repo, nil
 }
@@ -1,26 +1,26 @@
 package inmem
 
 import (
-	"encoding/gob"
-	"log"
-	"os"
 	"time"
+
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/metrics"
 )
 
 // gobber - сервис, сохраняющий данные in-memory хранилища в файл в формате gob с заданной периодичностью.
-// Сервис работает в своей горутине и завершается по сигналу из канала gobberStop.
-func (db *DB) gobber() {
-	log.Println("[INF] gobber started!")
+// Сервис работает в своей горутине и завершается по сигналу из канала stop.
+func (db *DB) gobber(stop <-chan struct{}) {
+	defer db.workers.Done()
+	db.log.Debug("gobber started")
 	ticker := time.NewTicker(db.flushInterval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
 			if err := db.flush(); err != nil {
-				log.Printf("gobber: %v", err)
+				db.log.WithError(err).Error("gobber: could not save the snapshot")
 			}
-		case <-db.gobberStop:
-			log.Println("[INF] gobber stopped")
+		case <-stop:
+			db.log.Debug("gobber stopped")
 
 			return
 		}
@@ -28,6 +28,7 @@ func (db *DB) gobber() {
 }
 
 // flush проверяет флаг isChanged и при необходимости сохраняет данные хранилища в файл.
+// После успешного сохранения снимка начинается новый сегмент журнала изменений.
 func (db *DB) flush() error {
 	db.Lock()
 	defer db.Unlock()
@@ -35,19 +36,23 @@ func (db *DB) flush() error {
 
 		return nil
 	}
-	file, err := os.OpenFile(db.fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777) // переписываем весь файл
-	if err != nil {
+	defer func(start time.Time) {
+		metrics.SnapshotDuration.Observe(time.Since(start).Seconds())
+	}(time.Now())
+	if err := writeSnapshot(db.fileName, db.snapshot()); err != nil {
+		db.flushErr = err
 
 		return err
 	}
-	defer file.Close()
-	enc := gob.NewEncoder(file)
-	if err = enc.Encode(&db.repo); err != nil {
+	if err := db.journal.rotate(); err != nil {
+		db.flushErr = err
 
 		return err
 	}
 	db.isChanged = false
-	log.Printf("[INF] gobber: saved changes to the file %s", db.fileName)
+	db.lastFlush = time.Now()
+	db.flushErr = nil
+	db.log.WithField("file", db.fileName).Debug("gobber: saved changes to the snapshot")
 
 	return nil
 }
@@ -1,16 +1,21 @@
 // Пакет inmem представляет собой реализацию хранилища ключей в виде потокобезопасной in-memory структуры.
-// Данные хранилища переиодически сохраняются в файл сервисом gobber.
+// Каждое изменение сначала записывается в журнал (write-ahead log), а сервис gobber периодически
+// сохраняет данные хранилища в файл и очищает журнал.
 package inmem
 
 import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
 )
 
@@ -22,14 +27,35 @@ type (
 		OriginalURL string
 		Key         string
 		Deleted     bool
+		ExpiresAt   time.Time
+		CreatedAt   time.Time
+		Title       string
+		// AlwaysPreview - всегда показывать страницу предпросмотра ссылки.
+		AlwaysPreview bool
+		// ClickCount - общее количество переходов по ссылке.
+		ClickCount int
+		// DailyClicks - количество переходов по дням (UTC) в порядке возрастания даты. Хранятся только
+		// агрегаты, поэтому размер хранилища и снимка не растёт с каждым переходом.
+		DailyClicks []storage.DailyClicks
+		// Clicks - полная история переходов из снимков, сохранённых до появления агрегатов.
+		// При загрузке снимка переводится в ClickCount и DailyClicks.
+		Clicks []storage.Click
 	}
 
 	// DB - реализация интерфейса storage.Storage c thread-safe inmemory хранилищем (структура с RW Mutex).
+	// Все операции выполняются за константное время благодаря индексам по ключу, URL и пользователю.
 	DB struct {
 		sync.RWMutex
 
-		// repo - in-memory хранилище
-		repo []row
+		// rows - in-memory хранилище записей, проиндексированное по ключу (включая удалённые записи).
+		rows map[string]*row
+
+		// urls - индекс URL -> ключ. Содержит только неудалённые записи.
+		urls map[string]string
+
+		// users - индекс пользователь -> множество его ключей. Содержит только неудалённые записи,
+		// пользователи без единой неудалённой записи из индекса удаляются.
+		users map[uuid.UUID]map[string]struct{}
 
 		// fileName - имя файла, который хранит данные надиске в формате gob. При старте сервиса in-memory
 		// хранилище загружается из файла и по ходу работы периодически переписывает файл, если были изменения.
@@ -43,44 +69,219 @@ type (
 		// файл с данными необходимо обновить.
 		isChanged bool
 
+		// journal - журнал изменений, сделанных после сохранения последнего снимка хранилища.
+		journal *journal
+
+		// lastFlush - время последнего успешного сохранения снимка, flushErr - ошибка последней попытки
+		// сохранения снимка (nil, если она завершилась успешно).
+		lastFlush time.Time
+		flushErr  error
+
+		// gobberStop - сигнал завершения фоновых воркеров gobber и reaper.
 		gobberStop chan struct{}
+		// workers позволяет дождаться завершения воркеров gobber и reaper.
+		workers sync.WaitGroup
+
+		log logrus.FieldLogger
+	}
+
+	// Option - параметр хранилища, передаваемый конструктору NewDB.
+	Option func(*options)
+
+	options struct {
+		log logrus.FieldLogger
 	}
 )
 
+// WithLogger задаёт логгер хранилища.
+func WithLogger(l logrus.FieldLogger) Option {
+	return func(o *options) {
+		o.log = l
+	}
+}
+
 // New инициализирует структуру in-memory хранилища.
-func NewDB(fileName string, interval time.Duration) (*DB, error) {
+func NewDB(fileName string, interval time.Duration, opts ...Option) (*DB, error) {
 	if err := validate(fileName, interval); err != nil {
 		return nil, err
 	}
-	repo, err := initRepo(fileName)
+	o := options{log: logger.Default()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	log := o.log.WithField(logger.FieldComponent, "inmem")
+	repo, fromPrev, err := initRepo(fileName, log)
 	if err != nil {
 		return nil, err
 	}
 
-	db := &DB{
-		repo:          repo,
-		fileName:      fileName,
-		flushInterval: interval,
-		isChanged:     false,
-		gobberStop:    make(chan struct{}),
+	db := newIndexedDB(repo)
+	db.log = log
+	db.fileName = fileName
+	db.flushInterval = interval
+	db.gobberStop = make(chan struct{})
+
+	if fromPrev {
+		// изменения, сделанные между предыдущим и повреждённым снимками, хранятся в предыдущем сегменте журнала
+		prevEntries, err := readJournalFile(fileName + journalSuffix + prevSuffix)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range prevEntries {
+			db.apply(e)
+		}
+		log.Infof("journal: replayed %d records of the previous segment", len(prevEntries))
 	}
+	j, entries, err := openJournal(fileName+journalSuffix, log)
+	if err != nil {
+		return nil, err
+	}
+	db.journal = j
+	for _, e := range entries {
+		db.apply(e)
+	}
+	db.isChanged = fromPrev || len(entries) > 0 // проигранные из журнала изменения попадут в следующий снимок
 
-	go db.gobber()
+	db.workers.Add(2)
+	go db.gobber(db.gobberStop)
+	go db.reaper(db.gobberStop)
 
 	return db, nil
 }
 
-// Close закрывает сервис in-memory хранилища и останавливает воркер gobber.
-func (db *DB) Close() {
-	db.flush()
+// newIndexedDB создаёт хранилище из переданных записей и строит по ним индексы.
+// Если ключ встречается несколько раз, в хранилище остаётся последняя запись.
+func newIndexedDB(repo []row) *DB {
+	db := &DB{
+		rows:  make(map[string]*row, len(repo)),
+		urls:  make(map[string]string, len(repo)),
+		users: make(map[uuid.UUID]map[string]struct{}),
+		log:   logger.Default().WithField(logger.FieldComponent, "inmem"),
+	}
+	for _, r := range repo {
+		r := r
+		for _, c := range r.Clicks {
+			r.addClick(c.Time)
+		}
+		r.Clicks = nil
+		if old, ok := db.rows[r.Key]; ok && !old.Deleted {
+			db.unindex(old)
+		}
+		db.insert(&r)
+	}
 
-	db.Lock()
-	defer db.Unlock()
+	return db
+}
+
+// insert добавляет запись в хранилище и, если она не удалена, в индексы URL и пользователя.
+// Вызывающая сторона должна удерживать блокировку на запись.
+func (db *DB) insert(r *row) {
+	db.rows[r.Key] = r
+	if r.Deleted {
+		return
+	}
+	db.urls[r.OriginalURL] = r.Key
+	keys, ok := db.users[r.SessionID]
+	if !ok {
+		keys = make(map[string]struct{})
+		db.users[r.SessionID] = keys
+	}
+	keys[r.Key] = struct{}{}
+}
+
+// unindex удаляет запись из индексов URL и пользователя (сама запись остаётся в хранилище).
+// Вызывающая сторона должна удерживать блокировку на запись.
+func (db *DB) unindex(r *row) {
+	if key, ok := db.urls[r.OriginalURL]; ok && key == r.Key {
+		delete(db.urls, r.OriginalURL)
+	}
+	if keys, ok := db.users[r.SessionID]; ok {
+		delete(keys, r.Key)
+		if len(keys) == 0 {
+			delete(db.users, r.SessionID)
+		}
+	}
+}
+
+// apply применяет к хранилищу запись журнала. Повторное применение записи не меняет
+// состояние хранилища, поэтому журнал можно проигрывать поверх снимка, уже содержащего часть изменений.
+// Вызывающая сторона должна удерживать блокировку на запись.
+func (db *DB) apply(e walEntry) {
+	switch e.Op {
+	case opStore:
+		for _, r := range e.Rows {
+			r := r
+			if _, ok := db.rows[r.Key]; ok {
+				continue
+			}
+			db.insert(&r)
+		}
+	case opBatchDelete:
+		for _, key := range e.Keys {
+			if r, ok := db.rows[key]; ok && r.SessionID == e.SessionID && !r.Deleted {
+				db.unindex(r)
+				r.Deleted = true
+			}
+		}
+	case opExpire:
+		for _, key := range e.Keys {
+			if r, ok := db.rows[key]; ok && !r.Deleted {
+				db.unindex(r)
+				r.Deleted = true
+			}
+		}
+	case opClicks:
+		for i, c := range e.Clicks {
+			if r, ok := db.rows[c.Key]; ok && r.ClickCount == e.Positions[i] {
+				r.addClick(c.Time)
+			}
+		}
+	}
+}
+
+// snapshot возвращает все записи хранилища в виде слайса для сохранения в файл.
+// Вызывающая сторона должна удерживать блокировку.
+func (db *DB) snapshot() []row {
+	repo := make([]row, 0, len(db.rows))
+	for _, r := range db.rows {
+		repo = append(repo, *r)
+	}
+
+	return repo
+}
 
+// Close закрывает сервис in-memory хранилища и останавливает воркеры gobber и reaper.
+// Если все изменения успешно сохранены в файл, журнал удаляется.
+func (db *DB) Close() {
+	// останавливаем воркеры до сохранения последнего снимка, чтобы gobber не работал с закрытым журналом.
+	db.Lock()
 	if db.gobberStop != nil {
 		close(db.gobberStop)
 	}
 	db.gobberStop = nil
+	db.Unlock()
+	db.workers.Wait()
+
+	flushErr := db.flush()
+	if flushErr != nil {
+		db.log.WithError(flushErr).Error("close: could not save the snapshot")
+	}
+
+	db.Lock()
+	defer db.Unlock()
+
+	if db.journal == nil {
+		return
+	}
+	if err := db.journal.close(); err != nil {
+		db.log.WithError(err).Error("close: could not close the journal")
+	}
+	db.journal = nil
+	if flushErr == nil {
+		if err := os.Remove(db.fileName + journalSuffix); err != nil {
+			db.log.WithError(err).Error("close: could not remove the journal")
+		}
+	}
 }
 
 func validate(filename string, flushinterval time.Duration) error {
@@ -95,79 +296,89 @@ func validate(filename string, flushinterval time.Duration) error {
 }
 
 // Store сохраняет в репозитории пару ключ:url.
-// если ключ уже используется, выдается ошибка.
-func (db *DB) Store(ctx context.Context, id uuid.UUID, key, url string) error {
-	if db.hasKey(ctx, key) {
-		return fmt.Errorf("DB: the key %s already in use", key)
+// если ключ уже используется, выдается ошибка storage.ErrKeyCollision.
+func (db *DB) Store(ctx context.Context, id uuid.UUID, rec storage.Record) error {
+	db.Lock()
+	defer db.Unlock()
+
+	// проверки и вставка выполняются под одной блокировкой, поэтому параллельные вызовы
+	// не могут сохранить один и тот же ключ или URL дважды.
+	if _, ok := db.rows[rec.Key]; ok {
+		return fmt.Errorf("DB: key %s: %w", rec.Key, storage.ErrKeyCollision)
 	}
-	if exitingKey, ok := db.hasURL(url); ok {
+	if err := db.expireHolders([]string{rec.OriginalURL}, time.Now()); err != nil {
+		return err
+	}
+	if exitingKey, ok := db.urls[rec.OriginalURL]; ok {
 		return &storage.ErrURLArlreadyExists{
 			Key: exitingKey,
-			URL: url,
+			URL: rec.OriginalURL,
 		}
 	}
-
-	db.Lock()
-	defer db.Unlock()
-	db.repo = append(db.repo, row{
-		SessionID:   id,
-		OriginalURL: url,
-		Key:         key,
-	})
+	r := row{
+		SessionID:     id,
+		OriginalURL:   rec.OriginalURL,
+		Key:           rec.Key,
+		ExpiresAt:     rec.ExpiresAt,
+		CreatedAt:     rec.CreatedAt,
+		Title:         rec.Title,
+		AlwaysPreview: rec.AlwaysPreview,
+	}
+	if err := db.journal.append(walEntry{Op: opStore, SessionID: id, Rows: []row{r}}); err != nil {
+		return err
+	}
+	db.insert(&r)
 	db.isChanged = true
 
 	return nil
 }
 
-// hasKey проверяет наличие в базе записи с ключом key.
-func (db *DB) hasKey(ctx context.Context, key string) bool {
-	_, err := db.Get(ctx, key)
-	return err == nil
-}
-
-// hasUrl проверяет в базе записи с переданным url и в случае успеха возвращает ключ.
-func (db *DB) hasURL(url string) (key string, ok bool) {
-	db.RLock()
-	defer db.RUnlock()
-
-	for _, rec := range db.repo {
-		if rec.OriginalURL == url && !rec.Deleted {
-			return rec.Key, true
-		}
+// Get извлекает из хранилища длинный url по ключу.
+// Если ключа в базе нет, возвращается ошибка storage.ErrNotFound.
+func (db *DB) Get(ctx context.Context, key string) (string, error) {
+	rec, err := db.Lookup(ctx, key)
+	if err != nil {
+		return "", err
 	}
 
-	return "", false
+	return rec.OriginalURL, nil
 }
 
-// Get извлекает из хранилища длинный url по ключу.
-// Если ключа в базе нет, возвращается ошибка.
-func (db *DB) Get(ctx context.Context, key string) (string, error) {
+// Lookup - реализация метода интерфейса storage.Storage.
+func (db *DB) Lookup(ctx context.Context, key string) (storage.Record, error) {
 	db.RLock()
 	defer db.RUnlock()
 
-	for _, r := range db.repo {
-		if r.Key == key {
-			if r.Deleted {
-				return "", storage.ErrDeleted
-			}
-			return r.OriginalURL, nil
-		}
+	r, ok := db.rows[key]
+	if !ok {
+		return storage.Record{}, fmt.Errorf("DB: key %s: %w", key, storage.ErrNotFound)
+	}
+	if r.Deleted {
+		return storage.Record{}, storage.ErrDeleted
+	}
+	if storage.Expired(r.ExpiresAt, time.Now()) {
+		return storage.Record{}, storage.ErrExpired
 	}
 
-	return "", fmt.Errorf("DB: key %s not found", key)
+	return storage.Record{
+		OriginalURL:   r.OriginalURL,
+		Key:           r.Key,
+		ExpiresAt:     r.ExpiresAt,
+		CreatedAt:     r.CreatedAt,
+		Title:         r.Title,
+		AlwaysPreview: r.AlwaysPreview,
+	}, nil
 }
 
 // GetAll является реализацией метода GetAll интерфейса storage.Storage.
 func (db *DB) GetAll(ctx context.Context, id uuid.UUID) map[string]string {
-	list := make(map[string]string)
-
 	db.RLock()
 	defer db.RUnlock()
 
-	for _, r := range db.repo {
-		if r.SessionID == id && !r.Deleted {
-			list[r.Key] = r.OriginalURL
-		}
+	keys := db.users[id]
+	list := make(map[string]string, len(keys))
+	for key := range keys {
+		list[key] = db.rows[key].OriginalURL
 	}
 	return list
 }
@@ -177,25 +388,73 @@ func (db *DB) BatchStore(ctx context.Context, id uuid.UUID, records []storage.Re
 	db.Lock()
 	defer db.Unlock()
 
+	urls := make([]string, 0, len(records))
+	for _, rec := range records {
+		urls = append(urls, rec.OriginalURL)
+	}
+	if err := db.expireHolders(urls, time.Now()); err != nil {
+		return err
+	}
 	// В случае обнаружения совпадений отменяем всю транзакцию
-	tmpRepo := make([]row, 0, len(records))
+	batchKeys := make(map[string]struct{}, len(records))
+	batchURLs := make(map[string]struct{}, len(records))
 	for _, rec := range records {
-		for _, r := range db.repo {
-			if r.Key == rec.Key {
-				return fmt.Errorf("DB: key %s already defined", rec.Key)
-			}
-			if r.OriginalURL == rec.OriginalURL {
-				return storage.ErrBatchURLUniqueViolation
-			}
+		if _, ok := db.rows[rec.Key]; ok {
+			return fmt.Errorf("DB: key %s: %w", rec.Key, storage.ErrKeyCollision)
 		}
-		tmpRepo = append(tmpRepo, row{
-			SessionID:   id,
-			OriginalURL: rec.OriginalURL,
-			Key:         rec.Key,
-		})
+		if _, ok := batchKeys[rec.Key]; ok {
+			return fmt.Errorf("DB: key %s: %w", rec.Key, storage.ErrKeyCollision)
+		}
+		if _, ok := db.urls[rec.OriginalURL]; ok {
+			return storage.ErrBatchURLUniqueViolation
+		}
+		if _, ok := batchURLs[rec.OriginalURL]; ok {
+			return storage.ErrBatchURLUniqueViolation
+		}
+		batchKeys[rec.Key] = struct{}{}
+		batchURLs[rec.OriginalURL] = struct{}{}
 	}
 	// делаем "коммит транзакции"
-	db.repo = append(db.repo, tmpRepo...)
+	rows := make([]row, 0, len(records))
+	for _, rec := range records {
+		rows = append(rows, row{
+			SessionID:     id,
+			OriginalURL:   rec.OriginalURL,
+			Key:           rec.Key,
+			ExpiresAt:     rec.ExpiresAt,
+			CreatedAt:     rec.CreatedAt,
+			Title:         rec.Title,
+			AlwaysPreview: rec.AlwaysPreview,
+		})
+	}
+	e := walEntry{Op: opStore, SessionID: id, Rows: rows}
+	if err := db.journal.append(e); err != nil {
+		return err
+	}
+	db.apply(e)
+	db.isChanged = true
+
+	return nil
+}
+
+// expireHolders помечает как удалённые просроченные записи, которые владеют URL из urls, но ещё не удалены
+// reaper, чтобы эти URL можно было сохранить заново.
+// Вызывающая сторона должна удерживать блокировку на запись.
+func (db *DB) expireHolders(urls []string, now time.Time) error {
+	var keys []string
+	for _, url := range urls {
+		if key, ok := db.urls[url]; ok && storage.Expired(db.rows[key].ExpiresAt, now) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	e := walEntry{Op: opExpire, Keys: keys}
+	if err := db.journal.append(e); err != nil {
+		return err
+	}
+	db.apply(e)
 	db.isChanged = true
 
 	return nil
@@ -206,33 +465,120 @@ func (db *DB) BatchDelete(ctx context.Context, id uuid.UUID, keys []string) erro
 	db.Lock()
 	defer db.Unlock()
 
+	// в журнал попадают только ключи, которые действительно будут удалены
+	toDelete := make([]string, 0, len(keys))
 	for _, key := range keys {
-		for i, r := range db.repo {
-			if r.Key == key && r.SessionID == id {
-				db.repo[i].Deleted = true
-				db.isChanged = true
-			}
+		if r, ok := db.rows[key]; ok && r.SessionID == id && !r.Deleted {
+			toDelete = append(toDelete, key)
 		}
 	}
+	if len(toDelete) == 0 {
+		return nil
+	}
+	e := walEntry{Op: opBatchDelete, SessionID: id, Keys: toDelete}
+	if err := db.journal.append(e); err != nil {
+		return err
+	}
+	db.apply(e)
+	db.isChanged = true
 
 	return nil
 }
 
 // Stats - реализация метода интерфейса storage.Storage.
 func (db *DB) Stats(ctx context.Context) (urls int, users int, err error) {
-	urls = 0
-	userMap := make(map[uuid.UUID]struct{})
-	for _, row := range db.repo {
-		if row.Deleted { // не учитываем удаленные записи
+	db.RLock()
+	defer db.RUnlock()
+
+	// индексы содержат только неудалённые записи
+	return len(db.urls), len(db.users), nil
+}
+
+// Ping проверяет, что хранилище не закрыто и последнее сохранение снимка в файл завершилось успешно.
+func (db *DB) Ping() error {
+	db.RLock()
+	defer db.RUnlock()
+	if db.journal == nil {
+		return &storage.UnavailableError{Err: errors.New("storage is closed")}
+	}
+	if db.flushErr != nil {
+		return &storage.UnavailableError{Err: fmt.Errorf("could not save the snapshot: %w", db.flushErr)}
+	}
+
+	return nil
+}
+
+// LastFlush возвращает время последнего успешного сохранения снимка хранилища в файл (нулевое, если
+// снимок ещё не сохранялся) и ошибку последней попытки сохранения.
+func (db *DB) LastFlush() (time.Time, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	return db.lastFlush, db.flushErr
+}
+
+// StoreClicks - реализация метода интерфейса storage.Storage.
+func (db *DB) StoreClicks(ctx context.Context, clicks []storage.Click) error {
+	db.Lock()
+	defer db.Unlock()
+
+	e := walEntry{Op: opClicks}
+	next := make(map[string]int) // следующий порядковый номер перехода для каждого ключа
+	for _, c := range clicks {
+		r, ok := db.rows[c.Key]
+		if !ok {
 			continue
 		}
-		urls++
-		userMap[row.SessionID] = struct{}{}
+		pos, ok := next[c.Key]
+		if !ok {
+			pos = r.ClickCount
+		}
+		next[c.Key] = pos + 1
+		e.Clicks = append(e.Clicks, c)
+		e.Positions = append(e.Positions, pos)
+	}
+	if len(e.Clicks) == 0 {
+		return nil
+	}
+	if err := db.journal.append(e); err != nil {
+		return err
 	}
+	db.apply(e)
+	db.isChanged = true
 
-	return urls, len(userMap), nil
+	return nil
 }
 
-func (db *DB) Ping() error {
-	return nil
+// ClickStats - реализация метода интерфейса storage.Storage.
+func (db *DB) ClickStats(ctx context.Context, id uuid.UUID, key string) (storage.ClickStats, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	r, ok := db.rows[key]
+	if !ok || r.SessionID != id {
+		return storage.ClickStats{}, fmt.Errorf("DB: key %s: %w", key, storage.ErrNotFound)
+	}
+
+	stats := storage.ClickStats{
+		Total: r.ClickCount,
+		Daily: make([]storage.DailyClicks, len(r.DailyClicks)),
+	}
+	copy(stats.Daily, r.DailyClicks)
+
+	return stats, nil
+}
+
+// addClick учитывает переход по ссылке в момент t. Вызывающая сторона должна удерживать блокировку на запись.
+func (r *row) addClick(t time.Time) {
+	day := t.UTC().Truncate(24 * time.Hour)
+	r.ClickCount++
+	// переходы поступают почти в хронологическом порядке, поэтому обычно увеличивается последний элемент
+	i := sort.Search(len(r.DailyClicks), func(i int) bool { return !r.DailyClicks[i].Date.Before(day) })
+	if i < len(r.DailyClicks) && r.DailyClicks[i].Date.Equal(day) {
+		r.DailyClicks[i].Clicks++
+		return
+	}
+	r.DailyClicks = append(r.DailyClicks, storage.DailyClicks{})
+	copy(r.DailyClicks[i+1:], r.DailyClicks[i:])
+	r.DailyClicks[i] = storage.DailyClicks{Date: day, Clicks: 1}
 }
@@ -2,24 +2,28 @@ package inmem
 
 import (
 	"context"
+	"encoding/gob"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/storagetest"
 )
 
 // TestGet тестирует функцию Get с использованием фейкового хранилища.
 func TestGet(t *testing.T) {
-	db := DB{
-		repo: []row{
-			{Key: "key1", OriginalURL: "url1"},
-			{Key: "key2", OriginalURL: "url2"},
-			{Key: "", OriginalURL: "url"},
-			{Key: "key", OriginalURL: ""},
-		},
-	}
+	db := newIndexedDB([]row{
+		{Key: "key1", OriginalURL: "url1"},
+		{Key: "key2", OriginalURL: "url2"},
+		{Key: "", OriginalURL: "url"},
+		{Key: "key", OriginalURL: ""},
+	})
 	tt := []struct {
 		name    string
 		key     string
@@ -123,13 +127,15 @@ func TestInmem(t *testing.T) {
 	defer func() {
 		db.Close()
 		require.NoError(t, os.Remove("tmp.db"))
+		require.NoError(t, os.Remove("tmp.db"+prevSuffix))
+		require.NoError(t, os.Remove("tmp.db"+journalSuffix+prevSuffix))
 	}()
 	ctx := context.Background()
 
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
 			if tc.action == "store" || tc.action == "both" {
-				if err := db.Store(ctx, uuid.Nil, tc.args.key, tc.args.url); (err != nil) != tc.wantErrStore {
+				if err := db.Store(ctx, uuid.Nil, storage.Record{Key: tc.args.key, OriginalURL: tc.args.url}); (err != nil) != tc.wantErrStore {
 					t.Errorf("DB.Store() error = %v, wantErr %v", err, tc.wantErrStore)
 				}
 			}
@@ -145,3 +151,357 @@ func TestInmem(t *testing.T) {
 		})
 	}
 }
+
+// TestIndexesOnDelete проверяет согласованность индексов после мягкого удаления записей.
+func TestIndexesOnDelete(t *testing.T) {
+	ctx := context.Background()
+	id1, id2 := uuid.New(), uuid.New()
+	db := newIndexedDB(nil)
+
+	require.NoError(t, db.Store(ctx, id1, storage.Record{Key: "key1", OriginalURL: "url1"}))
+	require.NoError(t, db.Store(ctx, id1, storage.Record{Key: "key2", OriginalURL: "url2"}))
+	require.NoError(t, db.Store(ctx, id2, storage.Record{Key: "key3", OriginalURL: "url3"}))
+
+	// попытка удалить чужую запись ничего не меняет
+	require.NoError(t, db.BatchDelete(ctx, id2, []string{"key1"}))
+	require.Equal(t, map[string]string{"key1": "url1", "key2": "url2"}, db.GetAll(ctx, id1))
+
+	require.NoError(t, db.BatchDelete(ctx, id1, []string{"key1", "key2"}))
+	require.Empty(t, db.GetAll(ctx, id1))
+	_, err := db.Get(ctx, "key1")
+	require.ErrorIs(t, err, storage.ErrDeleted)
+
+	urls, users, err := db.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, urls)
+	require.Equal(t, 1, users)
+
+	// URL удалённой записи можно сохранить заново, но ключ остаётся занятым
+	require.NoError(t, db.Store(ctx, id2, storage.Record{Key: "key4", OriginalURL: "url1"}))
+	require.Error(t, db.Store(ctx, id2, storage.Record{Key: "key1", OriginalURL: "url5"}))
+	require.Equal(t, map[string]string{"key3": "url3", "key4": "url1"}, db.GetAll(ctx, id2))
+}
+
+// TestJournalReplay проверяет восстановление данных из журнала после аварийного завершения,
+// в том числе при оборванной последней записи журнала.
+func TestJournalReplay(t *testing.T) {
+	const fileName = "tmp_journal.db"
+	ctx := context.Background()
+	id := uuid.New()
+	defer func() {
+		os.Remove(fileName)
+		os.Remove(fileName + journalSuffix)
+		os.Remove(fileName + prevSuffix)
+		os.Remove(fileName + journalSuffix + prevSuffix)
+	}()
+
+	db, err := NewDB(fileName, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "url1"}))
+	require.NoError(t, db.BatchStore(ctx, id, []storage.Record{
+		{Key: "key2", OriginalURL: "url2"},
+		{Key: "key3", OriginalURL: "url3"},
+	}))
+	require.NoError(t, db.BatchDelete(ctx, id, []string{"key2"}))
+	crash(db)
+
+	// имитируем запись, оборванную при сбое
+	f, err := os.OpenFile(fileName+journalSuffix, os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1, 0, 42})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	db, err = NewDB(fileName, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"key1": "url1", "key3": "url3"}, db.GetAll(ctx, id))
+	_, err = db.Get(ctx, "key2")
+	require.ErrorIs(t, err, storage.ErrDeleted)
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key4", OriginalURL: "url4"}))
+	db.Close()
+
+	// после штатного завершения все данные в снимке, журнал удалён
+	_, err = os.Stat(fileName + journalSuffix)
+	require.True(t, os.IsNotExist(err))
+	db, err = NewDB(fileName, time.Hour)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, map[string]string{"key1": "url1", "key3": "url3", "key4": "url4"}, db.GetAll(ctx, id))
+}
+
+// shortWriteFile - файл журнала, при записи сохраняющий только половину данных и возвращающий ошибку.
+type shortWriteFile struct {
+	*os.File
+}
+
+func (f shortWriteFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+
+	return n, io.ErrShortWrite
+}
+
+// TestJournalShortWrite проверяет, что запись, оборванная ошибкой записи, не мешает чтению последующих записей.
+func TestJournalShortWrite(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.wal")
+	j, _, err := openJournal(fileName, logger.Default())
+	require.NoError(t, err)
+	defer j.close()
+
+	require.NoError(t, j.append(walEntry{Op: opBatchDelete, Keys: []string{"key1"}}))
+	file := j.file.(*os.File)
+	j.file = shortWriteFile{File: file}
+	require.ErrorIs(t, j.append(walEntry{Op: opBatchDelete, Keys: []string{"key2"}}), io.ErrShortWrite)
+	j.file = file
+	require.NoError(t, j.append(walEntry{Op: opBatchDelete, Keys: []string{"key3"}}))
+
+	f, err := os.Open(fileName)
+	require.NoError(t, err)
+	defer f.Close()
+	entries, _, err := readJournal(f)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, []string{"key1"}, entries[0].Keys)
+	require.Equal(t, []string{"key3"}, entries[1].Keys)
+}
+
+// TestPing проверяет, что Ping сообщает о неудачном сохранении снимка и о закрытом хранилище.
+func TestPing(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDB(fileName, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, db.Ping())
+	lastFlush, err := db.LastFlush()
+	require.NoError(t, err)
+	require.True(t, lastFlush.IsZero())
+
+	require.NoError(t, db.Store(context.Background(), uuid.New(), storage.Record{Key: "key1", OriginalURL: "url1"}))
+	require.NoError(t, db.flush())
+	lastFlush, err = db.LastFlush()
+	require.NoError(t, err)
+	require.False(t, lastFlush.IsZero())
+
+	// снимок не может быть записан в несуществующий каталог
+	require.NoError(t, db.Store(context.Background(), uuid.New(), storage.Record{Key: "key2", OriginalURL: "url2"}))
+	db.fileName = filepath.Join(t.TempDir(), "missing", "test.db")
+	require.Error(t, db.flush())
+	require.ErrorIs(t, db.Ping(), storage.ErrUnavailable)
+	failedFlush, err := db.LastFlush()
+	require.Error(t, err)
+	require.Equal(t, lastFlush, failedFlush)
+
+	db.fileName = fileName
+	require.NoError(t, db.flush())
+	require.NoError(t, db.Ping())
+	db.Close()
+	require.ErrorIs(t, db.Ping(), storage.ErrUnavailable)
+}
+
+// TestReaper проверяет, что просроченные записи помечаются как удалённые и это изменение
+// восстанавливается из журнала.
+func TestReaper(t *testing.T) {
+	const fileName = "tmp_reaper.db"
+	ctx := context.Background()
+	id := uuid.New()
+	defer func() {
+		os.Remove(fileName)
+		os.Remove(fileName + journalSuffix)
+		os.Remove(fileName + prevSuffix)
+		os.Remove(fileName + journalSuffix + prevSuffix)
+	}()
+
+	db, err := NewDB(fileName, time.Hour)
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "url1", ExpiresAt: now.Add(time.Minute)}))
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key2", OriginalURL: "url2", ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key3", OriginalURL: "url3"}))
+
+	require.NoError(t, db.reapExpired(now.Add(2*time.Minute)))
+	_, err = db.Get(ctx, "key1")
+	require.ErrorIs(t, err, storage.ErrDeleted)
+	require.Equal(t, map[string]string{"key2": "url2", "key3": "url3"}, db.GetAll(ctx, id))
+	// URL просроченной записи освобождается
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key4", OriginalURL: "url1"}))
+	crash(db)
+
+	db, err = NewDB(fileName, time.Hour)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Get(ctx, "key1")
+	require.ErrorIs(t, err, storage.ErrDeleted)
+	require.Equal(t, map[string]string{"key2": "url2", "key3": "url3", "key4": "url1"}, db.GetAll(ctx, id))
+}
+
+// TestClicksReplay проверяет, что переходы восстанавливаются из журнала и не дублируются,
+// если журнал проигрывается поверх снимка, уже содержащего эти переходы.
+func TestClicksReplay(t *testing.T) {
+	const fileName = "tmp_clicks.db"
+	ctx := context.Background()
+	id := uuid.New()
+	defer func() {
+		os.Remove(fileName)
+		os.Remove(fileName + journalSuffix)
+		os.Remove(fileName + prevSuffix)
+		os.Remove(fileName + journalSuffix + prevSuffix)
+	}()
+
+	db, err := NewDB(fileName, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "url1"}))
+	now := time.Now()
+	require.NoError(t, db.StoreClicks(ctx, []storage.Click{{Key: "key1", Time: now}, {Key: "key1", Time: now}}))
+	// имитируем сбой между сохранением снимка и очисткой журнала
+	db.Lock()
+	require.NoError(t, writeSnapshot(fileName, db.snapshot()))
+	db.Unlock()
+	require.NoError(t, db.StoreClicks(ctx, []storage.Click{{Key: "key1", Time: now}}))
+	crash(db)
+
+	db, err = NewDB(fileName, time.Hour)
+	require.NoError(t, err)
+	defer db.Close()
+	stats, err := db.ClickStats(ctx, id, "key1")
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Total)
+}
+
+// TestClickAggregates проверяет, что переходы хранятся в виде агрегатов по дням, в том числе
+// переходы из снимков старого формата с полной историей.
+func TestClickAggregates(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+	day := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	db := newIndexedDB([]row{{
+		SessionID:   id,
+		Key:         "key1",
+		OriginalURL: "url1",
+		Clicks:      []storage.Click{{Key: "key1", Time: day.Add(time.Hour)}, {Key: "key1", Time: day.Add(48 * time.Hour)}},
+	}})
+	require.NoError(t, db.StoreClicks(ctx, []storage.Click{
+		{Key: "key1", Time: day.Add(49 * time.Hour)},
+		{Key: "key1", Time: day.Add(25 * time.Hour)}, // запоздавший переход
+		{Key: "key1", Time: day.Add(2 * time.Hour)},
+	}))
+
+	stats, err := db.ClickStats(ctx, id, "key1")
+	require.NoError(t, err)
+	require.Equal(t, storage.ClickStats{
+		Total: 5,
+		Daily: []storage.DailyClicks{
+			{Date: day, Clicks: 2},
+			{Date: day.Add(24 * time.Hour), Clicks: 1},
+			{Date: day.Add(48 * time.Hour), Clicks: 2},
+		},
+	}, stats)
+	require.Nil(t, db.rows["key1"].Clicks, "raw click history must not be kept")
+}
+
+// crash останавливает хранилище без сохранения снимка, как при аварийном завершении.
+func crash(db *DB) {
+	db.Lock()
+	defer db.Unlock()
+	close(db.gobberStop)
+	db.gobberStop = nil
+	db.journal.close() //nolint:errcheck
+	db.journal = nil
+}
+
+// TestSnapshotFallback проверяет, что при повреждённом основном снимке данные восстанавливаются
+// из предыдущего поколения, а снимки старого формата (без заголовка) читаются.
+func TestSnapshotFallback(t *testing.T) {
+	const fileName = "tmp_snapshot.db"
+	defer func() {
+		os.Remove(fileName)
+		os.Remove(fileName + prevSuffix)
+	}()
+	gen1 := []row{{Key: "key1", OriginalURL: "url1"}}
+	gen2 := []row{{Key: "key1", OriginalURL: "url1"}, {Key: "key2", OriginalURL: "url2"}}
+
+	require.NoError(t, writeSnapshot(fileName, gen1))
+	require.NoError(t, writeSnapshot(fileName, gen2))
+	repo, fromPrev, err := initRepo(fileName, logger.Default())
+	require.NoError(t, err)
+	require.Equal(t, gen2, repo)
+	require.False(t, fromPrev)
+
+	// портим основной снимок - должно подняться предыдущее поколение
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(fileName, data[:len(data)-3], 0666))
+	_, err = readSnapshot(fileName)
+	require.ErrorIs(t, err, errBadSnapshot)
+	repo, fromPrev, err = initRepo(fileName, logger.Default())
+	require.NoError(t, err)
+	require.Equal(t, gen1, repo)
+	require.True(t, fromPrev)
+
+	// основного файла нет (сбой между переименованиями) - также используем предыдущее поколение
+	require.NoError(t, os.Remove(fileName))
+	repo, fromPrev, err = initRepo(fileName, logger.Default())
+	require.NoError(t, err)
+	require.Equal(t, gen1, repo)
+	require.True(t, fromPrev)
+
+	// испорчены оба поколения - хранилище не стартует
+	require.NoError(t, os.WriteFile(fileName, []byte(snapshotMagic+"garbage"), 0666))
+	require.NoError(t, os.WriteFile(fileName+prevSuffix, []byte("garbage"), 0666))
+	_, _, err = initRepo(fileName, logger.Default())
+	require.Error(t, err)
+
+	// файл старого формата - поток gob без заголовка
+	f, err := os.Create(fileName)
+	require.NoError(t, err)
+	require.NoError(t, gob.NewEncoder(f).Encode(&gen2))
+	require.NoError(t, f.Close())
+	repo, _, err = initRepo(fileName, logger.Default())
+	require.NoError(t, err)
+	require.Equal(t, gen2, repo)
+}
+
+// TestSnapshotFallbackJournal проверяет, что при восстановлении из предыдущего поколения снимка
+// не теряются изменения, сделанные между предыдущим и повреждённым снимками.
+func TestSnapshotFallbackJournal(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+	id := uuid.New()
+	now := time.Now()
+
+	db, err := NewDB(fileName, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "url1"}))
+	require.NoError(t, db.StoreClicks(ctx, []storage.Click{{Key: "key1", Time: now}}))
+	require.NoError(t, db.flush())
+	// изменения между предыдущим и последним снимками
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key2", OriginalURL: "url2"}))
+	require.NoError(t, db.StoreClicks(ctx, []storage.Click{{Key: "key1", Time: now}}))
+	require.NoError(t, db.BatchDelete(ctx, id, []string{"key2"}))
+	require.NoError(t, db.flush())
+	// изменения после последнего снимка
+	require.NoError(t, db.Store(ctx, id, storage.Record{Key: "key3", OriginalURL: "url3"}))
+	require.NoError(t, db.StoreClicks(ctx, []storage.Click{{Key: "key1", Time: now}}))
+	crash(db)
+
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(fileName, data[:len(data)-3], 0666))
+
+	db, err = NewDB(fileName, time.Hour)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, map[string]string{"key1": "url1", "key3": "url3"}, db.GetAll(ctx, id))
+	_, err = db.Get(ctx, "key2")
+	require.ErrorIs(t, err, storage.ErrDeleted)
+	stats, err := db.ClickStats(ctx, id, "key1")
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Total)
+}
+
+// TestConformance прогоняет общий набор тестов хранилища.
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		db, err := NewDB(filepath.Join(t.TempDir(), "test.db"), time.Hour)
+		require.NoError(t, err)
+		t.Cleanup(db.Close)
+		return db
+	})
+}
@@ -0,0 +1,253 @@
+package inmem
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+// journalSuffix - расширение файла журнала, который хранится рядом с файлом снимка хранилища.
+const journalSuffix = ".wal"
+
+// frameHeaderSize - размер заголовка записи журнала: длина данных (uint32) и контрольная сумма CRC32 (uint32).
+const frameHeaderSize = 8
+
+// Операции, сохраняемые в журнале.
+const (
+	opStore walOp = iota + 1
+	opBatchDelete
+	opExpire
+	opClicks
+)
+
+type (
+	// journal - журнал упреждающей записи (write-ahead log). Каждая изменяющая операция хранилища
+	// дописывается в конец файла и сбрасывается на диск (fsync) до того, как изменение попадёт в память.
+	// При старте сервиса журнал проигрывается поверх последнего снимка, а сервис gobber периодически
+	// сохраняет снимок и начинает новый сегмент журнала. Предыдущий сегмент (файл с суффиксом prevSuffix)
+	// хранится до следующего снимка: если последний снимок окажется повреждён, хранилище восстанавливается
+	// из предыдущего поколения снимка и проигрывает оба сегмента.
+	//
+	// Каждая запись журнала имеет вид: <длина данных><CRC32 данных><данные в формате gob>. Запись,
+	// оборванная при сбое, отбрасывается при чтении журнала.
+	journal struct {
+		name string
+		file journalFile
+	}
+
+	// journalFile - файл журнала. Интерфейс позволяет подменить файл в тестах.
+	journalFile interface {
+		io.WriteSeeker
+		Truncate(size int64) error
+		Sync() error
+		Close() error
+	}
+
+	walOp uint8
+
+	// walEntry - запись журнала.
+	walEntry struct {
+		Op walOp
+		// SessionID - пользователь, выполнивший операцию.
+		SessionID uuid.UUID
+		// Rows - сохраняемые записи (для opStore).
+		Rows []row
+		// Keys - удаляемые ключи (для opBatchDelete и opExpire).
+		Keys []string
+		// Clicks - переходы по ссылкам (для opClicks).
+		Clicks []storage.Click
+		// Positions - порядковые номера переходов в истории соответствующих ссылок (для opClicks).
+		// Позволяют не добавлять переход повторно при проигрывании журнала поверх снимка.
+		Positions []int
+	}
+)
+
+// openJournal открывает (или создаёт) файл журнала и считывает из него все корректные записи.
+// Если последняя запись повреждена (например, сервис упал во время записи), файл обрезается
+// до последней корректной записи.
+func openJournal(fileName string, log logrus.FieldLogger) (*journal, []walEntry, error) {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, nil, fmt.Errorf("openJournal: %w", err)
+	}
+	entries, size, err := readJournal(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("openJournal: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("openJournal: %w", err)
+	}
+	if info.Size() != size {
+		log.WithField("file", fileName).Warnf("journal: discarding %d bytes of a torn record", info.Size()-size)
+		if err := file.Truncate(size); err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("openJournal: %w", err)
+		}
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("openJournal: %w", err)
+	}
+	if len(entries) > 0 {
+		log.WithField("file", fileName).Infof("journal: read %d records", len(entries))
+	}
+
+	return &journal{name: fileName, file: file}, entries, nil
+}
+
+// readJournalFile считывает все корректные записи из файла журнала. Отсутствующий файл считается пустым.
+func readJournalFile(fileName string) ([]walEntry, error) {
+	file, err := os.Open(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("readJournalFile: %w", err)
+	}
+	defer file.Close()
+	entries, _, err := readJournal(file)
+	if err != nil {
+		return nil, fmt.Errorf("readJournalFile: %w", err)
+	}
+
+	return entries, nil
+}
+
+// readJournal последовательно читает записи журнала и возвращает их вместе с размером
+// корректной части файла.
+func readJournal(r io.Reader) ([]walEntry, int64, error) {
+	var (
+		entries []walEntry
+		size    int64
+		header  [frameHeaderSize]byte
+	)
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return entries, size, nil
+			}
+			return nil, 0, err
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		checksum := binary.BigEndian.Uint32(header[4:])
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return entries, size, nil
+			}
+			return nil, 0, err
+		}
+		if crc32.ChecksumIEEE(data) != checksum {
+			return entries, size, nil
+		}
+		var e walEntry
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+			return entries, size, nil
+		}
+		entries = append(entries, e)
+		size += frameHeaderSize + int64(length)
+	}
+}
+
+// append дописывает запись в журнал и сбрасывает её на диск.
+// Для хранилища без журнала (j == nil) ничего не делает.
+func (j *journal) append(e walEntry) error {
+	if j == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.Write(make([]byte, frameHeaderSize))
+	if err := gob.NewEncoder(&buf).Encode(&e); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	frame := buf.Bytes()
+	data := frame[frameHeaderSize:]
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(frame[4:frameHeaderSize], crc32.ChecksumIEEE(data))
+	offset, err := j.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	_, err = j.file.Write(frame)
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		// оборванная запись остановила бы чтение журнала, и все последующие записи были бы потеряны
+		if rbErr := j.rollback(offset); rbErr != nil {
+			return fmt.Errorf("journal: %w (could not discard the torn record: %v)", err, rbErr)
+		}
+		return fmt.Errorf("journal: %w", err)
+	}
+
+	return nil
+}
+
+// rollback обрезает журнал до размера offset, отбрасывая не полностью записанную запись.
+func (j *journal) rollback(offset int64) error {
+	if err := j.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := j.file.Seek(offset, io.SeekStart)
+
+	return err
+}
+
+// rotate начинает новый сегмент журнала. Вызывается после сохранения снимка: текущий сегмент содержит
+// изменения, сделанные между предыдущим и новым снимками, и сохраняется в файле с суффиксом prevSuffix
+// на случай, если новый снимок окажется повреждён. Сегмент, сохранённый при предыдущем вызове, больше
+// не нужен и перезаписывается.
+func (j *journal) rotate() error {
+	if j == nil {
+		return nil
+	}
+	tmpName := j.name + tmpSuffix
+	file, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	if err := os.Rename(j.name, j.name+prevSuffix); err != nil {
+		file.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("journal: %w", err)
+	}
+	if err := os.Rename(tmpName, j.name); err != nil {
+		// возвращаем текущий сегмент на место, чтобы последующие записи не попали в предыдущий сегмент
+		file.Close()
+		os.Remove(tmpName)
+		if rbErr := os.Rename(j.name+prevSuffix, j.name); rbErr != nil {
+			return fmt.Errorf("journal: %w (could not restore the journal: %v)", err, rbErr)
+		}
+		return fmt.Errorf("journal: %w", err)
+	}
+	old := j.file
+	j.file = file
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+
+	return syncDir(filepath.Dir(j.name))
+}
+
+// close закрывает файл журнала.
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+
+	return j.file.Close()
+}
@@ -0,0 +1,53 @@
+package inmem
+
+import (
+	"time"
+
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+// reaper - сервис, с периодичностью storage.ReapInterval помечающий просроченные записи как удалённые.
+// Сервис работает в своей горутине и завершается по сигналу из канала stop.
+func (db *DB) reaper(stop <-chan struct{}) {
+	defer db.workers.Done()
+	ticker := time.NewTicker(storage.ReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := db.reapExpired(now); err != nil {
+				db.log.WithError(err).Error("reaper: could not delete expired records")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reapExpired помечает как удалённые все записи, срок действия которых истёк к моменту now.
+// Поиск просроченных записей выполняется под блокировкой на чтение, чтобы не задерживать запросы.
+func (db *DB) reapExpired(now time.Time) error {
+	db.RLock()
+	var keys []string
+	for key, r := range db.rows {
+		if !r.Deleted && storage.Expired(r.ExpiresAt, now) {
+			keys = append(keys, key)
+		}
+	}
+	db.RUnlock()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	db.Lock()
+	defer db.Unlock()
+	e := walEntry{Op: opExpire, Keys: keys}
+	if err := db.journal.append(e); err != nil {
+		return err
+	}
+	db.apply(e)
+	db.isChanged = true
+	db.log.Infof("reaper: %d expired records deleted", len(keys))
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package inmem
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// Файл снимка хранилища состоит из заголовка и данных в формате gob:
+//
+//	<magic: 8 байт><версия: uint32><длина данных: uint64><CRC32 данных: uint32><данные>
+//
+// Снимок сначала записывается во временный файл, сбрасывается на диск и только затем атомарно
+// переименовывается в основной файл. Предыдущий снимок сохраняется в файле с суффиксом prevSuffix
+// и используется, если основной файл не удаётся прочитать.
+const (
+	snapshotMagic   = "SHRTSNAP"
+	snapshotVersion = 1
+
+	snapshotHeaderSize = len(snapshotMagic) + 4 + 8 + 4
+
+	// tmpSuffix - расширение временного файла, в который пишется новый снимок.
+	tmpSuffix = ".tmp"
+	// prevSuffix - расширение файла с предыдущим поколением снимка.
+	prevSuffix = ".prev"
+)
+
+var errBadSnapshot = errors.New("snapshot is corrupted")
+
+// writeSnapshot атомарно сохраняет записи хранилища в файл fileName.
+func writeSnapshot(fileName string, repo []row) error {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(&repo); err != nil {
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+	header := make([]byte, snapshotHeaderSize)
+	copy(header, snapshotMagic)
+	offset := len(snapshotMagic)
+	binary.BigEndian.PutUint32(header[offset:], snapshotVersion)
+	binary.BigEndian.PutUint64(header[offset+4:], uint64(payload.Len()))
+	binary.BigEndian.PutUint32(header[offset+12:], crc32.ChecksumIEEE(payload.Bytes()))
+
+	tmpName := fileName + tmpSuffix
+	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	w.Write(header)          //nolint:errcheck // ошибка будет получена при вызове Flush
+	w.Write(payload.Bytes()) //nolint:errcheck
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+
+	// текущий снимок становится предыдущим поколением
+	if err := os.Rename(fileName, fileName+prevSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+	if err := os.Rename(tmpName, fileName); err != nil {
+		return fmt.Errorf("writeSnapshot: %w", err)
+	}
+
+	return syncDir(filepath.Dir(fileName))
+}
+
+// syncDir сбрасывает на диск содержимое каталога, чтобы переименование файлов пережило сбой.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("syncDir: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("syncDir: %w", err)
+	}
+
+	return nil
+}
+
+// readSnapshot считывает записи хранилища из файла снимка, проверяя его контрольную сумму.
+// Файлы, сохранённые до появления заголовка, читаются как обычный поток gob.
+func readSnapshot(fileName string) ([]row, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := make([]row, 0)
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) { // файл старого формата
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&repo); err != nil {
+			return nil, fmt.Errorf("%w: %v", errBadSnapshot, err)
+		}
+		return repo, nil
+	}
+
+	if len(data) < snapshotHeaderSize {
+		return nil, fmt.Errorf("%w: header is truncated", errBadSnapshot)
+	}
+	offset := len(snapshotMagic)
+	version := binary.BigEndian.Uint32(data[offset:])
+	length := binary.BigEndian.Uint64(data[offset+4:])
+	checksum := binary.BigEndian.Uint32(data[offset+12:])
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", errBadSnapshot, version)
+	}
+	payload := data[snapshotHeaderSize:]
+	if uint64(len(payload)) != length {
+		return nil, fmt.Errorf("%w: expected %d bytes of data, got %d", errBadSnapshot, length, len(payload))
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", errBadSnapshot)
+	}
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&repo); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadSnapshot, err)
+	}
+
+	return repo, nil
+}
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -10,35 +12,84 @@ import (
 // Storage представляет хранилище для  пар key:URL.
 type (
 	Storage interface {
-		// Store сохраняет в хранилище пару ключ:url и возвращает ошибку, если ключ уже используется.
-		Store(ctx context.Context, id uuid.UUID, key, url string) error
-		// Get по ключу возвращает значение, либо ошибку, если ключа в базе нет.
+		// Store атомарно сохраняет в хранилище пару rec.Key:rec.OriginalURL, если ни ключ, ни URL ещё не заняты.
+		// Если ключ уже используется (в том числе удалённой или просроченной записью), возвращается
+		// ErrKeyCollision, если такой URL уже сохранён - ErrURLArlreadyExists с ключом существующей записи.
+		// При параллельных вызовах с одинаковым ключом или URL успешно завершается только один из них.
+		Store(ctx context.Context, id uuid.UUID, rec Record) error
+		// Get по ключу возвращает значение. Если ключа в базе нет, возвращается ErrNotFound,
+		// если запись удалена - ErrDeleted, если истёк срок действия записи - ErrExpired.
 		Get(ctx context.Context, key string) (string, error)
+		// Lookup по ключу возвращает запись целиком (поле CorellationID не заполняется).
+		// Ошибки те же, что и у метода Get.
+		Lookup(ctx context.Context, key string) (Record, error)
 		// GetAll возвращает все пары <key>:<URL> созданные данным пользователем.
 		// Если ни одной записи не найдено, возвращается пустая мапа.
 		GetAll(ctx context.Context, id uuid.UUID) map[string]string
 		// BatchStore сохраняет в хранилище пакет с парами <OriginalURL> : <Key> из передаваемых объектов Record.
-		// Ошибка выдается, если хотя бы один ключ не уникален.
+		// Если хотя бы один ключ не уникален, возвращается ErrKeyCollision, если не уникален URL -
+		// ErrBatchURLUniqueViolation. В случае ошибки не сохраняется ни одна запись.
 		BatchStore(ctx context.Context, id uuid.UUID, records []Record) error
 		// BatchDelete производит мягкое удаление записей из хранилища с ключами <keys>, если их создал пользователь
 		// с указанным id.
 		BatchDelete(ctx context.Context, id uuid.UUID, keys []string) error
 		// Stats возвращает общее количество сокращенных URL и количество пользователей в сервисе.
 		Stats(ctx context.Context) (urls int, users int, err error)
+		// StoreClicks сохраняет пакет записей о переходах по коротким ссылкам. Переходы по ключам,
+		// которых нет в хранилище, игнорируются.
+		StoreClicks(ctx context.Context, clicks []Click) error
+		// ClickStats возвращает статистику переходов по ключу key. Если ключа нет в хранилище или он создан
+		// другим пользователем, возвращается ErrNotFound.
+		ClickStats(ctx context.Context, id uuid.UUID, key string) (ClickStats, error)
 		// Close  завершает работу хранилища
 		Close()
 		// Ping проверяет соединение с хранилищем
 		Ping() error
 	}
 
-	// Record хранит информацию, передаваемую базе данных в пакетном запросе.
+	// Record хранит информацию о сохраняемой в базе данных записи.
 	Record struct {
-		// CorellationID - строковый идентификатор. В хранилище не сохраняется.
+		// CorellationID - строковый идентификатор пакетного запроса. В хранилище не сохраняется.
 		CorellationID string
 		// OriginalURL - передаваемый URL для сокращения
 		OriginalURL string
 		// Key - ключ для доступа к оригинальному URL
 		Key string
+		// ExpiresAt - время, после которого ссылка перестаёт действовать. Нулевое значение - срок не ограничен.
+		// Просроченные записи периодически помечаются хранилищем как удалённые.
+		ExpiresAt time.Time
+		// CreatedAt - время создания ссылки. У записей, созданных до появления этого поля, - нулевое значение.
+		CreatedAt time.Time
+		// Title - заголовок ссылки, заданный её автором. Показывается на странице предпросмотра.
+		Title string
+		// AlwaysPreview - при переходе по ссылке всегда показывать страницу предпросмотра вместо перенаправления.
+		AlwaysPreview bool
+	}
+
+	// Click - запись о переходе по короткой ссылке.
+	Click struct {
+		Key       string    `json:"key"`
+		Time      time.Time `json:"time"`
+		Referrer  string    `json:"referrer,omitempty"`
+		UserAgent string    `json:"user_agent,omitempty"`
+		// IP - адрес подсети клиента (/24 для IPv4, /48 для IPv6). Полный адрес клиента не сохраняется.
+		IP string `json:"ip,omitempty"`
+	}
+
+	// ClickStats - статистика переходов по короткой ссылке.
+	ClickStats struct {
+		// Total - общее количество переходов.
+		Total int
+		// Daily - количество переходов по дням (UTC) в порядке возрастания даты.
+		// Дни без переходов не включаются.
+		Daily []DailyClicks
+	}
+
+	// DailyClicks - количество переходов за сутки.
+	DailyClicks struct {
+		// Date - начало суток (UTC).
+		Date   time.Time
+		Clicks int
 	}
 
 	storageError string
@@ -48,6 +99,13 @@ type (
 		Key string
 		URL string
 	}
+
+	// UnavailableError оборачивает ошибку, вызванную временной недоступностью хранилища
+	// (потеря соединения, таймаут, перегрузка). Операцию имеет смысл повторить позже.
+	// Проверка класса ошибки выполняется через errors.Is(err, ErrUnavailable).
+	UnavailableError struct {
+		Err error
+	}
 )
 
 func (e storageError) Error() string {
@@ -58,10 +116,75 @@ func (err ErrURLArlreadyExists) Error() string {
 	return fmt.Sprintf("Url %s already exists in the database", err.URL)
 }
 
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrUnavailable, e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// Is позволяет проверять принадлежность ошибки к классу ErrUnavailable.
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
 const (
+	// ErrNotFound возвращается, когда запрашиваемого ключа нет в хранилище.
+	ErrNotFound storageError = "Key not found"
+
+	// ErrKeyCollision возвращается при попытке сохранить запись с ключом, который уже используется.
+	ErrKeyCollision storageError = "Key is already in use"
+
+	// ErrUnavailable - класс ошибок временной недоступности хранилища (см. UnavailableError).
+	ErrUnavailable storageError = "Storage is unavailable"
+
 	// ErrBatchURLUniqueViolation возвращается при попытке пакетного сохранения URL, если некоторые из них уже есть в базе.
 	ErrBatchURLUniqueViolation storageError = "Some of URLs is already exists in the database"
 
 	// ErrDeleted возвращается, когда запрашиваемый ключ был удален.
 	ErrDeleted storageError = "Key was deleted"
+
+	// ErrExpired возвращается, когда срок действия запрашиваемого ключа истёк.
+	ErrExpired storageError = "Key has expired"
+)
+
+// ReapInterval - периодичность, с которой хранилища помечают просроченные записи как удалённые.
+const ReapInterval = time.Minute
+
+// Expired сообщает, истёк ли к моменту now срок действия записи со временем окончания expiresAt.
+func Expired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// Исходы операций хранилища (см. Outcome).
+const (
+	OutcomeOK          = "ok"
+	OutcomeNotFound    = "not_found"
+	OutcomeGone        = "gone"
+	OutcomeConflict    = "conflict"
+	OutcomeUnavailable = "unavailable"
+	OutcomeError       = "error"
 )
+
+// Outcome возвращает исход операции хранилища, завершившейся ошибкой err. Ожидаемые исходы (ключ не найден,
+// удалён, просрочен или занят) отделены от сбоев хранилища, чтобы обычные ответы 404, 409 и 410
+// не учитывались в метриках и трассировке как ошибки.
+func Outcome(err error) string {
+	var errURLAlreadyExists *ErrURLArlreadyExists
+	switch {
+	case err == nil:
+		return OutcomeOK
+	case errors.Is(err, ErrNotFound):
+		return OutcomeNotFound
+	case errors.Is(err, ErrDeleted), errors.Is(err, ErrExpired):
+		return OutcomeGone
+	case errors.Is(err, ErrKeyCollision), errors.Is(err, ErrBatchURLUniqueViolation),
+		errors.As(err, &errURLAlreadyExists):
+		return OutcomeConflict
+	case errors.Is(err, ErrUnavailable):
+		return OutcomeUnavailable
+	}
+
+	return OutcomeError
+}
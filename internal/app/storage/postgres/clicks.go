@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+// StoreClicks имплементирует интерфейс storage.Storage. Переходы хранятся в виде счётчиков по дням (UTC).
+func (r Repo) StoreClicks(ctx context.Context, clicks []storage.Click) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	// nolint:errcheck
+	defer tx.Rollback()
+
+	// переходы по отсутствующим ключам пропускаются, чтобы не нарушить внешний ключ и не откатить весь пакет
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO daily_clicks (key, day, clicks)
+		SELECT $1::text, $2::date, 1 WHERE EXISTS (SELECT 1 FROM repo WHERE key=$1)
+		ON CONFLICT (key, day) DO UPDATE SET clicks = daily_clicks.clicks + 1;`)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer stmt.Close()
+
+	for _, c := range clicks {
+		if _, err := stmt.ExecContext(ctx, c.Key, c.Time.UTC().Truncate(24*time.Hour)); err != nil {
+			return wrapErr(err)
+		}
+	}
+
+	return wrapErr(tx.Commit())
+}
+
+// ClickStats имплементирует интерфейс storage.Storage.
+func (r Repo) ClickStats(ctx context.Context, id uuid.UUID, key string) (storage.ClickStats, error) {
+	var owner string
+	err := r.db.QueryRowContext(ctx, `SELECT id FROM repo WHERE key=$1;`, key).Scan(&owner)
+	if err != nil {
+		return storage.ClickStats{}, wrapErr(err)
+	}
+	if owner != id.String() {
+		return storage.ClickStats{}, fmt.Errorf("postgres: key %s: %w", key, storage.ErrNotFound)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT day, clicks FROM daily_clicks WHERE key=$1 ORDER BY day;`, key)
+	if err != nil {
+		return storage.ClickStats{}, wrapErr(err)
+	}
+	defer rows.Close()
+
+	stats := storage.ClickStats{Daily: make([]storage.DailyClicks, 0)}
+	for rows.Next() {
+		var (
+			day    time.Time
+			clicks int
+		)
+		if err := rows.Scan(&day, &clicks); err != nil {
+			return storage.ClickStats{}, wrapErr(err)
+		}
+		stats.Daily = append(stats.Daily, storage.DailyClicks{
+			Date:   time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC),
+			Clicks: clicks,
+		})
+		stats.Total += clicks
+	}
+	if err := rows.Err(); err != nil {
+		return storage.ClickStats{}, wrapErr(err)
+	}
+
+	return stats, nil
+}
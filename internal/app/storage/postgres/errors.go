@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+// keyConstraint - имя ограничения уникальности ключа в таблице repo.
+const keyConstraint = "repo_key_key"
+
+// isUniqueViolation проверяет, нарушено ли ограничение уникальности, и возвращает имя ограничения.
+func isUniqueViolation(err error) (constraint string, ok bool) {
+	var pgErr pgx.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return pgErr.ConstraintName, true
+	}
+
+	return "", false
+}
+
+// wrapErr приводит ошибку драйвера к ошибкам пакета storage: отсутствие строки - к storage.ErrNotFound,
+// ошибки соединения и перегрузки сервера - к классу storage.ErrUnavailable.
+func wrapErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrNotFound
+	}
+	if isTransient(err) {
+		return &storage.UnavailableError{Err: err}
+	}
+
+	return fmt.Errorf("postgres: %w", err)
+}
+
+// isTransient определяет, вызвана ли ошибка временной недоступностью базы данных.
+func isTransient(err error) bool {
+	var pgErr pgx.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") || // connection exception
+			strings.HasPrefix(pgErr.Code, "53") || // insufficient resources
+			strings.HasPrefix(pgErr.Code, "57P") || // operator intervention (shutdown)
+			pgErr.Code == pgerrcode.SerializationFailure ||
+			pgErr.Code == pgerrcode.DeadlockDetected
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, pgx.ErrDeadConn) ||
+		errors.Is(err, pgx.ErrAcquireTimeout)
+}
@@ -0,0 +1,259 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Миграции схемы хранятся в каталоге migrations в виде пар файлов <версия>_<имя>.up.sql и
+// <версия>_<имя>.down.sql и встраиваются в бинарный файл. Применённые версии учитываются в таблице schema_migrations.
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationsLockID - ключ advisory-блокировки, не позволяющей нескольким экземплярам сервиса
+// применять миграции одновременно.
+const migrationsLockID = 20220501
+
+type (
+	// migration - версия схемы базы данных.
+	migration struct {
+		version int
+		name    string
+		up      string
+		down    string
+	}
+
+	// MigrationStatus описывает состояние одной миграции.
+	MigrationStatus struct {
+		Version int
+		Name    string
+		// AppliedAt - время применения миграции; нулевое значение, если миграция не применена.
+		AppliedAt time.Time
+	}
+)
+
+// Applied сообщает, применена ли миграция.
+func (ms MigrationStatus) Applied() bool {
+	return !ms.AppliedAt.IsZero()
+}
+
+// loadMigrations считывает встроенные миграции и возвращает их в порядке возрастания версий.
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*migration)
+	for _, file := range files {
+		base := path.Base(file)
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migrations: unexpected file %s", base)
+		}
+		parts := strings.SplitN(strings.TrimSuffix(base, "."+direction+".sql"), "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("migrations: wrong file name %s", base)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("migrations: wrong version in file name %s: %w", base, err)
+		}
+		query, err := migrationsFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(query)
+		} else {
+			m.down = string(query)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migrations: version %d must have both up and down files", m.version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	return migrations, nil
+}
+
+// createMigrationsTable создаёт таблицу учёта миграций, если она отсутствует.
+func (r Repo) createMigrationsTable(ctx context.Context) error {
+	const query = `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now());`
+	if _, err := r.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("could not create schema_migrations table: %w", err)
+	}
+
+	return nil
+}
+
+// MigrateUp применяет все ещё не применённые миграции. Каждая миграция выполняется в отдельной транзакции.
+func (r Repo) MigrateUp(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if err := r.createMigrationsTable(ctx); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		m := m
+		err := r.inMigrationTx(ctx, func(tx *sql.Tx) error {
+			applied, err := isApplied(ctx, tx, m.version)
+			if err != nil || applied {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, m.up); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2);`, m.version, m.name); err != nil {
+				return err
+			}
+			r.log.Infof("applied migration %04d_%s", m.version, m.name)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown откатывает steps последних применённых миграций.
+func (r Repo) MigrateDown(ctx context.Context, steps int) error {
+	if steps < 1 {
+		return errors.New("number of steps must be positive")
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if err := r.createMigrationsTable(ctx); err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		reverted := false
+		err := r.inMigrationTx(ctx, func(tx *sql.Tx) error {
+			applied, err := isApplied(ctx, tx, m.version)
+			if err != nil || !applied {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, m.down); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version=$1;`, m.version); err != nil {
+				return err
+			}
+			reverted = true
+			r.log.Infof("reverted migration %04d_%s", m.version, m.name)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", m.version, m.name, err)
+		}
+		if reverted {
+			steps--
+		}
+	}
+
+	return nil
+}
+
+// MigrationStatus возвращает состояние всех известных миграций в порядке возрастания версий.
+func (r Repo) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.createMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	appliedAt := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version int
+			at      time.Time
+		)
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status = append(status, MigrationStatus{
+			Version:   m.version,
+			Name:      m.name,
+			AppliedAt: appliedAt[m.version],
+		})
+	}
+
+	return status, nil
+}
+
+// inMigrationTx выполняет функцию fn в транзакции под advisory-блокировкой миграций.
+func (r Repo) inMigrationTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// nolint:errcheck
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1);`, migrationsLockID); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isApplied проверяет, применена ли миграция с указанной версией.
+func isApplied(ctx context.Context, tx *sql.Tx, version int) (bool, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version=$1);`, version).Scan(&exists)
+
+	return exists, err
+}
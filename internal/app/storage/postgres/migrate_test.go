@@ -0,0 +1,24 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadMigrations проверяет, что встроенные миграции читаются, упорядочены по версиям
+// и первая из них воспроизводит исходную схему таблицы repo.
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	assert.Equal(t, 1, migrations[0].version)
+	assert.Equal(t, "create_repo", migrations[0].name)
+	assert.Contains(t, migrations[0].up, "CREATE TABLE IF NOT EXISTS repo")
+	assert.Contains(t, migrations[0].down, "DROP TABLE IF EXISTS repo")
+	for i := 1; i < len(migrations); i++ {
+		assert.Less(t, migrations[i-1].version, migrations[i].version)
+	}
+}
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS repo (id TEXT, key TEXT UNIQUE, url TEXT, deleted BOOLEAN DEFAULT FALSE);
+CREATE UNIQUE INDEX IF NOT EXISTS url_not_deleted ON repo(url) WHERE NOT deleted;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS repo_expires_at;
+ALTER TABLE repo DROP COLUMN IF EXISTS expires_at;
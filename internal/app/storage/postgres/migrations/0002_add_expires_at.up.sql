@@ -0,0 +1,2 @@
+ALTER TABLE repo ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS repo_expires_at ON repo(expires_at) WHERE NOT deleted AND expires_at IS NOT NULL;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS clicks (
+    key TEXT NOT NULL REFERENCES repo(key) ON DELETE CASCADE,
+    clicked_at TIMESTAMPTZ NOT NULL,
+    referrer TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '');
+CREATE INDEX IF NOT EXISTS clicks_key_clicked_at ON clicks(key, clicked_at);
@@ -0,0 +1,3 @@
+ALTER TABLE repo DROP COLUMN IF EXISTS always_preview;
+ALTER TABLE repo DROP COLUMN IF EXISTS title;
+ALTER TABLE repo DROP COLUMN IF EXISTS created_at;
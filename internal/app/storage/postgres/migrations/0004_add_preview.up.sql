@@ -0,0 +1,3 @@
+ALTER TABLE repo ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
+ALTER TABLE repo ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '';
+ALTER TABLE repo ADD COLUMN IF NOT EXISTS always_preview BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS daily_clicks (
+    key TEXT NOT NULL REFERENCES repo(key) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    clicks INTEGER NOT NULL,
+    PRIMARY KEY (key, day));
+INSERT INTO daily_clicks (key, day, clicks)
+    SELECT key, (clicked_at AT TIME ZONE 'UTC')::date, count(*) FROM clicks GROUP BY 1, 2;
+DROP TABLE IF EXISTS clicks;
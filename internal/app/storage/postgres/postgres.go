@@ -4,16 +4,15 @@ package postgres
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgerrcode"
-	"github.com/jackc/pgx"
+	"github.com/sirupsen/logrus"
 
 	//pgx is postgres driver.
 	_ "github.com/jackc/pgx/stdlib"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
 )
 
@@ -21,67 +20,97 @@ var _ storage.Storage = (*Repo)(nil)
 
 type Repo struct {
 	db *sql.DB
+
+	// stopReaper останавливает воркер reaper. Воркер запускается только в NewRepo.
+	stopReaper context.CancelFunc
+
+	log logrus.FieldLogger
 }
 
-// NewRepo создаёт новый сервис Postgreds storage.
-func NewRepo(ctx context.Context, dsn string) (*Repo, error) {
-	db, err := sql.Open("pgx", dsn)
-	if err != nil {
-		return nil, fmt.Errorf("newRepo: could not connect to the DB: %w", err)
-	}
-	err = db.Ping()
-	if err != nil {
-		return nil, fmt.Errorf("newRepo: ping to DB failed: %w", err)
+// Option - параметр хранилища, передаваемый конструкторам NewRepo и Open.
+type Option func(*Repo)
+
+// WithLogger задаёт логгер хранилища.
+func WithLogger(l logrus.FieldLogger) Option {
+	return func(r *Repo) {
+		r.log = l
 	}
+}
 
-	r := Repo{db: db}
-	err = r.createTable(ctx)
+// NewRepo создаёт новый сервис Postgreds storage и применяет к базе данных недостающие миграции схемы.
+func NewRepo(ctx context.Context, dsn string, opts ...Option) (*Repo, error) {
+	r, err := Open(ctx, dsn, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("newRepo: CreateTable: %w", err)
+		return nil, err
+	}
+	if err := r.MigrateUp(ctx); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("newRepo: %w", err)
 	}
+	reaperCtx, cancel := context.WithCancel(context.Background())
+	r.stopReaper = cancel
+	go r.reaper(reaperCtx)
 
-	return &r, nil
+	return r, nil
 }
 
-// createTable создает таблицу для хранилища, если она отсутствует.
-func (r Repo) createTable(ctx context.Context) error {
-	const queryCreate = `CREATE TABLE IF NOT EXISTS repo (id TEXT, key TEXT UNIQUE, url TEXT, deleted BOOLEAN DEFAULT FALSE);`
-	const queryIndex = `CREATE UNIQUE INDEX IF NOT EXISTS url_not_deleted ON repo(url) WHERE NOT deleted;`
-	_, err := r.db.ExecContext(ctx, queryCreate)
+// Open подключается к базе данных, не применяя миграции.
+func Open(ctx context.Context, dsn string, opts ...Option) (*Repo, error) {
+	db, err := sql.Open("pgx", dsn)
 	if err != nil {
-		return fmt.Errorf("could not create table: %w", err)
+		return nil, fmt.Errorf("newRepo: could not connect to the DB: %w", err)
 	}
-
-	_, err = r.db.ExecContext(ctx, queryIndex)
+	err = db.PingContext(ctx)
 	if err != nil {
-		return fmt.Errorf("could not create index: %w", err)
+		db.Close()
+		return nil, fmt.Errorf("newRepo: ping to DB failed: %w", err)
 	}
 
-	return nil
+	r := &Repo{
+		db:  db,
+		log: logger.Default(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.log = r.log.WithField(logger.FieldComponent, "postgres")
+
+	return r, nil
 }
 
-// destructiveReset удаляет таблицу из хранилища и пересоздаёт её заново.
-func (r Repo) destructiveReset(ctx context.Context) error {
-	const query = `DROP TABLE IF EXISTS repo;`
-	res, err := r.db.ExecContext(ctx, query)
+// expireURLQuery помечает как удалённую просроченную запись с заданным URL, ещё не удалённую reaper,
+// чтобы она не мешала сохранить этот URL заново.
+const expireURLQuery = `UPDATE repo SET deleted=TRUE WHERE url=$1 AND NOT deleted AND expires_at <= now();`
+
+// Store имплементирует интерфейс storage.Storage.
+func (r Repo) Store(ctx context.Context, id uuid.UUID, rec storage.Record) error {
+	key, url := rec.Key, rec.OriginalURL
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return wrapErr(err)
 	}
-	log.Printf("postgres: drop table: %+v", res)
-
-	return r.createTable(ctx)
-}
+	// nolint:errcheck
+	defer tx.Rollback()
 
-func (r Repo) Store(ctx context.Context, id uuid.UUID, key, url string) error {
-	_, err := r.db.ExecContext(ctx,
-		`INSERT INTO repo (id, key, url) VALUES ($1,$2,$3);`,
-		id.String(), key, url)
+	if _, err := tx.ExecContext(ctx, expireURLQuery, url); err != nil {
+		return wrapErr(err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO repo (id, key, url, expires_at, created_at, title, always_preview) VALUES ($1,$2,$3,$4,$5,$6,$7);`,
+		id.String(), key, url, nullTime(rec.ExpiresAt), nullTime(rec.CreatedAt), rec.Title, rec.AlwaysPreview)
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
-		var pgErr pgx.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation { // Если url уже имеется в таблице...
+		constraint, ok := isUniqueViolation(err)
+		if ok && constraint == keyConstraint {
+			return fmt.Errorf("postgres: key %s: %w", key, storage.ErrKeyCollision)
+		}
+		if ok { // Если url уже имеется в таблице...
+			tx.Rollback() // nolint:errcheck
 			row := r.db.QueryRowContext(ctx, "SELECT key FROM repo WHERE url=$1 AND NOT deleted;", url)
 			if err = row.Scan(&key); err != nil {
-				return fmt.Errorf("postgres: url '%s' already exists in the database, but we cannot get the key: %w", url, err)
+				return fmt.Errorf("postgres: url '%s' already exists in the database, but we cannot get the key: %w", url, wrapErr(err))
 			}
 			return &storage.ErrURLArlreadyExists{ // возвращаем имеющиеся ключ с URL'ом в теле ошибки.
 				Key: key,
@@ -89,7 +118,7 @@ func (r Repo) Store(ctx context.Context, id uuid.UUID, key, url string) error {
 			}
 		}
 
-		return fmt.Errorf("postgres: %w", err)
+		return wrapErr(err)
 	}
 
 	return nil
@@ -102,7 +131,7 @@ func (r Repo) GetAll(ctx context.Context, id uuid.UUID) map[string]string {
 		`SELECT key, url FROM repo WHERE id=$1 AND NOT deleted;`,
 		id.String())
 	if err != nil {
-		log.Printf("postgres: %v", err)
+		r.log.WithError(err).Error("GetAll: could not query records")
 		return m
 	}
 	defer rows.Close()
@@ -111,13 +140,13 @@ func (r Repo) GetAll(ctx context.Context, id uuid.UUID) map[string]string {
 		var key, url string
 		err = rows.Scan(&key, &url)
 		if err != nil {
-			log.Printf("postgres: %v", err)
+			r.log.WithError(err).Error("GetAll: could not scan a record")
 		}
 		m[key] = url
 	}
 
-	if rows.Err() != nil {
-		log.Printf("postgres: %v", err)
+	if err := rows.Err(); err != nil {
+		r.log.WithError(err).Error("GetAll: could not read records")
 	}
 
 	return m
@@ -126,85 +155,122 @@ func (r Repo) GetAll(ctx context.Context, id uuid.UUID) map[string]string {
 
 // Get имплементирует интерфейс storage.Storage.
 func (r Repo) Get(ctx context.Context, key string) (string, error) {
+	rec, err := r.Lookup(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	return rec.OriginalURL, nil
+}
+
+// Lookup имплементирует интерфейс storage.Storage.
+func (r Repo) Lookup(ctx context.Context, key string) (storage.Record, error) {
 	row := r.db.QueryRowContext(ctx,
-		`SELECT url, deleted FROM repo WHERE key=$1;`, key)
-	var url string
+		`SELECT url, deleted, expires_at, created_at, title, always_preview FROM repo WHERE key=$1;`, key)
+	rec := storage.Record{Key: key}
 	var deleted bool
-	err := row.Scan(&url, &deleted)
+	var expiresAt, createdAt sql.NullTime
+	if err := row.Scan(&rec.OriginalURL, &deleted, &expiresAt, &createdAt, &rec.Title, &rec.AlwaysPreview); err != nil {
+		return storage.Record{}, wrapErr(err)
+	}
 	if deleted {
-		return "", storage.ErrDeleted
+		return storage.Record{}, storage.ErrDeleted
 	}
+	if expiresAt.Valid && storage.Expired(expiresAt.Time, time.Now()) {
+		return storage.Record{}, storage.ErrExpired
+	}
+	rec.ExpiresAt, rec.CreatedAt = expiresAt.Time, createdAt.Time
 
-	return url, err
+	return rec, nil
 }
 
 // Close имплементирует интерфейс storage.Storage.
 func (r Repo) Close() {
+	if r.stopReaper != nil {
+		r.stopReaper()
+	}
 	r.db.Close()
-	log.Println("postgres: database closed")
+	r.log.Info("database closed")
 }
 
 // Ping имплементирует интерфейс storage.Storage.
 func (r Repo) Ping() error {
-	return r.db.Ping()
+	if err := r.db.Ping(); err != nil {
+		return &storage.UnavailableError{Err: err}
+	}
+
+	return nil
 }
 
 // BatchStore имплементирует интерфейс storage.Storage.
 func (r Repo) BatchStore(ctx context.Context, id uuid.UUID, records []storage.Record) error {
 	tx, err := r.db.Begin()
 	if err != nil {
-		return err
+		return wrapErr(err)
 	}
 	// nolint:errcheck
 	defer tx.Rollback()
 
-	stmt, err := tx.PrepareContext(ctx, "INSERT INTO repo (id, key, url) VALUES ($1, $2, $3);")
+	expireStmt, err := tx.PrepareContext(ctx, expireURLQuery)
 	if err != nil {
-		return err
+		return wrapErr(err)
+	}
+	defer expireStmt.Close()
+	stmt, err := tx.PrepareContext(ctx,
+		"INSERT INTO repo (id, key, url, expires_at, created_at, title, always_preview) VALUES ($1, $2, $3, $4, $5, $6, $7);")
+	if err != nil {
+		return wrapErr(err)
 	}
 	defer stmt.Close()
 
 	for _, rec := range records {
-		if _, err = stmt.ExecContext(ctx, id, rec.Key, rec.OriginalURL); err != nil {
-			var pgErr pgx.PgError
-			if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		if _, err = expireStmt.ExecContext(ctx, rec.OriginalURL); err != nil {
+			return wrapErr(err)
+		}
+		_, err = stmt.ExecContext(ctx, id, rec.Key, rec.OriginalURL,
+			nullTime(rec.ExpiresAt), nullTime(rec.CreatedAt), rec.Title, rec.AlwaysPreview)
+		if err != nil {
+			if constraint, ok := isUniqueViolation(err); ok {
+				if constraint == keyConstraint {
+					return fmt.Errorf("postgres: key %s: %w", rec.Key, storage.ErrKeyCollision)
+				}
 				return storage.ErrBatchURLUniqueViolation
 			}
-			return err
+			return wrapErr(err)
 		}
 	}
 
-	return tx.Commit()
+	return wrapErr(tx.Commit())
 }
 
 // BatchDelete имплементирует интерфейс storage.Storage.
 func (r Repo) BatchDelete(ctx context.Context, id uuid.UUID, keys []string) error {
 	tx, err := r.db.Begin()
 	if err != nil {
-		return err
+		return wrapErr(err)
 	}
 	// nolint:errcheck
 	defer tx.Rollback()
 
 	stmt, err := tx.PrepareContext(ctx, "UPDATE repo SET deleted=TRUE WHERE id=$1 AND key=$2;")
 	if err != nil {
-		return err
+		return wrapErr(err)
 	}
 	defer stmt.Close()
 
 	for _, key := range keys {
 		if _, err = stmt.ExecContext(ctx, id, key); err != nil {
-			return err
+			return wrapErr(err)
 		}
 	}
-	return tx.Commit()
+	return wrapErr(tx.Commit())
 }
 
 // Stats - реализация метода интерфейса storage.Storage.
 func (r Repo) Stats(ctx context.Context) (urls int, users int, err error) {
 	rows, err := r.db.QueryContext(ctx, `SELECT id FROM repo WHERE NOT deleted`)
-	if err != nil || rows.Err() != nil {
-		return 0, 0, err
+	if err != nil {
+		return 0, 0, wrapErr(err)
 	}
 	defer rows.Close()
 	userMap := make(map[string]struct{})
@@ -212,11 +278,14 @@ func (r Repo) Stats(ctx context.Context) (urls int, users int, err error) {
 	for rows.Next() {
 		var id string
 		if err := rows.Scan(&id); err != nil {
-			return 0, 0, err
+			return 0, 0, wrapErr(err)
 		}
 		urls++
 		userMap[id] = struct{}{}
 	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, wrapErr(err)
+	}
 
 	return urls, len(userMap), nil
 }
@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage/storagetest"
+)
+
+// testDSNEnv - переменная окружения с DSN тестовой базы данных. Все таблицы хранилища в этой базе
+// пересоздаются перед каждым тестом.
+const testDSNEnv = "TEST_DATABASE_DSN"
+
+// TestConformance прогоняет общий набор тестов хранилища. Тест пропускается, если не задана
+// переменная окружения TEST_DATABASE_DSN.
+func TestConformance(t *testing.T) {
+	dsn, ok := os.LookupEnv(testDSNEnv)
+	if !ok {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		ctx := context.Background()
+		r, err := NewRepo(ctx, dsn)
+		require.NoError(t, err)
+		require.NoError(t, r.destructiveReset(ctx))
+		t.Cleanup(r.Close)
+		return r
+	})
+}
+
+// destructiveReset откатывает все миграции, удаляя таблицы хранилища, и применяет их заново.
+func (r Repo) destructiveReset(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if err := r.MigrateDown(ctx, len(migrations)); err != nil {
+		return err
+	}
+	r.log.Info("all migrations reverted")
+
+	return r.MigrateUp(ctx)
+}
@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+// reaper - сервис, с периодичностью storage.ReapInterval помечающий просроченные записи как удалённые.
+// Сервис завершается при отмене контекста ctx.
+func (r Repo) reaper(ctx context.Context) {
+	ticker := time.NewTicker(storage.ReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.reapExpired(ctx); err != nil && ctx.Err() == nil {
+				r.log.WithError(err).Error("reaper: could not delete expired records")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reapExpired помечает как удалённые все записи, срок действия которых истёк.
+func (r Repo) reapExpired(ctx context.Context) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE repo SET deleted=TRUE WHERE NOT deleted AND expires_at <= now();`)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		r.log.Infof("reaper: %d expired records deleted", n)
+	}
+
+	return nil
+}
+
+// nullTime преобразует время окончания действия записи в значение для столбца expires_at:
+// нулевое время соответствует NULL (срок действия не ограничен).
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,418 @@
+// Пакет storagetest содержит общий набор тестов, которому должна удовлетворять любая реализация
+// интерфейса storage.Storage. Пакет реализации подключает его в своих тестах:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.Storage {
+//			db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+//			require.NoError(t, err)
+//			t.Cleanup(db.Close)
+//			return db
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+)
+
+// Factory создаёт пустое хранилище для очередного теста. Освобождение ресурсов хранилища
+// регистрируется фабрикой через t.Cleanup.
+type Factory func(t *testing.T) storage.Storage
+
+// Run прогоняет набор тестов для хранилища, создаваемого фабрикой newStorage.
+// Каждый тест получает новое пустое хранилище.
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage)
+	}{
+		{name: "Store and Get", fn: testStoreGet},
+		{name: "Lookup", fn: testLookup},
+		{name: "Expiration", fn: testExpiration},
+		{name: "Store duplicate key", fn: testStoreDuplicateKey},
+		{name: "Store duplicate URL", fn: testStoreDuplicateURL},
+		{name: "Store deleted key", fn: testStoreDeletedKey},
+		{name: "Store expired URL", fn: testStoreExpiredURL},
+		{name: "Concurrent Store", fn: testConcurrentStore},
+		{name: "GetAll", fn: testGetAll},
+		{name: "BatchStore", fn: testBatchStore},
+		{name: "BatchStore atomicity", fn: testBatchStoreAtomicity},
+		{name: "BatchDelete by owner", fn: testBatchDeleteByOwner},
+		{name: "BatchDelete by non-owner", fn: testBatchDeleteByNonOwner},
+		{name: "Stats", fn: testStats},
+		{name: "Clicks", fn: testClicks},
+		{name: "Ping", fn: testPing},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newStorage(t))
+		})
+	}
+}
+
+func testStoreGet(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Store(ctx, uuid.New(), storage.Record{Key: "key1", OriginalURL: "http://example.com/1"}))
+
+	url, err := s.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/1", url)
+
+	_, err = s.Get(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testLookup(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	id := uuid.New()
+	want := storage.Record{
+		Key:           "key1",
+		OriginalURL:   "http://example.com/1",
+		ExpiresAt:     time.Now().Add(time.Hour).Truncate(time.Microsecond),
+		CreatedAt:     time.Now().Truncate(time.Microsecond),
+		Title:         "Example",
+		AlwaysPreview: true,
+	}
+	require.NoError(t, s.Store(ctx, id, want))
+	require.NoError(t, s.BatchStore(ctx, id, []storage.Record{{Key: "key2", OriginalURL: "http://example.com/2"}}))
+
+	got, err := s.Lookup(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, want.Key, got.Key)
+	assert.Equal(t, want.OriginalURL, got.OriginalURL)
+	assert.True(t, want.ExpiresAt.Equal(got.ExpiresAt), "expires_at: want %v, got %v", want.ExpiresAt, got.ExpiresAt)
+	assert.True(t, want.CreatedAt.Equal(got.CreatedAt), "created_at: want %v, got %v", want.CreatedAt, got.CreatedAt)
+	assert.Equal(t, want.Title, got.Title)
+	assert.True(t, got.AlwaysPreview)
+
+	got, err = s.Lookup(ctx, "key2")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/2", got.OriginalURL)
+	assert.True(t, got.CreatedAt.IsZero())
+	assert.Empty(t, got.Title)
+	assert.False(t, got.AlwaysPreview)
+
+	_, err = s.Lookup(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, s.BatchDelete(ctx, id, []string{"key2"}))
+	_, err = s.Lookup(ctx, "key2")
+	assert.ErrorIs(t, err, storage.ErrDeleted)
+}
+
+func testExpiration(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	id := uuid.New()
+	require.NoError(t, s.Store(ctx, id, storage.Record{
+		Key:         "key1",
+		OriginalURL: "http://example.com/1",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}))
+	require.NoError(t, s.Store(ctx, id, storage.Record{
+		Key:         "key2",
+		OriginalURL: "http://example.com/2",
+		ExpiresAt:   time.Now().Add(-time.Second),
+	}))
+	require.NoError(t, s.BatchStore(ctx, id, []storage.Record{
+		{Key: "key3", OriginalURL: "http://example.com/3", ExpiresAt: time.Now().Add(-time.Second)},
+	}))
+
+	url, err := s.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/1", url)
+	_, err = s.Get(ctx, "key2")
+	assert.ErrorIs(t, err, storage.ErrExpired)
+	_, err = s.Get(ctx, "key3")
+	assert.ErrorIs(t, err, storage.ErrExpired)
+}
+
+func testStoreDuplicateKey(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Store(ctx, uuid.New(), storage.Record{Key: "key1", OriginalURL: "http://example.com/1"}))
+	assert.ErrorIs(t, s.Store(ctx, uuid.New(), storage.Record{Key: "key1", OriginalURL: "http://example.com/2"}), storage.ErrKeyCollision)
+
+	url, err := s.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/1", url, "the first record must not be overwritten")
+}
+
+func testStoreDuplicateURL(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Store(ctx, uuid.New(), storage.Record{Key: "key1", OriginalURL: "http://example.com/1"}))
+
+	err := s.Store(ctx, uuid.New(), storage.Record{Key: "key2", OriginalURL: "http://example.com/1"})
+	var errURLAlreadyExists *storage.ErrURLArlreadyExists
+	require.True(t, errors.As(err, &errURLAlreadyExists), "expected ErrURLArlreadyExists, got %v", err)
+	assert.Equal(t, "key1", errURLAlreadyExists.Key)
+	assert.Equal(t, "http://example.com/1", errURLAlreadyExists.URL)
+
+	_, err = s.Get(ctx, "key2")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testStoreDeletedKey(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	id := uuid.New()
+	require.NoError(t, s.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "http://example.com/1"}))
+	require.NoError(t, s.BatchDelete(ctx, id, []string{"key1"}))
+
+	// ключ удалённой записи повторно не используется, а её URL можно сохранить снова
+	assert.ErrorIs(t, s.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "http://example.com/2"}), storage.ErrKeyCollision)
+	assert.NoError(t, s.Store(ctx, id, storage.Record{Key: "key2", OriginalURL: "http://example.com/1"}))
+}
+
+// testStoreExpiredURL проверяет, что URL просроченной записи можно сохранить заново, не дожидаясь reaper.
+func testStoreExpiredURL(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	id := uuid.New()
+	expired := time.Now().Add(-time.Second)
+	require.NoError(t, s.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "http://example.com/1", ExpiresAt: expired}))
+	require.NoError(t, s.Store(ctx, id, storage.Record{Key: "key2", OriginalURL: "http://example.com/2", ExpiresAt: expired}))
+
+	require.NoError(t, s.Store(ctx, id, storage.Record{Key: "key3", OriginalURL: "http://example.com/1"}))
+	require.NoError(t, s.BatchStore(ctx, id, []storage.Record{{Key: "key4", OriginalURL: "http://example.com/2"}}))
+	assert.Equal(t, map[string]string{"key3": "http://example.com/1", "key4": "http://example.com/2"}, s.GetAll(ctx, id))
+	for _, key := range []string{"key1", "key2"} {
+		_, err := s.Get(ctx, key)
+		assert.True(t, errors.Is(err, storage.ErrExpired) || errors.Is(err, storage.ErrDeleted), "%s: unexpected error %v", key, err)
+	}
+}
+
+// testConcurrentStore проверяет, что из параллельных вызовов Store с одним и тем же ключом (или URL)
+// успешно завершается ровно один, а остальные получают ошибку соответствующего типа.
+func testConcurrentStore(t *testing.T, s storage.Storage) {
+	const (
+		rounds  = 20
+		workers = 32
+	)
+	ctx := context.Background()
+
+	run := func(rec func(i int) storage.Record) []error {
+		errs := make([]error, workers)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				errs[i] = s.Store(ctx, uuid.New(), rec(i))
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+		return errs
+	}
+
+	for r := 0; r < rounds; r++ {
+		errs := run(func(i int) storage.Record {
+			return storage.Record{
+				Key:         fmt.Sprintf("key%d", r),
+				OriginalURL: fmt.Sprintf("http://example.com/%d/%d", r, i),
+			}
+		})
+		stored := 0
+		for _, err := range errs {
+			if err == nil {
+				stored++
+				continue
+			}
+			assert.ErrorIs(t, err, storage.ErrKeyCollision)
+		}
+		require.Equal(t, 1, stored, "exactly one record with the same key must be stored")
+	}
+
+	for r := 0; r < rounds; r++ {
+		errs := run(func(i int) storage.Record {
+			return storage.Record{
+				Key:         fmt.Sprintf("url%d-%d", r, i),
+				OriginalURL: fmt.Sprintf("http://example.com/same/%d", r),
+			}
+		})
+		stored := 0
+		winner := ""
+		for i, err := range errs {
+			if err == nil {
+				stored++
+				winner = fmt.Sprintf("url%d-%d", r, i)
+			}
+		}
+		require.Equal(t, 1, stored, "exactly one record with the same URL must be stored")
+		for _, err := range errs {
+			if err == nil {
+				continue
+			}
+			var errURLAlreadyExists *storage.ErrURLArlreadyExists
+			require.True(t, errors.As(err, &errURLAlreadyExists), "expected ErrURLArlreadyExists, got %v", err)
+			assert.Equal(t, winner, errURLAlreadyExists.Key)
+		}
+	}
+}
+
+func testGetAll(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	id1, id2 := uuid.New(), uuid.New()
+	require.NoError(t, s.Store(ctx, id1, storage.Record{Key: "key1", OriginalURL: "http://example.com/1"}))
+	require.NoError(t, s.Store(ctx, id1, storage.Record{Key: "key2", OriginalURL: "http://example.com/2"}))
+	require.NoError(t, s.Store(ctx, id2, storage.Record{Key: "key3", OriginalURL: "http://example.com/3"}))
+
+	assert.Equal(t, map[string]string{
+		"key1": "http://example.com/1",
+		"key2": "http://example.com/2",
+	}, s.GetAll(ctx, id1))
+	assert.Equal(t, map[string]string{"key3": "http://example.com/3"}, s.GetAll(ctx, id2))
+
+	list := s.GetAll(ctx, uuid.New())
+	assert.NotNil(t, list)
+	assert.Empty(t, list)
+}
+
+func testBatchStore(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	id := uuid.New()
+	require.NoError(t, s.BatchStore(ctx, id, []storage.Record{
+		{CorellationID: "1", Key: "key1", OriginalURL: "http://example.com/1"},
+		{CorellationID: "2", Key: "key2", OriginalURL: "http://example.com/2"},
+	}))
+
+	assert.Equal(t, map[string]string{
+		"key1": "http://example.com/1",
+		"key2": "http://example.com/2",
+	}, s.GetAll(ctx, id))
+}
+
+func testBatchStoreAtomicity(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	id := uuid.New()
+	require.NoError(t, s.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "http://example.com/1"}))
+
+	// один из URL уже сохранён - не должна сохраниться ни одна запись пакета
+	err := s.BatchStore(ctx, id, []storage.Record{
+		{Key: "key2", OriginalURL: "http://example.com/2"},
+		{Key: "key3", OriginalURL: "http://example.com/1"},
+	})
+	assert.ErrorIs(t, err, storage.ErrBatchURLUniqueViolation)
+
+	// URL повторяется внутри пакета
+	err = s.BatchStore(ctx, id, []storage.Record{
+		{Key: "key4", OriginalURL: "http://example.com/4"},
+		{Key: "key5", OriginalURL: "http://example.com/4"},
+	})
+	assert.ErrorIs(t, err, storage.ErrBatchURLUniqueViolation)
+
+	// ключ уже занят
+	err = s.BatchStore(ctx, id, []storage.Record{
+		{Key: "key6", OriginalURL: "http://example.com/6"},
+		{Key: "key1", OriginalURL: "http://example.com/7"},
+	})
+	assert.ErrorIs(t, err, storage.ErrKeyCollision)
+
+	assert.Equal(t, map[string]string{"key1": "http://example.com/1"}, s.GetAll(ctx, id))
+	for _, key := range []string{"key2", "key3", "key4", "key5", "key6"} {
+		_, err := s.Get(ctx, key)
+		assert.ErrorIs(t, err, storage.ErrNotFound, "key %s must not be stored", key)
+	}
+}
+
+func testBatchDeleteByOwner(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	id := uuid.New()
+	require.NoError(t, s.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "http://example.com/1"}))
+	require.NoError(t, s.Store(ctx, id, storage.Record{Key: "key2", OriginalURL: "http://example.com/2"}))
+
+	require.NoError(t, s.BatchDelete(ctx, id, []string{"key1", "missing"}))
+
+	_, err := s.Get(ctx, "key1")
+	assert.ErrorIs(t, err, storage.ErrDeleted)
+	assert.Equal(t, map[string]string{"key2": "http://example.com/2"}, s.GetAll(ctx, id))
+
+	// URL удалённой записи можно сократить заново, а ключ остаётся занятым
+	assert.NoError(t, s.Store(ctx, id, storage.Record{Key: "key3", OriginalURL: "http://example.com/1"}))
+	assert.ErrorIs(t, s.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "http://example.com/4"}), storage.ErrKeyCollision)
+}
+
+func testBatchDeleteByNonOwner(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	owner, other := uuid.New(), uuid.New()
+	require.NoError(t, s.Store(ctx, owner, storage.Record{Key: "key1", OriginalURL: "http://example.com/1"}))
+
+	require.NoError(t, s.BatchDelete(ctx, other, []string{"key1"}))
+
+	url, err := s.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/1", url)
+	assert.Equal(t, map[string]string{"key1": "http://example.com/1"}, s.GetAll(ctx, owner))
+}
+
+func testStats(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	id1, id2, id3 := uuid.New(), uuid.New(), uuid.New()
+
+	urls, users, err := s.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, urls)
+	assert.Equal(t, 0, users)
+
+	require.NoError(t, s.Store(ctx, id1, storage.Record{Key: "key1", OriginalURL: "http://example.com/1"}))
+	require.NoError(t, s.Store(ctx, id1, storage.Record{Key: "key2", OriginalURL: "http://example.com/2"}))
+	require.NoError(t, s.Store(ctx, id2, storage.Record{Key: "key3", OriginalURL: "http://example.com/3"}))
+	require.NoError(t, s.Store(ctx, id3, storage.Record{Key: "key4", OriginalURL: "http://example.com/4"}))
+	// удалённые записи и пользователи, у которых не осталось записей, не учитываются
+	require.NoError(t, s.BatchDelete(ctx, id1, []string{"key1"}))
+	require.NoError(t, s.BatchDelete(ctx, id3, []string{"key4"}))
+
+	urls, users, err = s.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, urls)
+	assert.Equal(t, 2, users)
+}
+
+func testClicks(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	id := uuid.New()
+	require.NoError(t, s.Store(ctx, id, storage.Record{Key: "key1", OriginalURL: "http://example.com/1"}))
+	require.NoError(t, s.Store(ctx, id, storage.Record{Key: "key2", OriginalURL: "http://example.com/2"}))
+
+	day1 := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2022, 5, 3, 23, 59, 0, 0, time.UTC)
+	require.NoError(t, s.StoreClicks(ctx, []storage.Click{
+		{Key: "key1", Time: day2, Referrer: "http://referrer.com", UserAgent: "test", IP: "127.0.0.0"},
+		{Key: "key1", Time: day1},
+		{Key: "missing", Time: day1}, // переход по отсутствующему ключу игнорируется
+		{Key: "key1", Time: day1.Add(time.Hour)},
+	}))
+
+	stats, err := s.ClickStats(ctx, id, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Total)
+	require.Len(t, stats.Daily, 2)
+	assert.True(t, stats.Daily[0].Date.Equal(time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 2, stats.Daily[0].Clicks)
+	assert.True(t, stats.Daily[1].Date.Equal(time.Date(2022, 5, 3, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 1, stats.Daily[1].Clicks)
+
+	stats, err = s.ClickStats(ctx, id, "key2")
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Total)
+	assert.Empty(t, stats.Daily)
+
+	// статистика доступна только создателю ссылки
+	_, err = s.ClickStats(ctx, uuid.New(), "key1")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.ClickStats(ctx, id, "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testPing(t *testing.T, s storage.Storage) {
+	assert.NoError(t, s.Ping())
+}
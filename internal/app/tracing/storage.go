@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ storage.Storage = (*tracedStorage)(nil)
+
+// tracedStorage оборачивает хранилище и создаёт спан для каждой операции с контекстом.
+type tracedStorage struct {
+	db storage.Storage
+}
+
+// InstrumentStorage возвращает хранилище, делегирующее все вызовы db и создающее для каждого из них
+// дочерний спан спана из контекста вызова.
+func InstrumentStorage(db storage.Storage) storage.Storage {
+	return tracedStorage{db: db}
+}
+
+// start создаёт спан операции хранилища op.
+func start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, "storage."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// end завершает спан, записывая в атрибут storage.outcome исход операции (см. storage.Outcome).
+// Статус Error устанавливается только при сбое хранилища; ожидаемые исходы (ключ не найден, удалён
+// или занят) записываются событием.
+func end(span trace.Span, err error) {
+	outcome := storage.Outcome(err)
+	span.SetAttributes(attribute.String("storage.outcome", outcome))
+	switch outcome {
+	case storage.OutcomeOK:
+	case storage.OutcomeUnavailable, storage.OutcomeError:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	default:
+		span.AddEvent(outcome, trace.WithAttributes(attribute.String("message", err.Error())))
+	}
+	span.End()
+}
+
+func (s tracedStorage) Store(ctx context.Context, id uuid.UUID, rec storage.Record) (err error) {
+	ctx, span := start(ctx, "Store", attribute.String("key", rec.Key))
+	defer func() { end(span, err) }()
+
+	return s.db.Store(ctx, id, rec)
+}
+
+func (s tracedStorage) Get(ctx context.Context, key string) (url string, err error) {
+	ctx, span := start(ctx, "Get", attribute.String("key", key))
+	defer func() { end(span, err) }()
+
+	return s.db.Get(ctx, key)
+}
+
+func (s tracedStorage) Lookup(ctx context.Context, key string) (rec storage.Record, err error) {
+	ctx, span := start(ctx, "Lookup", attribute.String("key", key))
+	defer func() { end(span, err) }()
+
+	return s.db.Lookup(ctx, key)
+}
+
+func (s tracedStorage) GetAll(ctx context.Context, id uuid.UUID) map[string]string {
+	ctx, span := start(ctx, "GetAll")
+	defer span.End()
+
+	return s.db.GetAll(ctx, id)
+}
+
+func (s tracedStorage) BatchStore(ctx context.Context, id uuid.UUID, records []storage.Record) (err error) {
+	ctx, span := start(ctx, "BatchStore", attribute.Int("records", len(records)))
+	defer func() { end(span, err) }()
+
+	return s.db.BatchStore(ctx, id, records)
+}
+
+func (s tracedStorage) BatchDelete(ctx context.Context, id uuid.UUID, keys []string) (err error) {
+	ctx, span := start(ctx, "BatchDelete", attribute.Int("keys", len(keys)))
+	defer func() { end(span, err) }()
+
+	return s.db.BatchDelete(ctx, id, keys)
+}
+
+func (s tracedStorage) Stats(ctx context.Context) (urls int, users int, err error) {
+	ctx, span := start(ctx, "Stats")
+	defer func() { end(span, err) }()
+
+	return s.db.Stats(ctx)
+}
+
+func (s tracedStorage) StoreClicks(ctx context.Context, clicks []storage.Click) (err error) {
+	ctx, span := start(ctx, "StoreClicks", attribute.Int("clicks", len(clicks)))
+	defer func() { end(span, err) }()
+
+	return s.db.StoreClicks(ctx, clicks)
+}
+
+func (s tracedStorage) ClickStats(ctx context.Context, id uuid.UUID, key string) (st storage.ClickStats, err error) {
+	ctx, span := start(ctx, "ClickStats", attribute.String("key", key))
+	defer func() { end(span, err) }()
+
+	return s.db.ClickStats(ctx, id, key)
+}
+
+func (s tracedStorage) Ping() error {
+	return s.db.Ping()
+}
+
+func (s tracedStorage) Close() {
+	s.db.Close()
+}
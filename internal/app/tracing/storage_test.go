@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/storage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// getStorage - хранилище, метод Get которого возвращает заданную ошибку.
+type getStorage struct {
+	storage.Storage
+	err error
+}
+
+func (s getStorage) Get(ctx context.Context, key string) (string, error) {
+	return "http://example.com", s.err
+}
+
+// TestInstrumentStorage проверяет, что статус Error устанавливается только при сбоях хранилища,
+// а ожидаемые исходы операций записываются в атрибут storage.outcome и событием.
+func TestInstrumentStorage(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(prev)
+
+	tt := []struct {
+		name       string
+		err        error
+		outcome    string
+		wantStatus codes.Code
+		wantEvent  string
+	}{
+		{name: "OK", err: nil, outcome: storage.OutcomeOK, wantStatus: codes.Unset},
+		{name: "Not found", err: fmt.Errorf("DB: key: %w", storage.ErrNotFound), outcome: storage.OutcomeNotFound,
+			wantStatus: codes.Unset, wantEvent: storage.OutcomeNotFound},
+		{name: "Expired", err: storage.ErrExpired, outcome: storage.OutcomeGone, wantStatus: codes.Unset, wantEvent: storage.OutcomeGone},
+		{name: "URL already exists", err: &storage.ErrURLArlreadyExists{Key: "key", URL: "url"}, outcome: storage.OutcomeConflict,
+			wantStatus: codes.Unset, wantEvent: storage.OutcomeConflict},
+		{name: "Unavailable", err: &storage.UnavailableError{Err: errors.New("connection refused")}, outcome: storage.OutcomeUnavailable,
+			wantStatus: codes.Error, wantEvent: "exception"},
+		{name: "Other error", err: errors.New("disk is full"), outcome: storage.OutcomeError, wantStatus: codes.Error, wantEvent: "exception"},
+	}
+	for i, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := InstrumentStorage(getStorage{err: tc.err})
+			_, err := db.Get(context.Background(), "key")
+			assert.Equal(t, tc.err, err)
+
+			spans := recorder.Ended()
+			require.Len(t, spans, i+1)
+			span := spans[i]
+			assert.Equal(t, "storage.Get", span.Name())
+			assert.Equal(t, tc.wantStatus, span.Status().Code)
+			assert.Contains(t, span.Attributes(), attribute.String("storage.outcome", tc.outcome))
+			if tc.wantEvent == "" {
+				assert.Empty(t, span.Events())
+				return
+			}
+			require.Len(t, span.Events(), 1)
+			assert.Equal(t, tc.wantEvent, span.Events()[0].Name)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+// Пакет tracing настраивает трассировку OpenTelemetry и экспорт спанов по протоколу OTLP или в stdout.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Экспортёры спанов.
+const (
+	ExporterNone   = ""
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+const (
+	// instrumentationName - имя, под которым сервис создаёт спаны.
+	instrumentationName = "github.com/vanamelnik/go-musthave-shortener"
+	// serviceName - имя сервиса в ресурсе спанов.
+	serviceName = "shortener"
+	// exportTimeout - таймаут отправки пакета спанов коллектору.
+	exportTimeout = 10 * time.Second
+	// tracesPath - путь приёма спанов коллектором OTLP/HTTP.
+	tracesPath = "/v1/traces"
+)
+
+// Tracer возвращает трассировщик сервиса. До вызова Setup спаны не записываются.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Setup создаёт экспортёр exporter (stdout или otlp), устанавливает глобальный провайдер трассировки
+// и распространение контекста в формате W3C Trace Context. Для экспортёра otlp спаны отправляются
+// по OTLP/HTTP на адрес endpoint (например, http://localhost:4318).
+// Возвращаемая функция shutdown отправляет накопленные спаны и останавливает провайдер.
+// Если exporter не задан, трассировка отключена и возвращается shutdown, не выполняющая действий.
+func Setup(exporter, endpoint string) (shutdown func(context.Context) error, err error) {
+	var exp sdktrace.SpanExporter
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		exp, err = newOTLPExporter(endpoint)
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return tp.Shutdown, nil
+}
+
+// newOTLPExporter создаёт экспортёр OTLP/HTTP для коллектора с адресом endpoint (схема, хост, порт
+// и необязательный префикс пути). Спаны отправляются на <endpoint>/v1/traces.
+func newOTLPExporter(endpoint string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %w", err)
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(path.Join("/", u.Path, tracesPath)),
+		otlptracehttp.WithTimeout(exportTimeout),
+	}
+	switch u.Scheme {
+	case "http":
+		opts = append(opts, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: scheme must be http or https", endpoint)
+	}
+
+	return otlptracehttp.New(context.Background(), opts...)
+}
@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSetupOTLP(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []*coltracepb.ExportTraceServiceRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/otel"+tracesPath, r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var req coltracepb.ExportTraceServiceRequest
+		require.NoError(t, proto.Unmarshal(body, &req))
+		mu.Lock()
+		requests = append(requests, &req)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+
+	shutdown, err := Setup(ExporterOTLP, collector.URL+"/otel/")
+	require.NoError(t, err)
+	ctx, parent := Tracer().Start(context.Background(), "parent")
+	_, child := Tracer().Start(ctx, "child")
+	child.End()
+	parent.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, requests, 1)
+	require.Len(t, requests[0].ResourceSpans, 1)
+	rs := requests[0].ResourceSpans[0]
+	var service string
+	for _, kv := range rs.Resource.Attributes {
+		if kv.Key == "service.name" {
+			service = kv.Value.GetStringValue()
+		}
+	}
+	assert.Equal(t, serviceName, service)
+	require.Len(t, rs.ScopeSpans, 1)
+	assert.Equal(t, instrumentationName, rs.ScopeSpans[0].Scope.Name)
+	spans := rs.ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, spans[1].SpanId, spans[0].ParentSpanId)
+}
+
+func TestSetupErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		exporter string
+		endpoint string
+	}{
+		{name: "Unknown exporter", exporter: "jaeger", endpoint: "http://localhost:4318"},
+		{name: "Endpoint without scheme", exporter: ExporterOTLP, endpoint: "localhost:4318"},
+		{name: "Unsupported scheme", exporter: ExporterOTLP, endpoint: "grpc://localhost:4317"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Setup(tc.exporter, tc.endpoint)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/google/uuid"
+	appContext "github.com/vanamelnik/go-musthave-shortener/internal/app/context"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Ключи метаданных gRPC с идентификатором пользователя и его токеном. Совпадают с именами кук CookieMdlw.
+const (
+	UserIDMetadataKey = "uuid"
+	TokenMetadataKey  = "token"
+)
+
+// Signer подписывает идентификаторы пользователей: токен представляет собой uuid, симметрично хэшированный
+// секретным ключом по алгоритму SHA256, в шестнадцатеричной записи. Используется CookieMdlw и AuthInterceptor,
+// поэтому токен, выданный REST API, принимается gRPC-сервером и наоборот.
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner создаёт Signer с секретным ключом secret.
+func NewSigner(secret string) Signer {
+	return Signer{secret: []byte(secret)}
+}
+
+// Sign возвращает токен пользователя id.
+func (s Signer) Sign(id uuid.UUID) string {
+	return hex.EncodeToString(s.sum(id))
+}
+
+// Verify проверяет, что token - токен пользователя id.
+func (s Signer) Verify(id uuid.UUID, token string) bool {
+	sign, err := hex.DecodeString(token)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(s.sum(id), sign)
+}
+
+func (s Signer) sum(id uuid.UUID) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(id.String()))
+
+	return h.Sum(nil)
+}
+
+// AuthInterceptor - gRPC-интерсептор, аналогичный CookieMdlw: аутентифицирует вызовы методов, работающих
+// с данными пользователя (запросы с полем user_id), по метаданным uuid и token и добавляет идентификатор
+// пользователя в контекст вызова (context.WithID) и в логгер вызова.
+//
+// Если метаданные не переданы, создаётся новый пользователь, идентификатор и токен которого возвращаются
+// в заголовке ответа. Неверный токен отклоняется с кодом Unauthenticated, а поле user_id запроса,
+// не совпадающее с идентификатором аутентифицированного пользователя, - с кодом PermissionDenied.
+func AuthInterceptor(secret string) grpc.UnaryServerInterceptor {
+	signer := NewSigner(secret)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		r, ok := req.(interface{ GetUserId() string })
+		if !ok {
+			return handler(ctx, req)
+		}
+		log := logger.FromContext(ctx)
+		id, err := authenticate(ctx, signer)
+		if err != nil {
+			log.WithError(err).Info("AuthInterceptor: authentication failed")
+
+			return nil, err
+		}
+		if id == uuid.Nil {
+			if id, err = GenerateUserID(); err != nil {
+				log.WithError(err).Error("AuthInterceptor: cannot generate an uuid")
+
+				return nil, status.Error(codes.Internal, "cannot generate user ID")
+			}
+			// nolint:errcheck
+			grpc.SetHeader(ctx, metadata.Pairs(UserIDMetadataKey, id.String(), TokenMetadataKey, signer.Sign(id)))
+			log.WithField(logger.FieldUserID, id).Debug("AuthInterceptor: created new session")
+		}
+		if userID := r.GetUserId(); userID != "" && userID != id.String() {
+			log.WithField(logger.FieldUserID, id).Warnf("AuthInterceptor: user_id %q does not match the token", userID)
+
+			return nil, status.Error(codes.PermissionDenied, "user_id does not match the authenticated user")
+		}
+
+		ctx = appContext.WithID(ctx, id)
+		ctx = logger.WithContext(ctx, log.WithField(logger.FieldUserID, id))
+
+		return handler(ctx, req)
+	}
+}
+
+// authenticate проверяет метаданные uuid и token вызова. Если метаданные не переданы, возвращается uuid.Nil.
+func authenticate(ctx context.Context, signer Signer) (uuid.UUID, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ids, tokens := md.Get(UserIDMetadataKey), md.Get(TokenMetadataKey)
+	if len(ids) == 0 && len(tokens) == 0 {
+		return uuid.Nil, nil
+	}
+	if len(ids) != 1 || len(tokens) != 1 {
+		return uuid.Nil, status.Error(codes.Unauthenticated, "both uuid and token metadata are required")
+	}
+	id, err := uuid.Parse(ids[0])
+	if err != nil || !signer.Verify(id, tokens[0]) {
+		return uuid.Nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	return id, nil
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appContext "github.com/vanamelnik/go-musthave-shortener/internal/app/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testSecret = "secret"
+
+func TestSigner(t *testing.T) {
+	signer := NewSigner(testSecret)
+	id := uuid.New()
+	token := signer.Sign(id)
+
+	assert.True(t, signer.Verify(id, token))
+	assert.False(t, signer.Verify(uuid.New(), token), "token of another user")
+	assert.False(t, NewSigner("other").Verify(id, token), "token signed with another key")
+	assert.False(t, signer.Verify(id, "not a hex string"))
+
+	// токен из куки CookieMdlw принимается Signer
+	w := httptest.NewRecorder()
+	CookieMdlw(testSecret)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).
+		ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := make(map[string]string)
+	for _, c := range w.Result().Cookies() {
+		cookies[c.Name] = c.Value
+	}
+	cookieID, err := uuid.Parse(cookies["uuid"])
+	require.NoError(t, err)
+	assert.True(t, signer.Verify(cookieID, cookies["token"]))
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	signer := NewSigner(testSecret)
+	id, other := uuid.New(), uuid.New()
+	tests := []struct {
+		name   string
+		md     metadata.MD
+		req    interface{}
+		code   codes.Code
+		wantID uuid.UUID
+		// newID - ожидается новый пользователь
+		newID bool
+	}{
+		{
+			name: "Request without user ID is not authenticated",
+			req:  struct{}{},
+		},
+		{
+			name:  "No credentials - new user",
+			req:   userIDRequest{},
+			newID: true,
+		},
+		{
+			name:   "Valid credentials",
+			md:     metadata.Pairs(UserIDMetadataKey, id.String(), TokenMetadataKey, signer.Sign(id)),
+			req:    userIDRequest{},
+			wantID: id,
+		},
+		{
+			name:   "Valid credentials and matching user_id",
+			md:     metadata.Pairs(UserIDMetadataKey, id.String(), TokenMetadataKey, signer.Sign(id)),
+			req:    userIDRequest{userID: id.String()},
+			wantID: id,
+		},
+		{
+			name: "Valid credentials and user_id of another user",
+			md:   metadata.Pairs(UserIDMetadataKey, id.String(), TokenMetadataKey, signer.Sign(id)),
+			req:  userIDRequest{userID: other.String()},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "No credentials and user_id of existing user",
+			req:  userIDRequest{userID: other.String()},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "Token of another user",
+			md:   metadata.Pairs(UserIDMetadataKey, id.String(), TokenMetadataKey, signer.Sign(other)),
+			req:  userIDRequest{},
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "Token without user ID",
+			md:   metadata.Pairs(TokenMetadataKey, signer.Sign(id)),
+			req:  userIDRequest{},
+			code: codes.Unauthenticated,
+		},
+	}
+	interceptor := AuthInterceptor(testSecret)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tc.md)
+			var gotID uuid.UUID
+			called := false
+			_, err := interceptor(ctx, tc.req, &grpc.UnaryServerInfo{FullMethod: "/test/Method"},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					called = true
+					gotID, _ = appContext.ID(ctx)
+
+					return nil, nil
+				})
+			assert.Equal(t, tc.code, status.Code(err))
+			assert.Equal(t, tc.code == codes.OK, called, "handler must be called only for authenticated requests")
+			if tc.newID {
+				assert.NotEqual(t, uuid.Nil, gotID)
+			} else {
+				assert.Equal(t, tc.wantID, gotID)
+			}
+		})
+	}
+}
@@ -1,32 +1,30 @@
 package middleware
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
-	"hash"
-	"log"
 	"net/http"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
 	"github.com/vanamelnik/go-musthave-shortener/internal/app/context"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
 )
 
 // CookieMdlw проверяет в http request наличие cookie с полями uuid и token и добавляет в контекст запроса поле "uuid".
 // Если отсутствует поле uuid, пользователю присваивается уникальный идентификатор, которым помечаются все записи
-// в хранилище, сделанные данным пользователем. Токен представляет собой uuid, симметрично хэшированный секретным ключом по алгоритму SHA256.
-// При неверном токене создается новая кука.
+// в хранилище, сделанные данным пользователем. Токен представляет собой uuid, подписанный Signer с секретным ключом secret.
+// При неверном токене создается новая кука. В логгер запроса добавляется поле user_id.
 func CookieMdlw(secret string) mux.MiddlewareFunc {
+	signer := NewSigner(secret)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var id uuid.UUID
-			h := hmac.New(sha256.New, []byte(secret))
+			log := logger.FromContext(r.Context())
 
-			id, ok := analyseCookies(r, h)
+			id, ok := analyseCookies(r, signer)
 			if !ok {
 				var err error // определяем, чтобы избежать локального переопределения id
-				id, err = newSession(w, h)
+				id, err = newSession(w, signer, log)
 				if err != nil {
 					http.Error(w, "Something went wrong: cannot generate uuid", http.StatusInternalServerError)
 
@@ -35,6 +33,7 @@ func CookieMdlw(secret string) mux.MiddlewareFunc {
 			}
 
 			ctx := context.WithID(r.Context(), id)
+			ctx = logger.WithContext(ctx, log.WithField(logger.FieldUserID, id))
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -42,7 +41,7 @@ func CookieMdlw(secret string) mux.MiddlewareFunc {
 
 // analyseCookies проверяет наличие кук uuid и token, а также валидность их значений.
 // значение ok == false указывает на необходимость создания новой сессии.
-func analyseCookies(r *http.Request, h hash.Hash) (uuid.UUID, bool) {
+func analyseCookies(r *http.Request, signer Signer) (uuid.UUID, bool) {
 	// проверяем наличие куки uuid
 	cookie, err := r.Cookie("uuid")
 	if err != nil {
@@ -61,36 +60,25 @@ func analyseCookies(r *http.Request, h hash.Hash) (uuid.UUID, bool) {
 		return uuid.Nil, false
 	}
 
-	// раскодируем токен
-	token, err := hex.DecodeString(cookie.Value)
-	if err != nil {
-		return uuid.Nil, false
-	}
-
 	// проверяем совпадение подписи id с переданным токеном
-	h.Write([]byte(id.String()))
-	if !hmac.Equal(h.Sum(nil), token) {
-		h.Reset()
+	if !signer.Verify(id, cookie.Value) {
 		return uuid.Nil, false
 	}
 
-	log.Printf("CookieMdlw: successfully authenticated: id=%s", id)
-
 	return id, true
 }
 
 // newSession создает новые uuid и токен пользователя, сохраняет их в cookie.
 // Возвращает ошибку в маловероятном случае сбоя генерации нового uuid.
-func newSession(w http.ResponseWriter, h hash.Hash) (uuid.UUID, error) {
+func newSession(w http.ResponseWriter, signer Signer, log logrus.FieldLogger) (uuid.UUID, error) {
 	id, err := GenerateUserID()
 	if err != nil {
-		log.Printf("CookieMdlw: cannot generate an uuid: %v", err)
+		log.WithError(err).Error("CookieMdlw: cannot generate an uuid")
 		return uuid.Nil, err
 	}
 
-	h.Write([]byte(id.String()))
-	token := hex.EncodeToString(h.Sum(nil))
-	log.Printf("CookieMdlw: successfully created new session id=%s", id)
+	token := signer.Sign(id)
+	log.WithField(logger.FieldUserID, id).Debug("CookieMdlw: created new session")
 
 	http.SetCookie(w, &http.Cookie{Name: "uuid", Path: "/", Value: id.String()})
 	http.SetCookie(w, &http.Cookie{Name: "token", Path: "/", Value: token})
@@ -98,12 +86,7 @@ func newSession(w http.ResponseWriter, h hash.Hash) (uuid.UUID, error) {
 	return id, nil
 }
 
+// GenerateUserID генерирует новый идентификатор пользователя.
 func GenerateUserID() (uuid.UUID, error) {
-	id, err := uuid.NewRandom()
-	if err != nil {
-		log.Printf("CookieMdlw: cannot generate an uuid: %v", err)
-		return uuid.Nil, err
-	}
-
-	return id, nil
+	return uuid.NewRandom()
 }
@@ -3,9 +3,10 @@ package middleware
 import (
 	"compress/gzip"
 	"io"
-	"log"
 	"net/http"
 	"strings"
+
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
 )
 
 type gzipReadCloser struct {
@@ -43,7 +44,7 @@ func GzipMdlw(next http.Handler) http.Handler {
 		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
 			gr, err := gzip.NewReader(r.Body)
 			if err != nil {
-				log.Printf("gzipHandle: %v", err)
+				logger.FromContext(r.Context()).WithError(err).Error("GzipMdlw: could not decompress the request")
 				http.Error(w, "Something went wrong", http.StatusInternalServerError)
 
 				return
@@ -60,7 +61,7 @@ func GzipMdlw(next http.Handler) http.Handler {
 		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
 			gw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
 			if err != nil {
-				log.Printf("gzipHandle: %v", err)
+				logger.FromContext(r.Context()).WithError(err).Error("GzipMdlw: could not compress the response")
 				http.Error(w, "Something went wrong", http.StatusInternalServerError)
 				return
 			}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// RequestIDHeader - заголовок с идентификатором запроса.
+	RequestIDHeader = "X-Request-ID"
+	// maxRequestIDLength - максимальная длина идентификатора запроса, принимаемого от клиента.
+	maxRequestIDLength = 128
+)
+
+// statusWriter подменяет собой http.ResponseWriter и запоминает код ответа и размер тела ответа.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(data []byte) (int, error) {
+	n, err := sw.ResponseWriter.Write(data)
+	sw.bytes += n
+
+	return n, err
+}
+
+// LoggerMdlw добавляет в контекст запроса логгер с полем request_id и по завершении обработки запроса
+// записывает в лог его метод, путь, код и время ответа. Идентификатор запроса берётся из заголовка
+// X-Request-ID, а если его нет или он некорректен - генерируется; он возвращается клиенту в том же заголовке.
+// Middleware должна вызываться первой, чтобы логгер запроса был доступен остальным middleware.
+func LoggerMdlw(log logrus.FieldLogger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			requestID := r.Header.Get(RequestIDHeader)
+			if !validRequestID(requestID) {
+				requestID = uuid.NewString()
+			}
+			w.Header().Set(RequestIDHeader, requestID)
+			reqLog := withTraceID(r.Context(), log.WithField(logger.FieldRequestID, requestID))
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r.WithContext(logger.WithContext(r.Context(), reqLog)))
+
+			reqLog.WithFields(logrus.Fields{
+				"method":   r.Method,
+				"path":     r.URL.Path,
+				"status":   sw.status,
+				"bytes":    sw.bytes,
+				"duration": time.Since(start).String(),
+			}).Info("request completed")
+		})
+	}
+}
+
+// LoggerInterceptor - gRPC-интерсептор, аналогичный LoggerMdlw: добавляет в контекст вызова логгер с полями
+// request_id, method и user_id (если запрос содержит поле user_id) и записывает в лог результат вызова.
+// Идентификатор запроса берётся из метаданных x-request-id или генерируется и возвращается в заголовке ответа.
+func LoggerInterceptor(log logrus.FieldLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		var requestID string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if ids := md.Get(RequestIDHeader); len(ids) > 0 {
+				requestID = ids[0]
+			}
+		}
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		// nolint:errcheck
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, requestID))
+		reqLog := withTraceID(ctx, log.WithFields(logrus.Fields{
+			logger.FieldRequestID: requestID,
+			"method":              info.FullMethod,
+		}))
+		if r, ok := req.(interface{ GetUserId() string }); ok && r.GetUserId() != "" {
+			reqLog = reqLog.WithField(logger.FieldUserID, r.GetUserId())
+		}
+
+		resp, err := handler(logger.WithContext(ctx, reqLog), req)
+
+		reqLog.WithFields(logrus.Fields{
+			"code":     status.Code(err).String(),
+			"duration": time.Since(start).String(),
+		}).Info("call completed")
+
+		return resp, err
+	}
+}
+
+// withTraceID добавляет к логгеру идентификатор трассы из контекста, если запрос трассируется.
+func withTraceID(ctx context.Context, log logrus.FieldLogger) logrus.FieldLogger {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return log.WithField(logger.FieldTraceID, sc.TraceID().String())
+	}
+
+	return log
+}
+
+// validRequestID проверяет, что идентификатор запроса не пуст, не слишком длинный и состоит
+// из печатных символов ASCII без пробелов.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// entries разбирает вывод логгера в формате JSON.
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var res []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var e map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &e))
+		res = append(res, e)
+	}
+
+	return res
+}
+
+func TestLoggerMdlw(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := logger.NewWithOutput(&buf, "debug", logger.FormatJSON)
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Info("handler")
+		w.WriteHeader(http.StatusCreated)
+	})
+	router.Use(LoggerMdlw(log), CookieMdlw("secret"))
+
+	tt := []struct {
+		name      string
+		requestID string
+		wantID    string
+	}{
+		{name: "Client request ID", requestID: "abc-123", wantID: "abc-123"},
+		{name: "Generated request ID", requestID: ""},
+		{name: "Invalid request ID", requestID: "bad id"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			r := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tc.requestID != "" {
+				r.Header.Set(RequestIDHeader, tc.requestID)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			requestID := w.Result().Header.Get(RequestIDHeader)
+			require.NotEmpty(t, requestID)
+			if tc.wantID != "" {
+				assert.Equal(t, tc.wantID, requestID)
+			} else {
+				assert.NotEqual(t, tc.requestID, requestID)
+			}
+
+			var handlerEntry, completed map[string]interface{}
+			for _, e := range entries(t, &buf) {
+				assert.Equal(t, requestID, e[logger.FieldRequestID], "every entry must have the request id")
+				switch e["msg"] {
+				case "handler":
+					handlerEntry = e
+				case "request completed":
+					completed = e
+				}
+			}
+			require.NotNil(t, handlerEntry)
+			require.NotNil(t, completed)
+			assert.NotEmpty(t, handlerEntry[logger.FieldUserID], "CookieMdlw must add user id to the request logger")
+			assert.Equal(t, float64(http.StatusCreated), completed["status"])
+			assert.Equal(t, "/test", completed["path"])
+		})
+	}
+}
+
+type userIDRequest struct{ userID string }
+
+func (r userIDRequest) GetUserId() string { return r.userID }
+
+func TestLoggerInterceptor(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := logger.NewWithOutput(&buf, "info", logger.FormatJSON)
+	require.NoError(t, err)
+
+	interceptor := LoggerInterceptor(log)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		logger.FromContext(ctx).Info("handler")
+
+		return "ok", nil
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDHeader, "req-1"))
+	_, err = interceptor(ctx, userIDRequest{userID: "user-1"}, &grpc.UnaryServerInfo{FullMethod: "/test/Method"}, handler)
+	require.NoError(t, err)
+
+	got := entries(t, &buf)
+	require.Len(t, got, 2)
+	for _, e := range got {
+		assert.Equal(t, "req-1", e[logger.FieldRequestID])
+		assert.Equal(t, "user-1", e[logger.FieldUserID])
+		assert.Equal(t, "/test/Method", e["method"])
+	}
+	assert.Equal(t, "OK", got[1]["code"])
+}
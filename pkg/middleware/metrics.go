@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// MetricsMdlw считает количество и время обработки HTTP-запросов. Запросы группируются по шаблону пути
+// маршрута (например, "/{id}"), а не по самому пути, чтобы количество временных рядов не зависело
+// от количества ключей. Middleware должна подключаться через Router.Use, чтобы маршрут был известен.
+func MetricsMdlw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// MetricsInterceptor - gRPC-интерсептор, считающий количество и время обработки вызовов. Так как сервер
+// передаёт ошибки обработки в поле error ответа, вызов с непустым полем error также считается ошибочным.
+func MetricsInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		failed := err != nil
+		if r, ok := resp.(interface{ GetError() string }); ok && r.GetError() != "" {
+			failed = true
+		}
+		metrics.GRPCRequests.WithLabelValues(info.FullMethod, status.Code(err).String(), strconv.FormatBool(failed)).Inc()
+		metrics.GRPCRequestDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+
+		return resp, err
+	}
+}
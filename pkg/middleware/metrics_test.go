@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricsMdlw(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}).Methods(http.MethodGet)
+	router.Use(MetricsMdlw)
+
+	counter := metrics.HTTPRequests.WithLabelValues("/{id}", http.MethodGet, "307")
+	before := testutil.ToFloat64(counter)
+	for _, key := range []string{"abc", "def"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/"+key, nil))
+	}
+	assert.Equal(t, before+2, testutil.ToFloat64(counter), "requests must be counted by route template")
+}
+
+type errorResponse struct{ err string }
+
+func (r errorResponse) GetError() string { return r.err }
+
+func TestMetricsInterceptor(t *testing.T) {
+	interceptor := MetricsInterceptor()
+	tt := []struct {
+		name   string
+		resp   interface{}
+		err    error
+		code   string
+		failed string
+	}{
+		{name: "OK", resp: errorResponse{}, code: "OK", failed: "false"},
+		{name: "Error in response", resp: errorResponse{err: "not found"}, code: "OK", failed: "true"},
+		{name: "Status error", err: status.Error(codes.ResourceExhausted, "slow down"), code: "ResourceExhausted", failed: "true"},
+		{name: "Unknown error", err: errors.New("boom"), code: "Unknown", failed: "true"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			const method = "/test/Metrics"
+			counter := metrics.GRPCRequests.WithLabelValues(method, tc.code, tc.failed)
+			before := testutil.ToFloat64(counter)
+			_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					return tc.resp, tc.err
+				})
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, before+1, testutil.ToFloat64(counter))
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// sweepInterval - периодичность удаления из памяти давно не обращавшихся клиентов.
+const sweepInterval = time.Minute
+
+type (
+	// RateLimiter ограничивает частоту запросов от каждого клиента по алгоритму token bucket: у клиента есть
+	// "корзина" ёмкостью burst запросов, которая пополняется со скоростью rps запросов в секунду.
+	RateLimiter struct {
+		sync.Mutex
+		limit   rate.Limit
+		burst   int
+		idle    time.Duration
+		clients map[string]*client
+
+		lastSweep time.Time
+	}
+
+	// client - корзина одного клиента.
+	client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+)
+
+// NewRateLimiter создаёт ограничитель частоты запросов: в среднем не более rps запросов в секунду
+// и не более burst запросов подряд. Если rps <= 0, ограничение отключено и возвращается nil;
+// middleware и интерсептор пропускают все запросы при нулевом ограничителе.
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimiter{
+		limit: rate.Limit(rps),
+		burst: burst,
+		// за это время корзина заполняется полностью, и клиента можно забыть без потери состояния.
+		idle:      time.Duration(float64(burst) / rps * float64(time.Second)),
+		clients:   make(map[string]*client),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow расходует один запрос из корзины клиента key. Если корзина пуста, возвращается false
+// и время, через которое запрос будет разрешён.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+
+	l.sweep(now)
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+	r := c.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// sweep удаляет клиентов, не обращавшихся дольше, чем требуется для заполнения корзины.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	for key, c := range l.clients {
+		if now.Sub(c.lastSeen) > l.idle {
+			delete(l.clients, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// RateLimitMdlw ограничивает частоту запросов от одного клиента. Клиент определяется по IP-адресу соединения;
+// заголовок X-Real-IP учитывается, только если соединение установлено доверенным прокси из подсети trustedProxy
+// (пустая строка - сервис работает без прокси, и заголовок, который клиент может подставить сам, игнорируется).
+// Сессии CookieMdlw для учёта не используются: новую сессию может получить любой клиент, поэтому смена куки
+// позволяла бы обойти ограничение. При превышении ограничения возвращается 429 с заголовком Retry-After.
+func RateLimitMdlw(l *RateLimiter, trustedProxy string) mux.MiddlewareFunc {
+	proxy := ParseTrustedProxy(trustedProxy)
+
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ip:" + ClientIP(r, proxy)
+			if ok, retryAfter := l.Allow(key); !ok {
+				logger.FromContext(r.Context()).Warnf("RateLimitMdlw: too many requests from %s to %s", key, r.URL.Path)
+				w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitInterceptor - gRPC-интерсептор, ограничивающий частоту вызовов методов от одного клиента.
+// limiters сопоставляет полное имя метода (например, "/proto.shortener/ShortenURL") с ограничителем;
+// вызовы методов, которых нет в limiters, не ограничиваются. Клиент определяется по IP-адресу соединения.
+// Идентификатор пользователя для учёта не используется: AuthInterceptor выдаёт новые учётные данные любому
+// клиенту, который их не передал, поэтому смена пользователя позволяла бы обойти ограничение.
+// При превышении ограничения возвращается код ResourceExhausted и заголовок retry-after.
+func RateLimitInterceptor(limiters map[string]*RateLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		l := limiters[info.FullMethod]
+		if l == nil {
+			return handler(ctx, req)
+		}
+		key := peerKey(ctx)
+		if ok, retryAfter := l.Allow(key); !ok {
+			logger.FromContext(ctx).Warnf("RateLimitInterceptor: too many requests from %s to %s", key, info.FullMethod)
+			// nolint:errcheck
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfterSeconds(retryAfter)))
+
+			return nil, status.Errorf(codes.ResourceExhausted, "too many requests, retry after %s", retryAfter.Round(time.Millisecond))
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// peerKey возвращает идентификатор клиента gRPC для ограничителя - IP-адрес соединения.
+func peerKey(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return "ip:" + hostOnly(p.Addr.String())
+	}
+
+	return "ip:unknown"
+}
+
+// ParseTrustedProxy разбирает подсеть доверенного прокси, заданную в формате CIDR. Для пустой строки
+// возвращается nil - сервис работает без прокси. Подсеть проверяется config.Validate, поэтому при ошибке
+// разбора также возвращается nil, и заголовок X-Real-IP не учитывается.
+func ParseTrustedProxy(cidr string) *net.IPNet {
+	if cidr == "" {
+		return nil
+	}
+	_, proxy, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil
+	}
+
+	return proxy
+}
+
+// ClientIP возвращает IP-адрес клиента HTTP-запроса: адрес соединения или, если соединение установлено
+// доверенным прокси из подсети proxy, значение заголовка X-Real-IP.
+func ClientIP(r *http.Request, proxy *net.IPNet) string {
+	ip := hostOnly(r.RemoteAddr)
+	if proxy != nil && proxy.Contains(net.ParseIP(ip)) {
+		if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+			return realIP.String()
+		}
+	}
+
+	return ip
+}
+
+// hostOnly отбрасывает порт из адреса вида host:port.
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
+
+// retryAfterSeconds округляет задержку вверх до целого числа секунд для заголовка Retry-After.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
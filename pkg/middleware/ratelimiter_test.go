@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appContext "github.com/vanamelnik/go-musthave-shortener/internal/app/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, NewRateLimiter(0, 10), "zero rate disables the limiter")
+
+	l := NewRateLimiter(1, 3)
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		require.True(t, ok, "request #%d", i+1)
+	}
+	ok, retryAfter := l.Allow("a")
+	assert.False(t, ok)
+	assert.InDelta(t, time.Second, retryAfter, float64(100*time.Millisecond))
+	ok, _ = l.Allow("b")
+	assert.True(t, ok, "clients must have separate buckets")
+
+	// заполненные корзины давно не обращавшихся клиентов удаляются
+	l.sweep(time.Now().Add(sweepInterval + 4*time.Second))
+	assert.Empty(t, l.clients)
+}
+
+func TestRateLimitMdlw(t *testing.T) {
+	router := mux.NewRouter()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Handle("/limited", RateLimitMdlw(NewRateLimiter(1, 2), "")(ok))
+	router.Handle("/proxied", RateLimitMdlw(NewRateLimiter(1, 2), "10.0.0.0/8")(ok))
+	router.Handle("/unlimited", RateLimitMdlw(NewRateLimiter(0, 0), "")(ok))
+	router.Use(CookieMdlw("secret"))
+
+	get := func(target, remoteAddr, realIP string, cookies []*http.Cookie) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Real-IP", realIP)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		w.Result().Body.Close()
+
+		return w.Result()
+	}
+
+	// ни подменённый заголовок X-Real-IP, ни новая сессия не дают клиенту новую корзину
+	res := get("/limited", "192.0.2.1:1234", "198.51.100.1", nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	session := res.Cookies()
+	res = get("/limited", "192.0.2.1:1234", "198.51.100.2", session)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res = get("/limited", "192.0.2.1:1234", "198.51.100.3", nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "1", res.Header.Get("Retry-After"))
+
+	// у клиента с другим адресом своя корзина
+	res = get("/limited", "192.0.2.2:1234", "", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// за доверенным прокси клиенты различаются по X-Real-IP
+	for i := 0; i < 2; i++ {
+		res = get("/proxied", "10.0.0.1:1234", "198.51.100.1", nil)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+	res = get("/proxied", "10.0.0.1:1234", "198.51.100.1", nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	res = get("/proxied", "10.0.0.1:1234", "198.51.100.2", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	// а запросы не от прокси - по адресу соединения
+	for i := 0; i < 2; i++ {
+		res = get("/proxied", "192.0.2.3:1234", "198.51.100.3", nil)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+	res = get("/proxied", "192.0.2.3:1234", "198.51.100.4", nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+
+	for i := 0; i < 5; i++ {
+		res = get("/unlimited", "192.0.2.1:1234", "", nil)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	interceptor := RateLimitInterceptor(map[string]*RateLimiter{
+		"/test/Limited": NewRateLimiter(1, 1),
+	})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}})
+	call := func(method string) error {
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+
+		return err
+	}
+
+	require.NoError(t, call("/test/Limited"))
+	err := call("/test/Limited")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, call("/test/Unlimited"))
+	}
+
+	// учётные данные не влияют на учёт: и новый, и ранее выданный пользователь с того же адреса
+	// расходуют общую корзину
+	id := uuid.New()
+	authCtx := metadata.NewIncomingContext(appContext.WithID(ctx, id), metadata.Pairs(UserIDMetadataKey, id.String()))
+	_, err = interceptor(authCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Limited"}, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = interceptor(appContext.WithID(ctx, uuid.New()), nil, &grpc.UnaryServerInfo{FullMethod: "/test/Limited"}, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// клиенты с других адресов учитываются отдельно
+	otherCtx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 5000}})
+	_, err = interceptor(otherCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Limited"}, handler)
+	assert.NoError(t, err)
+}
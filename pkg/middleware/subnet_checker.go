@@ -1,11 +1,11 @@
 package middleware
 
 import (
-	"log"
 	"net"
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/vanamelnik/go-musthave-shortener/internal/app/logger"
 )
 
 // SubnetCheckerMdlw проверяет IP-адрес клиента и пропускает запрос только в случае, если
@@ -13,8 +13,9 @@ import (
 func SubnetCheckerMdlw(trustedSubnet string) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.FromContext(r.Context())
 			if trustedSubnet == "" {
-				log.Println("subnetCheckerMdlw: trusted subnet is not defined")
+				log.Warn("subnetCheckerMdlw: trusted subnet is not defined")
 				http.Error(w, "Forbidden", http.StatusForbidden)
 
 				return
@@ -22,7 +23,7 @@ func SubnetCheckerMdlw(trustedSubnet string) mux.MiddlewareFunc {
 			}
 			ipStr := r.Header.Get("X-Real-IP")
 			if ipStr == "" {
-				log.Println("subnetCheckerMdlw: no 'X-Real-IP' key in the header")
+				log.Warn("subnetCheckerMdlw: no 'X-Real-IP' key in the header")
 				http.Error(w, "Bad request", http.StatusBadRequest)
 
 				return
@@ -30,13 +31,13 @@ func SubnetCheckerMdlw(trustedSubnet string) mux.MiddlewareFunc {
 			ip := net.ParseIP(ipStr)
 			_, subnet, err := net.ParseCIDR(trustedSubnet)
 			if err != nil {
-				log.Println("subnetCheckerMdlw: unreachable error: could not parse trusted_subnet: ", err)
+				log.WithError(err).Error("subnetCheckerMdlw: unreachable error: could not parse trusted_subnet")
 				http.Error(w, "Something went wrong", http.StatusInternalServerError)
 
 				return
 			}
 			if !subnet.Contains(ip) {
-				log.Printf("subnetCheckerMdlw: ip address %s is not in the trusted subnet %s", ipStr, trustedSubnet)
+				log.Warnf("subnetCheckerMdlw: ip address %s is not in the trusted subnet %s", ipStr, trustedSubnet)
 				http.Error(w, "Forbidden", http.StatusForbidden)
 
 				return
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Идентификаторы трассы и спана клиента.
+const (
+	clientTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	clientSpanID  = "00f067aa0ba902b7"
+	traceparent   = "00-" + clientTraceID + "-" + clientSpanID + "-01"
+)
+
+// setupTracing устанавливает провайдер трассировки, записывающий спаны в память.
+func setupTracing(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	return rec
+}
+
+func TestTracingMdlw(t *testing.T) {
+	rec := setupTracing(t)
+	var handlerSpan trace.SpanContext
+	router := mux.NewRouter()
+	router.HandleFunc("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	}).Methods(http.MethodGet)
+	router.Use(TracingMdlw)
+
+	r := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	r.Header.Set("traceparent", traceparent)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := rec.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "GET /{id}", span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	assert.Equal(t, clientTraceID, span.SpanContext().TraceID().String(), "client trace must be continued")
+	assert.Equal(t, clientSpanID, span.Parent().SpanID().String())
+	assert.Equal(t, span.SpanContext(), handlerSpan, "handler must get the request span in the context")
+	assert.Equal(t, "Error", span.Status().Code.String())
+}
+
+func TestTracingInterceptor(t *testing.T) {
+	rec := setupTracing(t)
+	interceptor := TracingInterceptor()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", traceparent))
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Method"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return errorResponse{err: "not found"}, nil
+		})
+	require.NoError(t, err)
+
+	spans := rec.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "/test/Method", span.Name())
+	assert.Equal(t, clientTraceID, span.SpanContext().TraceID().String())
+	attrs := make(map[string]string)
+	for _, kv := range span.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	assert.Equal(t, "not found", attrs["app.error"])
+}